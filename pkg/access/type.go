@@ -9,13 +9,18 @@ type Role string
 
 // access constants
 const (
-	XHasuraRole             = "x-hasura-role"
-	XHasuraUserEmail        = "x-hasura-user-email"
-	XHasuraUserID           = "x-hasura-user-id"
-	XHasuraCurrentTime      = "x-hasura-current-time"
-	RoleAnonymous      Role = "anonymous"
-	RoleAdmin          Role = "admin"
-	RoleUser           Role = "user"
+	XHasuraRole                = "x-hasura-role"
+	XHasuraUserEmail           = "x-hasura-user-email"
+	XHasuraUserID              = "x-hasura-user-id"
+	XHasuraCurrentTime         = "x-hasura-current-time"
+	XHasuraSessionID           = "x-hasura-session-id"
+	XHasuraAPIKeyID            = "x-hasura-api-key-id"
+	XHasuraImpersonatorID      = "x-hasura-impersonator-id"
+	RoleAnonymous         Role = "anonymous"
+	RoleAdmin             Role = "admin"
+	RoleUser              Role = "user"
+	// RoleUnverified is the restricted role of accounts which haven't verified the email
+	RoleUnverified Role = "unverified"
 	// RoleModerator      Role = "moderator"
 )
 
@@ -28,6 +33,7 @@ func GetRoles() []string {
 		string(RoleAnonymous),
 		string(RoleAdmin),
 		string(RoleUser),
+		string(RoleUnverified),
 	}
 }
 
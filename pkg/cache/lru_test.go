@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUEviction(t *testing.T) {
+	c := NewLRU(2, time.Minute)
+	c.Set("a", 1, 0)
+	c.Set("b", 2, 0)
+
+	// touch a so that b is the least recently used item
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+
+	c.Set("c", 3, 0)
+	assert.Equal(t, 2, c.Len())
+
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+
+	value, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+}
+
+func TestLRUExpiry(t *testing.T) {
+	c := NewLRU(10, time.Minute)
+	c.Set("a", 1, time.Millisecond)
+	c.Set("b", 2, 0)
+
+	time.Sleep(5 * time.Millisecond)
+
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+
+	_, ok = c.Get("b")
+	assert.True(t, ok)
+}
+
+func TestLRUDeleteFunc(t *testing.T) {
+	c := NewLRU(10, time.Minute)
+	c.Set("user:1", 1, 0)
+	c.Set("user:2", 2, 0)
+	c.Set("admin:1", 3, 0)
+
+	count := c.DeleteFunc(func(key string, value interface{}) bool {
+		return strings.HasPrefix(key, "user:")
+	})
+	assert.Equal(t, 2, count)
+	assert.Equal(t, 1, c.Len())
+
+	c.Delete("admin:1")
+	assert.Equal(t, 0, c.Len())
+}
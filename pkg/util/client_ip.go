@@ -0,0 +1,90 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies resolves the client IP address from the X-Forwarded-For entries
+// which the proxies in front of the service appended. Entries on the left of the list
+// and the X-Real-Ip header are sent by the client, so they can't be trusted
+type TrustedProxies struct {
+	count    int
+	networks []*net.IPNet
+}
+
+// NewTrustedProxies create the resolver of the number of proxies which append to X-Forwarded-For.
+// Entries of the networks are skipped too, e.g. internal load balancers.
+// If count is zero, forwarded headers are ignored and the client IP is unknown
+func NewTrustedProxies(count int, cidrs []string) (*TrustedProxies, error) {
+	if count < 0 {
+		return nil, fmt.Errorf("invalid trusted proxy count: %d", count)
+	}
+
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy network %s: %s", cidr, err)
+		}
+		networks[i] = network
+	}
+
+	return &TrustedProxies{
+		count:    count,
+		networks: networks,
+	}, nil
+}
+
+// ClientIP return the right-most X-Forwarded-For entry which isn't a trusted proxy,
+// after the entries that the trusted proxies appended. It returns an empty string if the address is unknown
+func (tp *TrustedProxies) ClientIP(header http.Header) string {
+	if tp == nil || tp.count == 0 {
+		return ""
+	}
+
+	var hops []string
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseForwardedIP(hops[i])
+		if ip == nil {
+			return ""
+		}
+		if len(hops)-i < tp.count || tp.isTrusted(ip) {
+			continue
+		}
+		return ip.String()
+	}
+
+	return ""
+}
+
+func (tp *TrustedProxies) isTrusted(ip net.IP) bool {
+	for _, network := range tp.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwardedIP parse the address of a forwarded entry which may include the port
+func parseForwardedIP(value string) net.IP {
+	if ip := net.ParseIP(value); ip != nil {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(value)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
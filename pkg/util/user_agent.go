@@ -0,0 +1,102 @@
+package util
+
+import (
+	"regexp"
+	"strings"
+)
+
+// device types of user agents
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+	DeviceUnknown = "unknown"
+)
+
+// UserAgent is the client software and device parsed from the User-Agent header
+type UserAgent struct {
+	Browser        string `json:"browser"`
+	BrowserVersion string `json:"browser_version"`
+	OS             string `json:"os"`
+	DeviceType     string `json:"device_type"`
+}
+
+type userAgentPattern struct {
+	name  string
+	regex *regexp.Regexp
+}
+
+// browsers are checked in order because most of them mimic the tokens of Chrome and Safari
+var browserPatterns = []userAgentPattern{
+	{"Edge", regexp.MustCompile(`(?i)\b(?:edge|edg|edga|edgios)/([\d.]+)`)},
+	{"Opera", regexp.MustCompile(`(?i)\b(?:opr|opera)[/\s]([\d.]+)`)},
+	{"Samsung Internet", regexp.MustCompile(`(?i)\bsamsungbrowser/([\d.]+)`)},
+	{"Chrome", regexp.MustCompile(`(?i)\b(?:chrome|crios)/([\d.]+)`)},
+	{"Firefox", regexp.MustCompile(`(?i)\b(?:firefox|fxios)/([\d.]+)`)},
+	{"Safari", regexp.MustCompile(`(?i)\bversion/([\d.]+).*\bsafari/`)},
+	{"Internet Explorer", regexp.MustCompile(`(?i)(?:\bmsie\s|\btrident/.*\brv:)([\d.]+)`)},
+}
+
+var osPatterns = []userAgentPattern{
+	{"Windows Phone", regexp.MustCompile(`(?i)windows phone`)},
+	{"Windows", regexp.MustCompile(`(?i)windows`)},
+	{"iOS", regexp.MustCompile(`(?i)iphone|ipad|ipod`)},
+	{"Android", regexp.MustCompile(`(?i)android`)},
+	{"macOS", regexp.MustCompile(`(?i)mac os x|macintosh`)},
+	{"Chrome OS", regexp.MustCompile(`(?i)\bcros\b`)},
+	{"Linux", regexp.MustCompile(`(?i)linux|x11`)},
+}
+
+var (
+	botRegex    = regexp.MustCompile(`(?i)bot\b|crawl|spider|slurp|curl/|wget/|python-requests|headless`)
+	tabletRegex = regexp.MustCompile(`(?i)ipad|tablet|kindle|silk/|playbook`)
+	mobileRegex = regexp.MustCompile(`(?i)mobile|iphone|ipod|android|windows phone|opera mini`)
+)
+
+// ParseUserAgent detect the browser, operating system and device type of the user agent.
+// Unknown values are left empty, except the device type which is unknown
+func ParseUserAgent(userAgent string) UserAgent {
+	result := UserAgent{
+		DeviceType: DeviceUnknown,
+	}
+	if strings.TrimSpace(userAgent) == "" {
+		return result
+	}
+
+	for _, pattern := range browserPatterns {
+		if matches := pattern.regex.FindStringSubmatch(userAgent); len(matches) > 1 {
+			result.Browser = pattern.name
+			result.BrowserVersion = matches[1]
+			break
+		}
+	}
+
+	for _, pattern := range osPatterns {
+		if pattern.regex.MatchString(userAgent) {
+			result.OS = pattern.name
+			break
+		}
+	}
+
+	switch {
+	case botRegex.MatchString(userAgent):
+		result.DeviceType = DeviceBot
+	case tabletRegex.MatchString(userAgent),
+		// Android tablets don't have the Mobile token
+		result.OS == "Android" && !strings.Contains(strings.ToLower(userAgent), "mobile"):
+		result.DeviceType = DeviceTablet
+	case mobileRegex.MatchString(userAgent):
+		result.DeviceType = DeviceMobile
+	case result.OS != "":
+		result.DeviceType = DeviceDesktop
+	}
+
+	return result
+}
+
+// IsWebBrowserAgent checks if the user agent is from web browser
+func IsWebBrowserAgent(userAgent string) bool {
+	ua := ParseUserAgent(userAgent)
+	return ua.Browser != "" && ua.DeviceType != DeviceBot
+}
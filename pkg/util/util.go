@@ -23,7 +23,6 @@ const (
 )
 
 var emailRegex = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
-var webBrowserRegex = regexp.MustCompile(`(?i)(opera|chrome|safari|firefox|msie|trident)[/\s]([\d.]+)`)
 
 var src = rand.NewSource(time.Now().UnixNano())
 
@@ -145,10 +144,12 @@ func DurationToMilliseconds(d time.Duration) float64 {
 
 // GetRequestIP gets a requests IP address by reading off the forwarded-for
 // header (for proxies) and falls back to use the remote address.
+// The forwarded-for header may contain a list of proxies, the first one is the client.
+// The headers are set by the client, so use TrustedProxies for rate limits and other security decisions
 func GetRequestIP(r *http.Request) string {
-	ip := r.Header.Get("X-Real-Ip")
+	ip := strings.TrimSpace(r.Header.Get("X-Real-Ip"))
 	if ip == "" {
-		ip = r.Header.Get("X-Forwarded-For")
+		ip = strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-For"), ",")[0])
 	}
 	if ip != "" {
 		return ip
@@ -204,12 +205,3 @@ func GetFirstStringInMap(input map[string]string) string {
 	}
 	return ""
 }
-
-// IsWebBrowserAgent checks if the user agent is from web browser
-func IsWebBrowserAgent(userAgent string) bool {
-	if userAgent == "" {
-		return false
-	}
-
-	return webBrowserRegex.MatchString(userAgent)
-}
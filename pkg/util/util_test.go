@@ -1,6 +1,7 @@
 package util
 
 import (
+	"net/http"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -47,10 +48,122 @@ func TestGetFirstStringInMap(t *testing.T) {
 	}))
 }
 
+func TestGetRequestIP(t *testing.T) {
+	for _, fixture := range []struct {
+		Headers    map[string]string
+		RemoteAddr string
+		Expected   string
+	}{
+		{map[string]string{"X-Real-Ip": "10.0.0.1", "X-Forwarded-For": "10.0.0.2"}, "", "10.0.0.1"},
+		{map[string]string{"X-Forwarded-For": "203.0.113.1, 10.0.0.2, 10.0.0.3"}, "", "203.0.113.1"},
+		{map[string]string{}, "127.0.0.1:1234", "127.0.0.1:1234"},
+	} {
+		r := &http.Request{Header: http.Header{}, RemoteAddr: fixture.RemoteAddr}
+		for k, v := range fixture.Headers {
+			r.Header.Set(k, v)
+		}
+		assert.Equal(t, fixture.Expected, GetRequestIP(r), fixture.Headers)
+	}
+}
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	oneProxy, err := NewTrustedProxies(1, nil)
+	assert.Nil(t, err)
+	internal, err := NewTrustedProxies(1, []string{"10.0.0.0/8"})
+	assert.Nil(t, err)
+	twoProxies, err := NewTrustedProxies(2, nil)
+	assert.Nil(t, err)
+	disabled, err := NewTrustedProxies(0, nil)
+	assert.Nil(t, err)
+
+	for i, fixture := range []struct {
+		Proxies  *TrustedProxies
+		Headers  map[string][]string
+		Expected string
+	}{
+		{oneProxy, map[string][]string{"X-Forwarded-For": {"203.0.113.1"}}, "203.0.113.1"},
+		// spoofed entries on the left are ignored
+		{oneProxy, map[string][]string{"X-Forwarded-For": {"198.51.100.7, 203.0.113.1"}}, "203.0.113.1"},
+		{oneProxy, map[string][]string{"X-Forwarded-For": {"198.51.100.7", "203.0.113.1"}}, "203.0.113.1"},
+		{oneProxy, map[string][]string{"X-Real-Ip": {"198.51.100.7"}, "X-Forwarded-For": {"203.0.113.1"}}, "203.0.113.1"},
+		{oneProxy, map[string][]string{"X-Real-Ip": {"198.51.100.7"}}, ""},
+		{oneProxy, map[string][]string{"X-Forwarded-For": {"203.0.113.1:4321"}}, "203.0.113.1"},
+		{oneProxy, map[string][]string{"X-Forwarded-For": {"198.51.100.7, not-an-ip"}}, ""},
+		{internal, map[string][]string{"X-Forwarded-For": {"198.51.100.7, 203.0.113.1, 10.1.2.3"}}, "203.0.113.1"},
+		{twoProxies, map[string][]string{"X-Forwarded-For": {"198.51.100.7, 203.0.113.1, 10.1.2.3"}}, "203.0.113.1"},
+		{twoProxies, map[string][]string{"X-Forwarded-For": {"10.1.2.3"}}, ""},
+		{disabled, map[string][]string{"X-Forwarded-For": {"203.0.113.1"}}, ""},
+		{nil, map[string][]string{"X-Forwarded-For": {"203.0.113.1"}}, ""},
+	} {
+		header := http.Header{}
+		for k, values := range fixture.Headers {
+			for _, v := range values {
+				header.Add(k, v)
+			}
+		}
+		assert.Equal(t, fixture.Expected, fixture.Proxies.ClientIP(header), "fixture %d", i)
+	}
+
+	_, err = NewTrustedProxies(1, []string{"10.0.0.0"})
+	assert.NotNil(t, err)
+	_, err = NewTrustedProxies(-1, nil)
+	assert.NotNil(t, err)
+}
+
 func TestIsWebBrowserAgent(t *testing.T) {
 	assert.False(t, IsWebBrowserAgent(""))
 	assert.True(t, IsWebBrowserAgent("Mozilla/5.0 (Macintosh; Intel Mac OS X 10_14_6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/77.0.3865.90 Safari/537.36"))
 	assert.True(t, IsWebBrowserAgent("Mozilla/5.0 (Android; Mobile; rv:13.0) Gecko/13.0 Firefox/13.0"))
 	assert.True(t, IsWebBrowserAgent("Mozilla/5.0 (Windows Phone 10.0; Android 6.0.1; Xbox; Xbox One) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/58.0.3029.110 Mobile Safari/537.36 Edge/16.16299"))
+	assert.False(t, IsWebBrowserAgent("okhttp/4.9.0"))
+	assert.False(t, IsWebBrowserAgent("Mozilla/5.0 (Linux; Android 6.0.1; Nexus 5X Build/MMB29P) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/99.0.4844.84 Mobile Safari/537.36 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"))
+}
 
+func TestParseUserAgent(t *testing.T) {
+	for _, fixture := range []struct {
+		UserAgent string
+		Expected  UserAgent
+	}{
+		{"", UserAgent{DeviceType: DeviceUnknown}},
+		{"okhttp/4.9.0", UserAgent{DeviceType: DeviceUnknown}},
+		{
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_14_6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/77.0.3865.90 Safari/537.36",
+			UserAgent{Browser: "Chrome", BrowserVersion: "77.0.3865.90", OS: "macOS", DeviceType: DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/106.0.0.0 Safari/537.36 Edg/106.0.1370.47",
+			UserAgent{Browser: "Edge", BrowserVersion: "106.0.1370.47", OS: "Windows", DeviceType: DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:105.0) Gecko/20100101 Firefox/105.0",
+			UserAgent{Browser: "Firefox", BrowserVersion: "105.0", OS: "Linux", DeviceType: DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 16_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.0 Mobile/15E148 Safari/604.1",
+			UserAgent{Browser: "Safari", BrowserVersion: "16.0", OS: "iOS", DeviceType: DeviceMobile},
+		},
+		{
+			"Mozilla/5.0 (iPad; CPU OS 15_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/106.0.5249.92 Mobile/15E148 Safari/604.1",
+			UserAgent{Browser: "Chrome", BrowserVersion: "106.0.5249.92", OS: "iOS", DeviceType: DeviceTablet},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 12; SM-S906N) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/18.0 Chrome/99.0.4844.88 Mobile Safari/537.36",
+			UserAgent{Browser: "Samsung Internet", BrowserVersion: "18.0", OS: "Android", DeviceType: DeviceMobile},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 11; SM-T870) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/106.0.0.0 Safari/537.36 OPR/71.0.2254.68",
+			UserAgent{Browser: "Opera", BrowserVersion: "71.0.2254.68", OS: "Android", DeviceType: DeviceTablet},
+		},
+		{
+			"Mozilla/5.0 (Windows NT 6.1; WOW64; Trident/7.0; rv:11.0) like Gecko",
+			UserAgent{Browser: "Internet Explorer", BrowserVersion: "11.0", OS: "Windows", DeviceType: DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)",
+			UserAgent{DeviceType: DeviceBot},
+		},
+		{"curl/7.79.1", UserAgent{DeviceType: DeviceBot}},
+	} {
+		assert.Equal(t, fixture.Expected, ParseUserAgent(fixture.UserAgent), fixture.UserAgent)
+	}
 }
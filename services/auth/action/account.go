@@ -4,8 +4,11 @@ import (
 	"context"
 	"encoding/json"
 
-	"github.com/google/uuid"
+	"nexlab.tech/core/pkg/access"
 	"nexlab.tech/core/pkg/util"
+	"nexlab.tech/core/services/auth/audit"
+	"nexlab.tech/core/services/auth/password"
+	"nexlab.tech/core/services/auth/utils"
 )
 
 const (
@@ -18,10 +21,9 @@ type account_set_input map[string]interface{}
 type account_pk_columns_input map[string]interface{}
 
 type CreateAccountInput struct {
-	FullName string `json:fullName`
+	FullName string `json:"fullName"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
-	Role     string `json:"role"`
 }
 
 // createAccount create or assign user to specific app
@@ -36,14 +38,15 @@ func createAccount(ctx *actionContext, payload []byte) (interface{}, error) {
 		return nil, util.ErrBadRequest(err)
 	}
 
-	passwordHashed, err := ctx.JwtAuth.EncryptPassword(appInput.Data.Password)
+	err = ctx.Passwords.Validate(appInput.Data.Password, password.Identity{
+		Email:    appInput.Data.Email,
+		FullName: appInput.Data.FullName,
+	})
 	if err != nil {
-		return nil, util.ErrBadRequest(err)
+		return nil, err
 	}
 
-	randomUUID := uuid.New().String()
-	randomHashed, err := ctx.JwtAuth.EncryptPassword(randomUUID)
-
+	passwordHashed, err := ctx.JwtAuth.HashPassword(appInput.Data.Password)
 	if err != nil {
 		return nil, util.ErrBadRequest(err)
 	}
@@ -58,14 +61,20 @@ func createAccount(ctx *actionContext, payload []byte) (interface{}, error) {
 		} `graphql:"insert_account_one(object: $object)"`
 	}
 
+	status := utils.AccountStatusActive
+	if ctx.Env.AccountApprovalRequired {
+		status = utils.AccountStatusPending
+	}
+
+	// self-registered accounts are users, other roles are granted by admins with updateAccountRole
 	variables := map[string]interface{}{
 		"object": account_insert_input{
-			"email":      appInput.Data.Email,
-			"password":   string(passwordHashed),
-			"fullName":   appInput.Data.FullName,
-			"role":       appInput.Data.Role,
-			"randomHash": randomHashed,
-			"loginType":  defaultAccount,
+			"email":     appInput.Data.Email,
+			"password":  passwordHashed,
+			"fullName":  appInput.Data.FullName,
+			"role":      string(access.RoleUser),
+			"loginType": defaultAccount,
+			"status":    status,
 		},
 	}
 
@@ -75,7 +84,22 @@ func createAccount(ctx *actionContext, payload []byte) (interface{}, error) {
 		return nil, util.ErrBadRequest(err)
 	}
 
-	token, err := ctx.JwtAuth.EncodeToken(query.CreateAccount.ID)
+	ctx.AuditTarget(audit.TargetAccount, query.CreateAccount.ID)
+
+	// the account is usable with the unverified policy if the email can't be sent, and the user can resend it later
+	err = sendVerificationEmail(ctx, query.CreateAccount.ID, query.CreateAccount.Email, query.CreateAccount.FullName)
+	if err != nil {
+		ctx.Logger.WithError(err).WithField("account_id", query.CreateAccount.ID).Error("failed to send verification email")
+	}
+
+	// pending accounts can't sign in until they are approved
+	if status == utils.AccountStatusPending {
+		return map[string]string{
+			"id": query.CreateAccount.ID,
+		}, nil
+	}
+
+	token, err := ctx.JwtAuth.EncodeToken(query.CreateAccount.ID, ctx.SessionInfo())
 
 	if err != nil {
 		return nil, err
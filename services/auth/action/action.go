@@ -8,18 +8,62 @@ import (
 // New create action router instance
 func New(hc Config) (*action.Router, error) {
 
-	actions, err := action.New(map[action.ActionName]action.Action{
-		actionCreateAccount:       hc.wrap(createAccount),
-		actionAdminChangePassword: hc.wrap(changeAccountPassword),
-		actionLogin:               hc.wrap(login),
-		actionRefreshToken:        hc.wrap(refreshToken),
-		actionForgotPassword:      hc.wrap(forgotPassword),
-		actionUploadFile:          hc.wrap(uploadFile),
-		actionMoveFile:            hc.wrap(moveFile),
-		actionUpdateFile:          hc.wrap(updateFile),
-		actionShareFile:           hc.wrap(shareFile),
-	})
+	handlers := map[action.ActionName]actionHandler{
+		actionCreateAccount:              createAccount,
+		actionAdminChangePassword:        changeAccountPassword,
+		actionChangePassword:             changePassword,
+		actionLogin:                      login,
+		actionRefreshToken:               refreshToken,
+		actionLogout:                     logout,
+		actionLogoutAll:                  logoutAll,
+		actionForgotPassword:             forgotPassword,
+		actionResetPassword:              resetPassword,
+		actionUploadFile:                 uploadFile,
+		actionMoveFile:                   moveFile,
+		actionUpdateFile:                 updateFile,
+		actionShareFile:                  shareFile,
+		actionRotateSigningKey:           rotateSigningKey,
+		actionVerifyEmail:                verifyEmail,
+		actionResendVerification:         resendVerification,
+		actionEnrollTwoFactor:            enrollTwoFactor,
+		actionConfirmTwoFactor:           confirmTwoFactor,
+		actionVerifyMfa:                  verifyMfa,
+		actionResetTwoFactor:             resetTwoFactor,
+		actionUnlockAccount:              unlockAccount,
+		actionListAccounts:               listAccounts,
+		actionSearchAccounts:             searchAccounts,
+		actionUpdateAccountRole:          updateAccountRole,
+		actionSuspendAccount:             suspendAccount,
+		actionReactivateAccount:          reactivateAccount,
+		actionDeleteAccount:              deleteAccount,
+		actionCreateAPIKey:               createAPIKey,
+		actionListAPIKeys:                listAPIKeys,
+		actionRevokeAPIKey:               revokeAPIKey,
+		actionListAuditEvents:            listAuditEvents,
+		actionExportAuditEvents:          exportAuditEvents,
+		actionRequestAccountDeletion:     requestAccountDeletion,
+		actionCancelAccountDeletion:      cancelAccountDeletion,
+		actionExportMyData:               exportMyData,
+		actionImpersonate:                impersonate,
+		actionMySessions:                 mySessions,
+		actionRevokeSession:              revokeSession,
+		actionRequestMagicLink:           requestMagicLink,
+		actionConsumeMagicLink:           consumeMagicLink,
+		actionBeginWebauthnRegistration:  beginWebauthnRegistration,
+		actionFinishWebauthnRegistration: finishWebauthnRegistration,
+		actionBeginWebauthnLogin:         beginWebauthnLogin,
+		actionFinishWebauthnLogin:        finishWebauthnLogin,
+		actionMyWebauthnCredentials:      myWebauthnCredentials,
+		actionDeleteWebauthnCredential:   deleteWebauthnCredential,
+		actionResetWebauthnCredentials:   resetWebauthnCredentials,
+	}
+
+	routes := make(map[action.ActionName]action.Action)
+	for name, handler := range handlers {
+		routes[name] = hc.wrap(name, handler)
+	}
 
+	actions, err := action.New(routes)
 	if err != nil {
 		return nil, err
 	}
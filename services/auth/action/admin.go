@@ -0,0 +1,332 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/hgiasac/hasura-router/go/types"
+	"nexlab.tech/core/pkg/access"
+	"nexlab.tech/core/pkg/util"
+	"nexlab.tech/core/services/auth/audit"
+	"nexlab.tech/core/services/auth/utils"
+)
+
+const (
+	actionListAccounts      = "listAccounts"
+	actionSearchAccounts    = "searchAccounts"
+	actionUpdateAccountRole = "updateAccountRole"
+	actionSuspendAccount    = "suspendAccount"
+	actionReactivateAccount = "reactivateAccount"
+	actionDeleteAccount     = "deleteAccount"
+)
+
+// pagination limits of account lists
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+type account_order_by map[string]interface{}
+
+type search_accounts_args map[string]interface{}
+
+// accountSummary is the account information returned to admins, without credentials
+type accountSummary struct {
+	ID              string     `graphql:"id" json:"id"`
+	Email           string     `graphql:"email" json:"email"`
+	FullName        string     `graphql:"fullName" json:"fullName"`
+	Role            string     `graphql:"role" json:"role"`
+	Status          string     `graphql:"status" json:"status"`
+	LoginType       string     `graphql:"loginType" json:"loginType"`
+	EmailVerifiedAt *time.Time `graphql:"email_verified_at" json:"email_verified_at"`
+	TOTPEnabledAt   *time.Time `graphql:"totp_enabled_at" json:"totp_enabled_at"`
+	LockedUntil     *time.Time `graphql:"locked_until" json:"locked_until"`
+	CreatedAt       time.Time  `graphql:"created_at" json:"created_at"`
+	DeletedAt       *time.Time `graphql:"deleted_at" json:"deleted_at"`
+}
+
+type accountFilter struct {
+	Search    string `json:"search"`
+	Role      string `json:"role"`
+	Status    string `json:"status"`
+	LoginType string `json:"loginType"`
+}
+
+type accountPagination struct {
+	Page int `json:"page"`
+	Size int `json:"size"`
+}
+
+// normalize apply default values to out of range pagination
+func (p *accountPagination) normalize() {
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	if p.Size < 1 {
+		p.Size = defaultPageSize
+	}
+	if p.Size > maxPageSize {
+		p.Size = maxPageSize
+	}
+}
+
+// listAccounts filter and paginate accounts for admins.
+// Deleted accounts are hidden unless the deleted status is filtered explicitly
+func listAccounts(ctx *actionContext, payload []byte) (interface{}, error) {
+	if !ctx.Access.IsAdmin() {
+		return nil, util.ErrPermissionDenied(errors.New("only admin can list accounts"))
+	}
+
+	var input struct {
+		Data struct {
+			Filter     accountFilter     `json:"filter"`
+			Pagination accountPagination `json:"pagination"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	return queryAccounts(ctx, input.Data.Filter, input.Data.Pagination)
+}
+
+// searchAccounts find accounts by similar email or full name
+func searchAccounts(ctx *actionContext, payload []byte) (interface{}, error) {
+	if !ctx.Access.IsAdmin() {
+		return nil, util.ErrPermissionDenied(errors.New("only admin can search accounts"))
+	}
+
+	var input struct {
+		Data struct {
+			Search string `json:"search"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	if input.Data.Search == "" {
+		return nil, types.NewError("required:search", "search is required")
+	}
+
+	return queryAccounts(ctx, accountFilter{Search: input.Data.Search}, accountPagination{})
+}
+
+func queryAccounts(ctx *actionContext, filter accountFilter, pagination accountPagination) (interface{}, error) {
+	pagination.normalize()
+
+	where := account_bool_exp{}
+	if filter.Role != "" {
+		where["role"] = map[string]interface{}{
+			"_eq": filter.Role,
+		}
+	}
+	if filter.LoginType != "" {
+		where["loginType"] = map[string]interface{}{
+			"_eq": filter.LoginType,
+		}
+	}
+	if filter.Status != "" {
+		where["status"] = map[string]interface{}{
+			"_eq": filter.Status,
+		}
+	} else {
+		where["status"] = map[string]interface{}{
+			"_neq": utils.AccountStatusDeleted,
+		}
+	}
+
+	variables := map[string]interface{}{
+		"where":  where,
+		"limit":  graphql.Int(pagination.Size),
+		"offset": graphql.Int((pagination.Page - 1) * pagination.Size),
+	}
+
+	var accounts []accountSummary
+	var total int
+	if filter.Search == "" {
+		var query struct {
+			Accounts          []accountSummary `graphql:"account(where: $where, order_by: $order_by, limit: $limit, offset: $offset)"`
+			AccountsAggregate struct {
+				Aggregate struct {
+					Count int `graphql:"count"`
+				} `graphql:"aggregate"`
+			} `graphql:"account_aggregate(where: $where)"`
+		}
+
+		variables["order_by"] = []account_order_by{
+			{"created_at": "desc"},
+		}
+		err := ctx.Controller.Query(context.Background(), &query, variables, graphql.OperationName("ListAccounts"))
+		if err != nil {
+			return nil, err
+		}
+		accounts = query.Accounts
+		total = query.AccountsAggregate.Aggregate.Count
+	} else {
+		// search results keep the similarity order of the function
+		var query struct {
+			Accounts          []accountSummary `graphql:"search_accounts(args: $args, where: $where, limit: $limit, offset: $offset)"`
+			AccountsAggregate struct {
+				Aggregate struct {
+					Count int `graphql:"count"`
+				} `graphql:"aggregate"`
+			} `graphql:"search_accounts_aggregate(args: $args, where: $where)"`
+		}
+
+		variables["args"] = search_accounts_args{
+			"search": filter.Search,
+		}
+		err := ctx.Controller.Query(context.Background(), &query, variables, graphql.OperationName("SearchAccounts"))
+		if err != nil {
+			return nil, err
+		}
+		accounts = query.Accounts
+		total = query.AccountsAggregate.Aggregate.Count
+	}
+
+	if accounts == nil {
+		accounts = []accountSummary{}
+	}
+
+	return map[string]interface{}{
+		"page":     pagination.Page,
+		"size":     pagination.Size,
+		"total":    total,
+		"userList": accounts,
+	}, nil
+}
+
+// updateAccountRole change the role of an account.
+// Cached tokens are evicted so the new role applies to the next request
+func updateAccountRole(ctx *actionContext, payload []byte) (interface{}, error) {
+	if !ctx.Access.IsAdmin() {
+		return nil, util.ErrPermissionDenied(errors.New("only admin can update account roles"))
+	}
+
+	var input struct {
+		Data struct {
+			AccountID string `json:"account_id"`
+			Role      string `json:"role"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	if input.Data.AccountID == "" {
+		return nil, types.NewError("required:account_id", "account_id is required")
+	}
+
+	role, err := access.ParseRole(input.Data.Role)
+	if err != nil || role == access.RoleAnonymous || role == access.RoleUnverified {
+		return nil, types.NewError("invalid:role", "role must be an account role")
+	}
+
+	if input.Data.AccountID == ctx.Access.UserID {
+		return nil, util.ErrBadRequest(errors.New("admin can't change the own role"))
+	}
+
+	ctx.AuditTarget(audit.TargetAccount, input.Data.AccountID)
+	ctx.AuditMetadata("role", input.Data.Role)
+	id, err := updateAccountByAdmin(ctx, input.Data.AccountID, account_set_input{
+		"role": string(role),
+	}, "UpdateAccountRole")
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.JwtAuth.InvalidateAccountTokens(input.Data.AccountID)
+
+	return map[string]string{
+		"message": "success",
+		"id":      id,
+	}, nil
+}
+
+// suspendAccount disable the account and sign out all of its sessions
+func suspendAccount(ctx *actionContext, payload []byte) (interface{}, error) {
+	return changeAccountStatus(ctx, payload, utils.AccountStatusSuspended)
+}
+
+// reactivateAccount enable a suspended account, or approve a pending account
+func reactivateAccount(ctx *actionContext, payload []byte) (interface{}, error) {
+	return changeAccountStatus(ctx, payload, utils.AccountStatusActive)
+}
+
+// deleteAccount soft delete the account. The row is kept for references and audit
+func deleteAccount(ctx *actionContext, payload []byte) (interface{}, error) {
+	return changeAccountStatus(ctx, payload, utils.AccountStatusDeleted)
+}
+
+// changeAccountStatus move the input account to the status by admin
+func changeAccountStatus(ctx *actionContext, payload []byte, status string) (interface{}, error) {
+	if !ctx.Access.IsAdmin() {
+		return nil, util.ErrPermissionDenied(errors.New("only admin can change account status"))
+	}
+
+	var input struct {
+		Data struct {
+			AccountID string `json:"account_id"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	if input.Data.AccountID == "" {
+		return nil, types.NewError("required:account_id", "account_id is required")
+	}
+
+	if input.Data.AccountID == ctx.Access.UserID {
+		return nil, util.ErrBadRequest(errors.New("admin can't change the own status"))
+	}
+
+	ctx.AuditTarget(audit.TargetAccount, input.Data.AccountID)
+	count, err := ctx.JwtAuth.ChangeAccountStatus(context.Background(), input.Data.AccountID, status)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	return map[string]interface{}{
+		"message":          "success",
+		"revoked_sessions": count,
+	}, nil
+}
+
+func updateAccountByAdmin(ctx *actionContext, accountID string, set account_set_input, operationName string) (string, error) {
+	var mutation struct {
+		UpdateAccount *struct {
+			ID string `graphql:"id"`
+		} `graphql:"update_account_by_pk(pk_columns: $pk_columns, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"pk_columns": account_pk_columns_input{
+			"id": accountID,
+		},
+		"set": set,
+	}
+
+	err := ctx.Controller.Mutate(context.Background(), &mutation, variables, graphql.OperationName(operationName))
+	if err != nil {
+		return "", err
+	}
+
+	if mutation.UpdateAccount == nil {
+		return "", util.ErrBadRequest(errors.New("account not found"))
+	}
+
+	return mutation.UpdateAccount.ID, nil
+}
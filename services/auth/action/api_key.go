@@ -0,0 +1,155 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hgiasac/hasura-router/go/types"
+	"nexlab.tech/core/pkg/access"
+	"nexlab.tech/core/pkg/util"
+	"nexlab.tech/core/services/auth/audit"
+	"nexlab.tech/core/services/auth/utils"
+)
+
+const (
+	actionCreateAPIKey = "createApiKey"
+	actionListAPIKeys  = "listApiKeys"
+	actionRevokeAPIKey = "revokeApiKey"
+)
+
+// createAPIKey issue an API key of the current user.
+// The key is returned once, only its prefix is visible afterwards.
+// Keys are only restricted by their role, which can't exceed the role of the user
+func createAPIKey(ctx *actionContext, payload []byte) (interface{}, error) {
+	if ctx.Access.UserID == "" {
+		return nil, util.ErrUnauthorized(errors.New("user is required"))
+	}
+
+	// a leaked key must not be able to issue new keys
+	if ctx.SessionVariables[access.XHasuraAPIKeyID] != "" {
+		return nil, util.ErrPermissionDenied(errors.New("api keys can't create api keys"))
+	}
+
+	var input struct {
+		Data struct {
+			Name      string     `json:"name"`
+			Role      string     `json:"role"`
+			ExpiresAt *time.Time `json:"expires_at"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	if input.Data.Name == "" {
+		return nil, types.NewError("required:name", "name is required")
+	}
+
+	// keys act with the user role unless an admin asks for an admin key explicitly
+	role := input.Data.Role
+	if role == "" {
+		role = string(access.RoleUser)
+		if !ctx.Access.IsAdmin() {
+			role = string(ctx.Access.Role)
+		}
+	}
+	if !utils.APIKeyRoleAllowed(string(ctx.Access.Role), role) {
+		return nil, types.NewError("invalid:role", "role exceeds the role of the current user")
+	}
+
+	if input.Data.ExpiresAt != nil && !input.Data.ExpiresAt.After(time.Now()) {
+		return nil, types.NewError("invalid:expires_at", "expires_at must be in the future")
+	}
+
+	key, apiKey, err := ctx.JwtAuth.CreateAPIKey(context.Background(), ctx.Access.UserID, utils.APIKeyInput{
+		Name:      input.Data.Name,
+		Role:      role,
+		ExpiresAt: input.Data.ExpiresAt,
+	})
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+	ctx.AuditTarget(audit.TargetAPIKey, apiKey.ID)
+
+	return map[string]interface{}{
+		"id":         apiKey.ID,
+		"name":       apiKey.Name,
+		"prefix":     apiKey.Prefix,
+		"key":        key,
+		"role":       apiKey.Role,
+		"expires_at": apiKey.ExpiresAt,
+	}, nil
+}
+
+// listAPIKeys return the API keys of the current user without secrets
+func listAPIKeys(ctx *actionContext, payload []byte) (interface{}, error) {
+	if ctx.Access.UserID == "" {
+		return nil, util.ErrUnauthorized(errors.New("user is required"))
+	}
+
+	keys, err := ctx.JwtAuth.ListAPIKeys(context.Background(), ctx.Access.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]map[string]interface{}, len(keys))
+	for i, key := range keys {
+		results[i] = map[string]interface{}{
+			"id":           key.ID,
+			"name":         key.Name,
+			"prefix":       key.Prefix,
+			"role":         key.Role,
+			"expires_at":   key.ExpiresAt,
+			"last_used_at": key.LastUsedAt,
+			"revoked_at":   key.RevokedAt,
+			"created_at":   key.CreatedAt,
+		}
+	}
+
+	return results, nil
+}
+
+// revokeAPIKey revoke an API key of the current user. Admins can revoke keys of any account
+func revokeAPIKey(ctx *actionContext, payload []byte) (interface{}, error) {
+	if ctx.Access.UserID == "" && !ctx.Access.IsAdmin() {
+		return nil, util.ErrUnauthorized(errors.New("user is required"))
+	}
+
+	var input struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	if input.Data.ID == "" {
+		return nil, types.NewError("required:id", "id is required")
+	}
+
+	accountID := ctx.Access.UserID
+	if ctx.Access.IsAdmin() {
+		accountID = ""
+	}
+
+	ctx.AuditTarget(audit.TargetAPIKey, input.Data.ID)
+	ok, err := ctx.JwtAuth.RevokeAPIKey(context.Background(), accountID, input.Data.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, util.ErrBadRequest(errors.New("api key not found"))
+	}
+
+	return map[string]string{
+		"message": "success",
+	}, nil
+}
@@ -4,15 +4,21 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/hasura/go-graphql-client"
+	"github.com/hgiasac/hasura-router/go/types"
 	"nexlab.tech/core/pkg/util"
+	"nexlab.tech/core/services/auth/audit"
+	"nexlab.tech/core/services/auth/provider"
+	"nexlab.tech/core/services/auth/utils"
 )
 
 const (
 	actionLogin        = "login"
 	actionRefreshToken = "refreshToken"
+	actionLogout       = "logout"
+	actionLogoutAll    = "logoutAll"
 
 	// enum login type
 	defaultAccount = "default"
@@ -29,6 +35,8 @@ func login(ctx *actionContext, payload []byte) (interface{}, error) {
 			LoginType string `json:"loginType"`
 			FullName  string `json:"fullName"`
 			Avatar    string `json:"avatar"`
+			// Token is the ID token or access token issued by the third-party provider
+			Token string `json:"token"`
 		} `json:"data"`
 	}
 
@@ -37,8 +45,22 @@ func login(ctx *actionContext, payload []byte) (interface{}, error) {
 		return nil, util.ErrBadRequest(err)
 	}
 
+	ctx.AuditMetadata("login_type", input.Data.LoginType)
 	if input.Data.LoginType != defaultAccount {
-		tokenThirdParty, errThirdParty := findOrCreateLoginThirdParty(ctx, input.Data.Email, input.Data.FullName, input.Data.Avatar, input.Data.LoginType)
+		identity, errIdentity := ctx.Providers.Verify(context.Background(), input.Data.LoginType, input.Data.Token)
+		if errIdentity != nil {
+			return nil, util.ErrUnauthorized(errIdentity)
+		}
+
+		// the client profile is only used when the provider doesn't return it
+		if identity.FullName == "" {
+			identity.FullName = input.Data.FullName
+		}
+		if identity.Avatar == "" {
+			identity.Avatar = input.Data.Avatar
+		}
+
+		tokenThirdParty, errThirdParty := findOrCreateLoginThirdParty(ctx, identity)
 
 		if errThirdParty != nil {
 			return nil, errThirdParty
@@ -47,18 +69,28 @@ func login(ctx *actionContext, payload []byte) (interface{}, error) {
 		return tokenThirdParty, nil
 	}
 
+	ip := ctx.SessionInfo().IP
+	if err := ctx.JwtAuth.CheckLoginIP(context.Background(), ip); err != nil {
+		return nil, util.ErrUnauthorized(err)
+	}
+
 	var query struct {
 		Accounts []struct {
-			ID       string `graphql:"id"`
-			Password string `graphql:"password"`
-			Role     string `graphql:"role"`
+			ID            string     `graphql:"id"`
+			Email         string     `graphql:"email"`
+			FullName      string     `graphql:"fullName"`
+			Password      string     `graphql:"password"`
+			Role          string     `graphql:"role"`
+			Status        string     `graphql:"status"`
+			TOTPEnabledAt *time.Time `graphql:"totp_enabled_at"`
+			utils.LoginState
 		} `graphql:"account(where: $where, limit: 1)"`
 	}
 
 	variables := map[string]interface{}{
 		"where": account_bool_exp{
 			"email": map[string]interface{}{
-				"_like": input.Data.Email,
+				"_like": escapeLikePattern(input.Data.Email),
 			},
 			"loginType": map[string]interface{}{
 				"_eq": input.Data.LoginType,
@@ -73,20 +105,54 @@ func login(ctx *actionContext, payload []byte) (interface{}, error) {
 	}
 
 	if len(query.Accounts) == 0 {
+		ctx.AuditMetadata("email", input.Data.Email)
+		if _, err := ctx.JwtAuth.RecordLoginFailure(context.Background(), "", input.Data.Email, ip); err != nil {
+			return nil, err
+		}
 		return nil, errors.New("account not found")
 	}
 
 	account := query.Accounts[0]
-	if account.Password == "" || ctx.JwtAuth.ComparePassword(account.Password, input.Data.Password) != nil {
-		return nil, errors.New("password not match")
+	ctx.AuditTarget(audit.TargetAccount, account.ID)
+
+	// the attempt is reserved before the password is compared, so concurrent guesses can't exceed the lockout threshold
+	errPasswordNotMatch := errors.New("password not match")
+	failure, err := ctx.JwtAuth.VerifyFirstFactor(context.Background(), account.ID, ip, func() error {
+		if account.Password == "" || ctx.JwtAuth.ComparePassword(account.Password, input.Data.Password) != nil {
+			return errPasswordNotMatch
+		}
+		return nil
+	})
+	if err != nil {
+		if err != errPasswordNotMatch {
+			return nil, util.ErrUnauthorized(err)
+		}
+		if failure.Locked {
+			ctx.AuditMetadata("locked_until", failure.LockedUntil)
+			if err := sendUnlockEmail(ctx, account.ID, account.Email, account.FullName, failure.LockedUntil); err != nil {
+				ctx.Logger.WithError(err).WithField("account_id", account.ID).Error("failed to send unlock email")
+			}
+		}
+		return nil, err
 	}
 
-	token, err := ctx.JwtAuth.EncodeToken(account.ID)
+	// the status is checked after the password so it isn't disclosed to anyone knowing the email
+	if err := utils.CheckAccountStatus(account.Status); err != nil {
+		return nil, util.ErrUnauthorized(err)
+	}
 
-	if err != nil {
+	if err := ctx.JwtAuth.RecordLoginSuccess(context.Background(), account.ID, ip); err != nil {
 		return nil, err
 	}
-	return token, nil
+
+	// upgrade outdated hashes while the plain password is known
+	if ctx.JwtAuth.PasswordNeedsRehash(account.Password) {
+		if err := ctx.JwtAuth.SetAccountPassword(context.Background(), account.ID, input.Data.Password); err != nil {
+			ctx.Logger.WithError(err).WithField("account_id", account.ID).Error("failed to rehash password")
+		}
+	}
+
+	return issueLoginToken(ctx, account.ID, account.TOTPEnabledAt, &account.LoginState)
 }
 
 func refreshToken(ctx *actionContext, payload []byte) (interface{}, error) {
@@ -120,17 +186,97 @@ func refreshToken(ctx *actionContext, payload []byte) (interface{}, error) {
 	return token, nil
 }
 
-func findOrCreateLoginThirdParty(ctx *actionContext, email string, fullName string, avatar string, loginType string) (interface{}, error) {
+// logout revoke the session of the input tokens.
+// Anonymous callers are accepted so that clients with expired access tokens can still log out
+func logout(ctx *actionContext, payload []byte) (interface{}, error) {
+
+	var input struct {
+		Data struct {
+			AccessToken  string `json:"access_token"`
+			RefreshToken string `json:"refresh_token"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	if input.Data.AccessToken == "" {
+		return nil, types.NewError("required:access_token", "access_token is required")
+	}
+
+	// the expiry isn't checked, but purpose tokens of other audiences must not end sessions
+	claims, err := ctx.JwtAuth.ParseToken(input.Data.AccessToken)
+	if err != nil {
+		return nil, util.ErrUnauthorized(err)
+	}
+	if claims.Audience != "access" || claims.SessionID == "" {
+		return nil, util.ErrUnauthorized(errors.New("token_mismatch"))
+	}
+
+	if input.Data.RefreshToken != "" {
+		refreshClaims, err := ctx.JwtAuth.ParseToken(input.Data.RefreshToken)
+		if err != nil {
+			return nil, util.ErrUnauthorized(err)
+		}
+		if refreshClaims.Audience != "refresh" {
+			return nil, util.ErrUnauthorized(errors.New("token_mismatch"))
+		}
+		if refreshClaims.SessionID != claims.SessionID || refreshClaims.Subject != claims.Subject {
+			return nil, util.ErrBadRequest(errors.New("token_mismatch"))
+		}
+	}
+
+	ctx.AuditTarget(audit.TargetSession, claims.SessionID)
+	if !ctx.Access.IsAnonymous() && ctx.Access.UserID != claims.Subject {
+		return nil, util.ErrPermissionDenied(errors.New("the token doesn't belong to the current user"))
+	}
+
+	count, err := ctx.JwtAuth.RevokeSession(context.Background(), claims.Subject, claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"message":          "success",
+		"revoked_sessions": count,
+	}, nil
+}
+
+// logoutAll revoke every session of the current user
+func logoutAll(ctx *actionContext, payload []byte) (interface{}, error) {
+
+	if ctx.Access.UserID == "" {
+		return nil, util.ErrUnauthorized(errors.New("user is required"))
+	}
+
+	ctx.AuditTarget(audit.TargetAccount, ctx.Access.UserID)
+	count, err := ctx.JwtAuth.RevokeAccountSessions(context.Background(), ctx.Access.UserID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"message":          "success",
+		"revoked_sessions": count,
+	}, nil
+}
+
+// findOrCreateLoginThirdParty login the account of the verified email, or create it on the first login
+func findOrCreateLoginThirdParty(ctx *actionContext, identity *provider.Identity) (interface{}, error) {
 	var query struct {
 		AccountByEmail []struct {
-			ID string `graphql:"id"`
+			ID            string     `graphql:"id"`
+			Status        string     `graphql:"status"`
+			TOTPEnabledAt *time.Time `graphql:"totp_enabled_at"`
 		} `graphql:"account(where: $where, limit: 1)"`
 	}
 
 	variables := map[string]interface{}{
 		"where": account_bool_exp{
 			"email": map[string]interface{}{
-				"_like": email,
+				"_eq": identity.Email,
 			},
 			"loginType": map[string]interface{}{
 				"_neq": defaultAccount,
@@ -154,21 +300,15 @@ func findOrCreateLoginThirdParty(ctx *actionContext, email string, fullName stri
 			} `graphql:"insert_account_one(object: $object)"`
 		}
 
-		randomUUID := uuid.New().String()
-		randomHashed, err := ctx.JwtAuth.EncryptPassword(randomUUID)
-
-		if err != nil {
-			return nil, util.ErrBadRequest(err)
-		}
-
 		mutationVariables := map[string]interface{}{
 			"object": account_insert_input{
-				"email":      email,
-				"role":       "user",
-				"fullName":   fullName,
-				"avatar_url": avatar,
-				"loginType":  loginType,
-				"randomHash": randomHashed,
+				"email": identity.Email,
+				// the provider verified the email already
+				"email_verified_at": time.Now(),
+				"role":              "user",
+				"fullName":          identity.FullName,
+				"avatar_url":        identity.Avatar,
+				"loginType":         identity.Provider,
 			},
 		}
 
@@ -178,7 +318,9 @@ func findOrCreateLoginThirdParty(ctx *actionContext, email string, fullName stri
 			return nil, util.ErrBadRequest(err)
 		}
 
-		tokenCreate, err := ctx.JwtAuth.EncodeToken(mutation.CreateAccount.ID)
+		ctx.AuditTarget(audit.TargetAccount, mutation.CreateAccount.ID)
+		ctx.AuditMetadata("created", true)
+		tokenCreate, err := ctx.JwtAuth.EncodeToken(mutation.CreateAccount.ID, ctx.SessionInfo())
 
 		if err != nil {
 			return nil, err
@@ -187,11 +329,11 @@ func findOrCreateLoginThirdParty(ctx *actionContext, email string, fullName stri
 		return tokenCreate, nil
 	}
 
-	token, err := ctx.JwtAuth.EncodeToken(query.AccountByEmail[0].ID)
-
-	if err != nil {
-		return nil, err
+	account := query.AccountByEmail[0]
+	ctx.AuditTarget(audit.TargetAccount, account.ID)
+	if err := utils.CheckAccountStatus(account.Status); err != nil {
+		return nil, util.ErrUnauthorized(err)
 	}
 
-	return token, nil
+	return issueLoginToken(ctx, account.ID, account.TOTPEnabledAt, nil)
 }
@@ -9,6 +9,8 @@ import (
 	"github.com/google/uuid"
 	"github.com/hasura/go-graphql-client"
 	"nexlab.tech/core/pkg/util"
+	"nexlab.tech/core/services/auth/audit"
+	"nexlab.tech/core/services/auth/mailer"
 )
 
 const (
@@ -111,6 +113,7 @@ func uploadFile(ctx *actionContext, payload []byte) (interface{}, error) {
 	}
 
 	results := query.UploadFile
+	ctx.AuditTarget(audit.TargetFile, results.ID)
 
 	return map[string]interface{}{
 		"id":        results.ID,
@@ -141,6 +144,8 @@ func moveFile(ctx *actionContext, payload []byte) (interface{}, error) {
 
 	fromPathId := fromPathSplit[len(fromPathSplit)-1]
 	toPathId := toPathSplit[len(toPathSplit)-1]
+	ctx.AuditTarget(audit.TargetFile, fromPathId)
+	ctx.AuditMetadata("to_path", input.ToPath)
 
 	var getFileFromPath struct {
 		File []struct {
@@ -252,6 +257,7 @@ func updateFile(ctx *actionContext, payload []byte) (interface{}, error) {
 	}
 
 	input := appInput.Data
+	ctx.AuditTarget(audit.TargetFile, input.ID)
 
 	if ok, err := checkPermission(ctx, input.Path); !ok {
 		return nil, err
@@ -344,14 +350,22 @@ func shareFile(ctx *actionContext, payload []byte) (interface{}, error) {
 		return nil, util.ErrBadRequest(err)
 	}
 
+	ctx.AuditMetadata("path", appInput.Data.Path)
+	ctx.AuditMetadata("emails", appInput.Data.Emails)
 	if ok, reason := checkPermission(ctx, appInput.Data.Path); !ok {
 		return nil, reason
 	}
 
 	var query struct {
 		Accounts []struct {
-			ID string `graphql:"id"`
+			ID       string `graphql:"id"`
+			Email    string `graphql:"email"`
+			FullName string `graphql:"fullName"`
 		} `graphql:"account(where: $where)"`
+		Sharer *struct {
+			Email    string `graphql:"email"`
+			FullName string `graphql:"fullName"`
+		} `graphql:"account_by_pk(id: $id)"`
 	}
 
 	variables := map[string]interface{}{
@@ -360,6 +374,7 @@ func shareFile(ctx *actionContext, payload []byte) (interface{}, error) {
 				"_in": appInput.Data.Emails,
 			},
 		},
+		"id": graphql.String(ctx.Access.UserID),
 	}
 
 	err = ctx.Controller.Query(context.Background(), &query, variables)
@@ -412,6 +427,27 @@ func shareFile(ctx *actionContext, payload []byte) (interface{}, error) {
 			return nil, util.ErrBadRequest(err)
 		}
 	}
+
+	sharerName := ""
+	if query.Sharer != nil {
+		sharerName = query.Sharer.FullName
+		if sharerName == "" {
+			sharerName = query.Sharer.Email
+		}
+	}
+	// notifications are queued so the response doesn't wait for the mail server.
+	// The files are shared already so notification failures are only logged
+	for _, account := range query.Accounts {
+		err := ctx.MailQueue.EnqueueTemplate([]string{account.Email}, mailer.TemplateShareFile, map[string]string{
+			"Name":       account.FullName,
+			"SharerName": sharerName,
+			"Path":       appInput.Data.Path,
+		})
+		if err != nil {
+			ctx.Logger.WithError(err).WithField("account_id", account.ID).Error("failed to queue share notification")
+		}
+	}
+
 	return map[string]string{
 		"message": "Shared success",
 	}, nil
@@ -0,0 +1,121 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/hgiasac/hasura-router/go/action"
+	"github.com/hgiasac/hasura-router/go/types"
+	"nexlab.tech/core/pkg/access"
+	"nexlab.tech/core/pkg/util"
+	"nexlab.tech/core/services/auth/audit"
+	"nexlab.tech/core/services/auth/utils"
+)
+
+const (
+	actionImpersonate = "impersonate"
+)
+
+// actions which change credentials or the lifecycle of the account
+// can't be performed with impersonation tokens
+var impersonationDeniedActions = map[action.ActionName]bool{
+	actionChangePassword:             true,
+	actionLogoutAll:                  true,
+	actionRevokeSession:              true,
+	actionEnrollTwoFactor:            true,
+	actionConfirmTwoFactor:           true,
+	actionCreateAPIKey:               true,
+	actionRevokeAPIKey:               true,
+	actionRequestAccountDeletion:     true,
+	actionExportMyData:               true,
+	actionImpersonate:                true,
+	actionBeginWebauthnRegistration:  true,
+	actionFinishWebauthnRegistration: true,
+	actionDeleteWebauthnCredential:   true,
+}
+
+// impersonate issue a short-lived access token of the account to the admin.
+// The token can't be refreshed and every request made with it is audited
+func impersonate(ctx *actionContext, payload []byte) (interface{}, error) {
+	if !ctx.Access.IsAdmin() || ctx.Access.UserID == "" {
+		return nil, util.ErrPermissionDenied(errors.New("only admin can impersonate accounts"))
+	}
+
+	if ctx.SessionVariables[access.XHasuraAPIKeyID] != "" {
+		return nil, util.ErrPermissionDenied(errors.New("api keys can't impersonate accounts"))
+	}
+
+	var input struct {
+		Data struct {
+			AccountID string `json:"account_id"`
+			Reason    string `json:"reason"`
+			// TTL is the lifetime of the token in seconds
+			TTL int `json:"ttl"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	if input.Data.AccountID == "" {
+		return nil, types.NewError("required:account_id", "account_id is required")
+	}
+
+	if input.Data.Reason == "" {
+		return nil, types.NewError("required:reason", "reason is required")
+	}
+
+	if input.Data.TTL < 0 {
+		return nil, types.NewError("invalid:ttl", "ttl must be a positive number of seconds")
+	}
+
+	ctx.AuditTarget(audit.TargetAccount, input.Data.AccountID)
+	ctx.AuditMetadata("reason", input.Data.Reason)
+
+	if input.Data.AccountID == ctx.Access.UserID {
+		return nil, util.ErrBadRequest(errors.New("admin can't impersonate the own account"))
+	}
+
+	var query struct {
+		Account *struct {
+			Role   string `graphql:"role"`
+			Status string `graphql:"status"`
+		} `graphql:"account_by_pk(id: $id)"`
+	}
+
+	variables := map[string]interface{}{
+		"id": graphql.String(input.Data.AccountID),
+	}
+
+	err = ctx.Controller.Query(context.Background(), &query, variables, graphql.OperationName("GetAccountToImpersonate"))
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Account == nil {
+		return nil, util.ErrBadRequest(errors.New("account not found"))
+	}
+
+	if query.Account.Role == string(access.RoleAdmin) {
+		return nil, util.ErrPermissionDenied(errors.New("admin accounts can't be impersonated"))
+	}
+
+	if query.Account.Status != utils.AccountStatusActive {
+		return nil, util.ErrBadRequest(errors.New("only active accounts can be impersonated"))
+	}
+
+	token, err := ctx.JwtAuth.Impersonate(context.Background(), ctx.Access.UserID, input.Data.AccountID,
+		input.Data.Reason, time.Duration(input.Data.TTL)*time.Second, ctx.SessionInfo())
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+	ctx.AuditMetadata("session_id", token.SessionID)
+	ctx.AuditMetadata("expires_at", token.ExpiresAt)
+
+	return token, nil
+}
@@ -0,0 +1,132 @@
+package action
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/hgiasac/hasura-router/go/action"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"nexlab.tech/core/pkg/access"
+	"nexlab.tech/core/pkg/gql"
+	"nexlab.tech/core/pkg/util"
+	"nexlab.tech/core/services/auth/audit"
+	"nexlab.tech/core/services/auth/env"
+	"nexlab.tech/core/services/auth/mailer"
+	"nexlab.tech/core/services/auth/password"
+	"nexlab.tech/core/services/auth/utils"
+)
+
+// newRecordingController serve canned GraphQL data by the root field of the query
+// and record the variables of every request
+func newRecordingController(t *testing.T, responses map[string]string) (*graphql.Client, func(field string) []map[string]interface{}) {
+	var lock sync.Mutex
+	requests := map[string][]map[string]interface{}{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Query     string                 `json:"query"`
+			Variables map[string]interface{} `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for field, data := range responses {
+			if strings.Contains(body.Query, field+"(") {
+				lock.Lock()
+				requests[field] = append(requests[field], body.Variables)
+				lock.Unlock()
+
+				fmt.Fprintf(w, `{"data":%s}`, data)
+				return
+			}
+		}
+		fmt.Fprint(w, `{"errors":[{"message":"unexpected query"}]}`)
+	}))
+	t.Cleanup(server.Close)
+
+	return graphql.NewClient(server.URL, server.Client()), func(field string) []map[string]interface{} {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return requests[field]
+	}
+}
+
+func newTestActionContext(t *testing.T, controller *graphql.Client, sessionVariables map[string]string) *actionContext {
+	jwtAuth, err := utils.NewJWTAuth(utils.JWTAuthConfig{
+		Cost:       bcrypt.MinCost,
+		SessionKey: "secret",
+		Issuer:     "test",
+		PasswordHash: utils.PasswordHashConfig{
+			Algorithm: utils.HashAlgorithmBcrypt,
+		},
+	}, controller)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passwords, err := password.New(password.Config{MinLength: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acs, err := access.ParseSessionVariables(sessionVariables)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &actionContext{
+		Context: &action.Context{
+			SessionVariables: sessionVariables,
+		},
+		Logger:     logrus.NewEntry(logrus.New()),
+		Access:     acs,
+		Env:        &env.Environment{AccountApprovalRequired: true},
+		Controller: gql.NewAccessClient(controller, acs),
+		JwtAuth:    jwtAuth,
+		Mailer:     mailer.NewMemoryMailer(),
+		Passwords:  passwords,
+		auditEvent: &audit.Event{},
+	}
+}
+
+func TestSelfRegisteredAccountCannotImpersonate(t *testing.T) {
+	controller, requests := newRecordingController(t, map[string]string{
+		"insert_account_one": `{"insert_account_one":{"id":"account","email":"foo@example.com","fullName":"Foo","role":"user"}}`,
+		"account_by_pk":      `{"account_by_pk":{"id":"other","role":"user","status":"active"}}`,
+	})
+
+	// the role of the input is ignored, accounts are created as users
+	ctx := newTestActionContext(t, controller, map[string]string{
+		access.XHasuraRole: string(access.RoleAnonymous),
+	})
+	_, err := createAccount(ctx, []byte(`{"data":{"fullName":"Foo","email":"foo@example.com","password":"correct horse battery","role":"admin"}}`))
+	assert.Nil(t, err)
+
+	inserts := requests("insert_account_one")
+	if !assert.Len(t, inserts, 1) {
+		return
+	}
+	object := inserts[0]["object"].(map[string]interface{})
+	assert.Equal(t, string(access.RoleUser), object["role"])
+
+	ctx = newTestActionContext(t, controller, map[string]string{
+		access.XHasuraRole:   object["role"].(string),
+		access.XHasuraUserID: "account",
+	})
+	_, err = impersonate(ctx, []byte(`{"data":{"account_id":"other","reason":"support"}}`))
+	assert.EqualError(t, err, util.ErrPermissionDenied(errors.New("only admin can impersonate accounts")).Error())
+	// the account isn't looked up, the role of the session is checked first
+	assert.Empty(t, requests("account_by_pk"))
+}
@@ -0,0 +1,37 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"nexlab.tech/core/pkg/util"
+	"nexlab.tech/core/services/auth/audit"
+)
+
+const (
+	actionRotateSigningKey = "rotateSigningKey"
+)
+
+// rotateSigningKey replace the JWT signing key by admin.
+// Tokens signed by the previous key stay valid during the overlap window
+func rotateSigningKey(ctx *actionContext, payload []byte) (interface{}, error) {
+	if !ctx.Access.IsAdmin() {
+		return nil, util.ErrPermissionDenied(errors.New("only admin can rotate signing keys"))
+	}
+
+	result, err := ctx.JwtAuth.RotateKey(context.Background())
+	if err != nil {
+		return nil, util.ErrInternal(err)
+	}
+	ctx.AuditTarget(audit.TargetSigningKey, result.KeyID)
+	ctx.AuditMetadata("previous_kid", result.PreviousKeyID)
+
+	return map[string]string{
+		"kid":                     result.KeyID,
+		"algorithm":               result.Algorithm,
+		"activates_at":            result.ActivatesAt.UTC().Format(time.RFC3339),
+		"previous_kid":            result.PreviousKeyID,
+		"previous_kid_expires_at": result.PreviousExpiresAt.UTC().Format(time.RFC3339),
+	}, nil
+}
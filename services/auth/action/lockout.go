@@ -0,0 +1,87 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hgiasac/hasura-router/go/types"
+	"nexlab.tech/core/pkg/util"
+	"nexlab.tech/core/services/auth/audit"
+	"nexlab.tech/core/services/auth/mailer"
+)
+
+const (
+	actionUnlockAccount = "unlockAccount"
+)
+
+// unlockAccount unlock a locked account.
+// Admin unlocks by account_id, the account owner unlocks by the token of the lockout email
+func unlockAccount(ctx *actionContext, payload []byte) (interface{}, error) {
+
+	var input struct {
+		Data struct {
+			AccountID string `json:"account_id"`
+			Token     string `json:"token"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	if input.Data.Token != "" {
+		accountID, err := ctx.JwtAuth.UnlockAccountByToken(context.Background(), input.Data.Token)
+		if err != nil {
+			return nil, util.ErrBadRequest(err)
+		}
+		ctx.AuditTarget(audit.TargetAccount, accountID)
+
+		return map[string]string{
+			"message": "success",
+		}, nil
+	}
+
+	if !ctx.Access.IsAdmin() {
+		return nil, util.ErrPermissionDenied(errors.New("only admin can unlock accounts without token"))
+	}
+
+	if input.Data.AccountID == "" {
+		return nil, types.NewError("required:account_id", "account_id or token is required")
+	}
+
+	ctx.AuditTarget(audit.TargetAccount, input.Data.AccountID)
+	ok, err := ctx.JwtAuth.UnlockAccount(context.Background(), input.Data.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, util.ErrBadRequest(errors.New("account not found"))
+	}
+
+	return map[string]string{
+		"message": "success",
+	}, nil
+}
+
+// sendUnlockEmail notify the lockout to the account owner with the unlock link
+func sendUnlockEmail(ctx *actionContext, accountID string, email string, fullName string, lockedUntil time.Time) error {
+	token, _, err := ctx.JwtAuth.EncodeUnlockToken(accountID, lockedUntil)
+	if err != nil {
+		return err
+	}
+
+	unlockURL, err := buildTokenURL(ctx.Env.UnlockAccountURL, token)
+	if err != nil {
+		return err
+	}
+
+	return mailer.SendTemplate(context.Background(), ctx.Mailer, []string{email}, mailer.TemplateUnlockAccount, map[string]string{
+		"Name":        fullName,
+		"URL":         unlockURL,
+		"LockedUntil": lockedUntil.UTC().Format(time.RFC1123),
+	})
+}
@@ -0,0 +1,181 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/hgiasac/hasura-router/go/types"
+	"nexlab.tech/core/pkg/util"
+	"nexlab.tech/core/services/auth/audit"
+	"nexlab.tech/core/services/auth/mailer"
+	"nexlab.tech/core/services/auth/utils"
+)
+
+const (
+	actionRequestMagicLink = "requestMagicLink"
+	actionConsumeMagicLink = "consumeMagicLink"
+)
+
+// MagicLinkOutput is the response of magic link requests.
+// DeviceToken must be sent with the link token when the link is bound to the requesting device
+type MagicLinkOutput struct {
+	Message     string `json:"message"`
+	DeviceToken string `json:"device_token,omitempty"`
+}
+
+// requestMagicLink email a single-use login link to the account.
+// The response is the same whether the account exists or not so that emails can't be enumerated
+func requestMagicLink(ctx *actionContext, payload []byte) (interface{}, error) {
+
+	var input struct {
+		Data struct {
+			Email string `json:"email"`
+			// SameDevice binds the link to the requesting device
+			SameDevice bool `json:"same_device"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	if input.Data.Email == "" {
+		return nil, types.NewError("required:email", "email is required")
+	}
+
+	info := ctx.SessionInfo()
+	if err := ctx.JwtAuth.CheckMagicLinkIP(context.Background(), info.IP); err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	result := MagicLinkOutput{
+		Message: "if the email exists, a sign-in link has been sent",
+	}
+	// the device token is returned even if the account doesn't exist
+	if input.Data.SameDevice {
+		result.DeviceToken, err = utils.NewMagicLinkDeviceToken()
+		if err != nil {
+			return nil, util.ErrInternal(err)
+		}
+	}
+
+	var query struct {
+		Accounts []struct {
+			ID       string `graphql:"id"`
+			Email    string `graphql:"email"`
+			FullName string `graphql:"fullName"`
+			Status   string `graphql:"status"`
+		} `graphql:"account(where: $where, limit: 1)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": account_bool_exp{
+			"email": map[string]interface{}{
+				"_ilike": escapeLikePattern(input.Data.Email),
+			},
+			// accounts of third-party providers sign in with the provider
+			"loginType": map[string]interface{}{
+				"_eq": defaultAccount,
+			},
+		},
+	}
+
+	err = ctx.Controller.Query(context.Background(), &query, variables, graphql.OperationName("GetAccountByEmail"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(query.Accounts) == 0 {
+		return result, nil
+	}
+
+	account := query.Accounts[0]
+	ctx.AuditTarget(audit.TargetAccount, account.ID)
+	ctx.AuditMetadata("same_device", input.Data.SameDevice)
+	if utils.CheckAccountStatus(account.Status) != nil {
+		return result, nil
+	}
+
+	token, expiresAt, err := ctx.JwtAuth.CreateMagicLink(context.Background(), account.ID, result.DeviceToken, info)
+	if utils.IsMagicLinkRateLimited(err) {
+		// the limit of the account isn't returned because it would reveal the account exists
+		ctx.AuditMetadata("rate_limited", true)
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	loginURL, err := buildTokenURL(ctx.Env.MagicLinkURL, token)
+	if err != nil {
+		return nil, util.ErrInternal(err)
+	}
+
+	err = mailer.SendTemplate(context.Background(), ctx.Mailer, []string{account.Email}, mailer.TemplateMagicLink, map[string]string{
+		"Name":      account.FullName,
+		"URL":       loginURL,
+		"ExpiresAt": expiresAt.UTC().Format(time.RFC1123),
+	})
+	if err != nil {
+		ctx.Logger.WithError(err).WithField("account_id", account.ID).Error("failed to send magic link email")
+	}
+
+	return result, nil
+}
+
+// consumeMagicLink sign in with the token of the magic link email.
+// Accounts with two-factor authentication still have to complete the MFA challenge
+func consumeMagicLink(ctx *actionContext, payload []byte) (interface{}, error) {
+
+	var input struct {
+		Data struct {
+			Token       string `json:"token"`
+			DeviceToken string `json:"device_token"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	if input.Data.Token == "" {
+		return nil, types.NewError("required:token", "token is required")
+	}
+
+	accountID, err := ctx.JwtAuth.ConsumeMagicLink(context.Background(), input.Data.Token, input.Data.DeviceToken)
+	if err != nil {
+		return nil, util.ErrUnauthorized(err)
+	}
+	ctx.AuditTarget(audit.TargetAccount, accountID)
+
+	var query struct {
+		Account *struct {
+			Status        string     `graphql:"status"`
+			TOTPEnabledAt *time.Time `graphql:"totp_enabled_at"`
+		} `graphql:"account_by_pk(id: $id)"`
+	}
+
+	variables := map[string]interface{}{
+		"id": graphql.String(accountID),
+	}
+
+	err = ctx.Controller.Query(context.Background(), &query, variables, graphql.OperationName("GetAccountToLogin"))
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Account == nil {
+		return nil, util.ErrUnauthorized(errors.New("account not found"))
+	}
+
+	if err := utils.CheckAccountStatus(query.Account.Status); err != nil {
+		return nil, util.ErrUnauthorized(err)
+	}
+
+	return issueLoginToken(ctx, accountID, query.Account.TOTPEnabledAt, nil)
+}
@@ -0,0 +1,204 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/hgiasac/hasura-router/go/types"
+	"nexlab.tech/core/pkg/util"
+	"nexlab.tech/core/services/auth/audit"
+	"nexlab.tech/core/services/auth/utils"
+)
+
+const (
+	actionEnrollTwoFactor  = "enrollTwoFactor"
+	actionConfirmTwoFactor = "confirmTwoFactor"
+	actionVerifyMfa        = "verifyMfa"
+	actionResetTwoFactor   = "resetTwoFactor"
+)
+
+// enrollTwoFactor generate a new TOTP secret of the current user.
+// The otpauth URI is the payload of the QR code which authenticator apps scan
+func enrollTwoFactor(ctx *actionContext, payload []byte) (interface{}, error) {
+
+	if ctx.Access.UserID == "" {
+		return nil, util.ErrUnauthorized(errors.New("user is required"))
+	}
+
+	var query struct {
+		Account *struct {
+			Email string `graphql:"email"`
+		} `graphql:"account_by_pk(id: $id)"`
+	}
+
+	variables := map[string]interface{}{
+		"id": graphql.String(ctx.Access.UserID),
+	}
+
+	err := ctx.Controller.Query(context.Background(), &query, variables, graphql.OperationName("GetAccountEmail"))
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Account == nil {
+		return nil, util.ErrUnauthorized(errors.New("account not found"))
+	}
+
+	ctx.AuditTarget(audit.TargetAccount, ctx.Access.UserID)
+	enrollment, err := ctx.JwtAuth.EnrollTOTP(context.Background(), ctx.Access.UserID, query.Account.Email)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	return map[string]string{
+		"secret":      enrollment.Secret,
+		"otpauth_uri": enrollment.URI,
+		"qr_payload":  enrollment.URI,
+	}, nil
+}
+
+// confirmTwoFactor enable two-factor authentication with the first code from the authenticator app
+func confirmTwoFactor(ctx *actionContext, payload []byte) (interface{}, error) {
+
+	if ctx.Access.UserID == "" {
+		return nil, util.ErrUnauthorized(errors.New("user is required"))
+	}
+
+	var input struct {
+		Data struct {
+			Code string `json:"code"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	if input.Data.Code == "" {
+		return nil, types.NewError("required:code", "code is required")
+	}
+
+	ctx.AuditTarget(audit.TargetAccount, ctx.Access.UserID)
+	codes, err := ctx.JwtAuth.ConfirmTOTP(context.Background(), ctx.Access.UserID, input.Data.Code)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	return map[string]interface{}{
+		"recovery_codes": codes,
+	}, nil
+}
+
+// verifyMfa exchange the login challenge token and the second factor for session tokens
+func verifyMfa(ctx *actionContext, payload []byte) (interface{}, error) {
+
+	var input struct {
+		Data struct {
+			MfaToken string `json:"mfa_token"`
+			Code     string `json:"code"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	if input.Data.MfaToken == "" {
+		return nil, types.NewError("required:mfa_token", "mfa_token is required")
+	}
+
+	if input.Data.Code == "" {
+		return nil, types.NewError("required:code", "code is required")
+	}
+
+	accountID, err := ctx.JwtAuth.VerifyMFAChallenge(context.Background(), input.Data.MfaToken, input.Data.Code, ctx.SessionInfo().IP)
+	if err != nil {
+		return nil, util.ErrUnauthorized(err)
+	}
+	ctx.AuditTarget(audit.TargetAccount, accountID)
+
+	return ctx.JwtAuth.EncodeToken(accountID, ctx.SessionInfo())
+}
+
+// resetTwoFactor disable two-factor authentication of the account by admin, e.g. when the user lost the device
+func resetTwoFactor(ctx *actionContext, payload []byte) (interface{}, error) {
+
+	if !ctx.Access.IsAdmin() {
+		return nil, util.ErrPermissionDenied(errors.New("only admin can reset two-factor authentication"))
+	}
+
+	var input struct {
+		Data struct {
+			AccountID string `json:"account_id"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	if input.Data.AccountID == "" {
+		return nil, types.NewError("required:account_id", "account_id is required")
+	}
+
+	ctx.AuditTarget(audit.TargetAccount, input.Data.AccountID)
+	ok, err := ctx.JwtAuth.ResetTOTP(context.Background(), input.Data.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, util.ErrBadRequest(errors.New("account not found"))
+	}
+
+	return map[string]string{
+		"message": "success",
+	}, nil
+}
+
+// issueLoginToken start a new session of the account,
+// or return the MFA challenge if the account enabled two-factor authentication or opted in a passkey as the second factor.
+// The login failures of the state are reset when the login is complete. Otherwise they are kept
+// so that failures of the second factor count towards the lockout across challenges
+func issueLoginToken(ctx *actionContext, accountID string, totpEnabledAt *time.Time, state *utils.LoginState) (interface{}, error) {
+	var methods []string
+	if totpEnabledAt != nil {
+		methods = append(methods, "totp")
+	}
+
+	hasPasskeys, err := ctx.JwtAuth.HasWebAuthnSecondFactor(context.Background(), accountID)
+	if err != nil {
+		return nil, err
+	}
+	if hasPasskeys {
+		methods = append(methods, "webauthn")
+	}
+
+	if len(methods) == 0 {
+		if state != nil {
+			if err := ctx.JwtAuth.ResetLoginFailures(context.Background(), accountID, *state); err != nil {
+				return nil, err
+			}
+		}
+		return ctx.JwtAuth.EncodeToken(accountID, ctx.SessionInfo())
+	}
+
+	token, expiresAt, err := ctx.JwtAuth.EncodeMFAChallenge(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"mfa_required": true,
+		"mfa_token":    token,
+		"mfa_methods":  methods,
+		"token_type":   "mfa",
+		"expires_in":   int(time.Until(expiresAt) / time.Second),
+	}, nil
+}
@@ -4,21 +4,33 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/hasura/go-graphql-client"
 	"github.com/hgiasac/hasura-router/go/types"
+	"nexlab.tech/core/pkg/access"
 	"nexlab.tech/core/pkg/util"
+	"nexlab.tech/core/services/auth/audit"
+	"nexlab.tech/core/services/auth/mailer"
+	"nexlab.tech/core/services/auth/password"
+	"nexlab.tech/core/services/auth/utils"
 )
 
 const (
 	actionAdminChangePassword = "changeAccountPassword"
+	actionChangePassword      = "changePassword"
 	actionForgotPassword      = "forgotPassword"
+	actionResetPassword       = "resetPassword"
 )
 
 // adminChangePassword change account password by admin
 func changeAccountPassword(ctx *actionContext, payload []byte) (interface{}, error) {
+	if !ctx.Access.IsAdmin() {
+		return nil, util.ErrPermissionDenied(errors.New("only admin can change passwords of other accounts"))
+	}
+
 	var input struct {
 		Data struct {
 			AccountID   string `json:"account_id"`
@@ -35,48 +47,125 @@ func changeAccountPassword(ctx *actionContext, payload []byte) (interface{}, err
 		return nil, types.NewError("required:account_id", "account_id is required")
 	}
 
-	passwordHashed, err := ctx.JwtAuth.EncryptPassword(input.Data.NewPassword)
+	ctx.AuditTarget(audit.TargetAccount, input.Data.AccountID)
+	err = validateAccountPassword(ctx, input.Data.AccountID, input.Data.NewPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.JwtAuth.SetAccountPassword(context.Background(), input.Data.AccountID, input.Data.NewPassword)
 	if err != nil {
 		return nil, util.ErrBadRequest(err)
 	}
 
-	randomUUID := uuid.New().String()
-	randomHashed, err := ctx.JwtAuth.EncryptPassword(randomUUID)
+	// sign out every device of the account
+	_, err = ctx.JwtAuth.RevokeAccountSessions(context.Background(), input.Data.AccountID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"message": "success",
+		"id":      input.Data.AccountID,
+	}, nil
+
+}
+
+// changePassword change the password of the current user.
+// Other sessions are signed out and the user is notified by email
+func changePassword(ctx *actionContext, payload []byte) (interface{}, error) {
+	if ctx.Access.UserID == "" {
+		return nil, util.ErrUnauthorized(errors.New("user is required"))
+	}
+	ctx.AuditTarget(audit.TargetAccount, ctx.Access.UserID)
 
+	var input struct {
+		Data struct {
+			CurrentPassword string `json:"current_password"`
+			NewPassword     string `json:"new_password"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
 	if err != nil {
 		return nil, util.ErrBadRequest(err)
 	}
 
+	if input.Data.CurrentPassword == "" {
+		return nil, types.NewError("required:current_password", "current_password is required")
+	}
+
+	if input.Data.NewPassword == "" {
+		return nil, types.NewError("required:new_password", "new_password is required")
+	}
+
 	var query struct {
-		UpdatePassword struct {
-			ID       string `graphql:"id"`
-			Password string `graphql:"password"`
-		} `graphql:"update_account_by_pk(pk_columns: $pk_columns, _set: $set)"`
+		Account *struct {
+			Email    string `graphql:"email"`
+			FullName string `graphql:"fullName"`
+		} `graphql:"account_by_pk(id: $id)"`
 	}
 
 	variables := map[string]interface{}{
-		"pk_columns": account_pk_columns_input{
-			"id": input.Data.AccountID,
-		},
-		"set": account_set_input{
-			"password":   string(passwordHashed),
-			"randomHash": string(randomHashed),
-		},
+		"id": graphql.String(ctx.Access.UserID),
 	}
 
-	err = ctx.Controller.Mutate(context.Background(), &query, variables)
+	err = ctx.Controller.Query(context.Background(), &query, variables, graphql.OperationName("GetAccountIdentity"))
+	if err != nil {
+		return nil, err
+	}
 
+	account := query.Account
+	if account == nil {
+		return nil, util.ErrUnauthorized(errors.New("account not found"))
+	}
+
+	err = verifyCurrentPassword(ctx, account.Email, account.FullName, input.Data.CurrentPassword)
+	if err == errIncorrectPassword {
+		return nil, types.NewError("invalid:current_password", "current password is incorrect")
+	}
 	if err != nil {
-		return nil, util.ErrBadRequest(err)
+		return nil, err
+	}
+
+	err = ctx.Passwords.Validate(input.Data.NewPassword, password.Identity{
+		Email:    account.Email,
+		FullName: account.FullName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.JwtAuth.SetAccountPassword(context.Background(), ctx.Access.UserID, input.Data.NewPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the current device signed in
+	count, err := ctx.JwtAuth.RevokeAccountSessions(context.Background(), ctx.Access.UserID, ctx.SessionVariables[access.XHasuraSessionID])
+	if err != nil {
+		return nil, err
+	}
+
+	info := ctx.SessionInfo()
+	err = mailer.SendTemplate(context.Background(), ctx.Mailer, []string{account.Email}, mailer.TemplatePasswordChanged, map[string]string{
+		"Name":      account.FullName,
+		"ChangedAt": time.Now().UTC().Format(time.RFC1123),
+		"IP":        info.IP,
+		"UserAgent": info.UserAgent,
+	})
+	if err != nil {
+		ctx.Logger.WithError(err).WithField("account_id", ctx.Access.UserID).Error("failed to send password changed email")
 	}
-	return map[string]string{
-		"message":  "success",
-		"id":       query.UpdatePassword.ID,
-		"password": query.UpdatePassword.Password,
-	}, nil
 
+	return map[string]interface{}{
+		"message":          "success",
+		"revoked_sessions": count,
+	}, nil
 }
 
+// forgotPassword email a password reset link to the account.
+// The response is the same whether the account exists or not so that emails can't be enumerated
 func forgotPassword(ctx *actionContext, payload []byte) (interface{}, error) {
 
 	var input struct {
@@ -94,16 +183,32 @@ func forgotPassword(ctx *actionContext, payload []byte) (interface{}, error) {
 		return nil, types.NewError("required:email", "email is required")
 	}
 
+	// configuration and IP errors are checked before the lookup so they don't depend on the account
+	if ctx.Env.ResetPasswordURL == "" {
+		return nil, util.ErrInternal(errors.New("RESET_PASSWORD_URL isn't configured"))
+	}
+
+	info := ctx.SessionInfo()
+	if err := ctx.JwtAuth.CheckPasswordResetIP(context.Background(), info.IP); err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	result := map[string]string{
+		"message": "if the email exists, a password reset link has been sent",
+	}
+
 	var query struct {
 		Accounts []struct {
-			ID string `graphql:"id"`
+			ID       string `graphql:"id"`
+			Email    string `graphql:"email"`
+			FullName string `graphql:"fullName"`
 		} `graphql:"account(where: $where, limit: 1)"`
 	}
 
 	variables := map[string]interface{}{
 		"where": account_bool_exp{
 			"email": map[string]interface{}{
-				"_ilike": input.Data.Email,
+				"_ilike": escapeLikePattern(input.Data.Email),
 			},
 		},
 	}
@@ -115,40 +220,171 @@ func forgotPassword(ctx *actionContext, payload []byte) (interface{}, error) {
 	}
 
 	if len(query.Accounts) == 0 {
-		return nil, errors.New("account not found")
+		return result, nil
+	}
+
+	account := query.Accounts[0]
+	ctx.AuditTarget(audit.TargetAccount, account.ID)
+	token, expiresAt, err := ctx.JwtAuth.CreatePasswordReset(context.Background(), account.ID, info)
+	if utils.IsPasswordResetRateLimited(err) {
+		// the limit of the account isn't returned because it would reveal the account exists
+		ctx.AuditMetadata("rate_limited", true)
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	accountID := query.Accounts[0].ID
+	resetURL, err := buildTokenURL(ctx.Env.ResetPasswordURL, token)
+	if err != nil {
+		return nil, util.ErrInternal(err)
+	}
+
+	// sending failures aren't returned because they would reveal the account exists
+	err = mailer.SendTemplate(context.Background(), ctx.Mailer, []string{account.Email}, mailer.TemplateResetPassword, map[string]string{
+		"Name":      account.FullName,
+		"URL":       resetURL,
+		"ExpiresAt": expiresAt.UTC().Format(time.RFC1123),
+	})
+	if err != nil {
+		ctx.Logger.WithError(err).WithField("account_id", account.ID).Error("failed to send password reset email")
+	}
 
-	token, err := ctx.JwtAuth.EncodeToken(accountID)
+	return result, nil
+}
+
+// resetPassword set the new password with a reset token and sign out every device of the account
+func resetPassword(ctx *actionContext, payload []byte) (interface{}, error) {
+
+	var input struct {
+		Data struct {
+			Token       string `json:"token"`
+			NewPassword string `json:"new_password"`
+		} `json:"data"`
+	}
 
+	err := json.Unmarshal([]byte(payload), &input)
 	if err != nil {
-		return nil, err
+		return nil, util.ErrBadRequest(err)
 	}
 
-	from := ctx.Env.Email
-	password := ctx.Env.Password
+	if input.Data.Token == "" {
+		return nil, types.NewError("required:token", "token is required")
+	}
 
-	toList := []string{input.Data.Email}
+	if input.Data.NewPassword == "" {
+		return nil, types.NewError("required:new_password", "new_password is required")
+	}
 
-	host := "smtp.gmail.com"
-	port := "587"
+	accountID, err := ctx.JwtAuth.FindPasswordReset(context.Background(), input.Data.Token)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+	ctx.AuditTarget(audit.TargetAccount, accountID)
 
-	msg := "Reset Password!!! \n Click link: your link" // Add redirect URL to password reset page
+	err = validateAccountPassword(ctx, accountID, input.Data.NewPassword)
+	if err != nil {
+		return nil, err
+	}
 
-	body := []byte(msg)
+	// the token is consumed atomically, so a concurrent reset with the same token fails here
+	consumedAccountID, err := ctx.JwtAuth.ConsumePasswordReset(context.Background(), input.Data.Token)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+	if consumedAccountID != accountID {
+		return nil, util.ErrBadRequest(errors.New("invalid_reset_token"))
+	}
 
-	auth := smtp.PlainAuth("", from, password, host)
-	err = smtp.SendMail(host+":"+port, auth, from, toList, body)
+	err = ctx.JwtAuth.SetAccountPassword(context.Background(), accountID, input.Data.NewPassword)
+	if err != nil {
+		return nil, err
+	}
 
+	_, err = ctx.JwtAuth.RevokeAccountSessions(context.Background(), accountID, "")
 	if err != nil {
 		return nil, err
 	}
 
 	return map[string]string{
 		"message": "success",
-		"email":   input.Data.Email,
-		"id":      accountID,
-		"token":   token.AccessToken,
 	}, nil
 }
+
+// validateAccountPassword check the new password against the policy and the account information
+func validateAccountPassword(ctx *actionContext, accountID string, newPassword string) error {
+	var query struct {
+		Account *struct {
+			Email    string `graphql:"email"`
+			FullName string `graphql:"fullName"`
+		} `graphql:"account_by_pk(id: $id)"`
+	}
+
+	variables := map[string]interface{}{
+		"id": graphql.String(accountID),
+	}
+
+	err := ctx.Controller.Query(context.Background(), &query, variables, graphql.OperationName("GetAccountIdentity"))
+	if err != nil {
+		return err
+	}
+
+	if query.Account == nil {
+		return util.ErrBadRequest(errors.New("account not found"))
+	}
+
+	return ctx.Passwords.Validate(newPassword, password.Identity{
+		Email:    query.Account.Email,
+		FullName: query.Account.FullName,
+	})
+}
+
+// buildTokenURL set the token to the query string of the configured link
+func buildTokenURL(baseURL string, token string) (string, error) {
+	if baseURL == "" {
+		return "", errors.New("the link URL isn't configured")
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// escapeLikePattern escape wildcards so that user input matches literally in like expressions
+func escapeLikePattern(input string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(input)
+}
+
+var errIncorrectPassword = errors.New("incorrect password")
+
+// verifyCurrentPassword confirm the password of the current user under the brute-force protection of logins,
+// so that a stolen session can't guess the password. Failures count towards the lockout of the account
+func verifyCurrentPassword(ctx *actionContext, email string, fullName string, currentPassword string) error {
+	var passwordErr error
+	failure, err := ctx.JwtAuth.VerifyAccountCredential(context.Background(), ctx.Access.UserID, ctx.SessionInfo().IP, func() error {
+		passwordErr = ctx.JwtAuth.VerifyAccountPassword(context.Background(), ctx.Access.UserID, currentPassword)
+		return passwordErr
+	})
+	if err == nil {
+		return nil
+	}
+	if passwordErr == nil || err != passwordErr {
+		return util.ErrUnauthorized(err)
+	}
+
+	if failure.Locked {
+		ctx.AuditMetadata("locked_until", failure.LockedUntil)
+		if err := sendUnlockEmail(ctx, ctx.Access.UserID, email, fullName, failure.LockedUntil); err != nil {
+			ctx.Logger.WithError(err).WithField("account_id", ctx.Access.UserID).Error("failed to send unlock email")
+		}
+	}
+
+	return errIncorrectPassword
+}
@@ -0,0 +1,36 @@
+package action
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildTokenURL(t *testing.T) {
+	for _, fixture := range []struct {
+		BaseURL  string
+		Expected string
+	}{
+		{"http://localhost:3000/reset-password", "http://localhost:3000/reset-password?token=a%2Bb%2Fc"},
+		// query parameters of the configured link are kept
+		{"https://example.com/reset?lang=vi", "https://example.com/reset?lang=vi&token=a%2Bb%2Fc"},
+	} {
+		result, err := buildTokenURL(fixture.BaseURL, "a+b/c")
+		assert.Nil(t, err, fixture.BaseURL)
+		assert.Equal(t, fixture.Expected, result)
+	}
+
+	_, err := buildTokenURL("", "token")
+	assert.EqualError(t, err, "the link URL isn't configured")
+}
+
+func TestEscapeLikePattern(t *testing.T) {
+	for input, expected := range map[string]string{
+		"user@example.com": "user@example.com",
+		"%@example.com":    `\%@example.com`,
+		"first_last":       `first\_last`,
+		`back\slash`:       `back\\slash`,
+	} {
+		assert.Equal(t, expected, escapeLikePattern(input), input)
+	}
+}
@@ -0,0 +1,186 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/hgiasac/hasura-router/go/types"
+	"nexlab.tech/core/pkg/access"
+	"nexlab.tech/core/pkg/util"
+	"nexlab.tech/core/services/auth/audit"
+	"nexlab.tech/core/services/auth/mailer"
+)
+
+const (
+	actionRequestAccountDeletion = "requestAccountDeletion"
+	actionCancelAccountDeletion  = "cancelAccountDeletion"
+	actionExportMyData           = "exportMyData"
+)
+
+// AccountDeletionOutput tells when the account will be purged
+type AccountDeletionOutput struct {
+	Message    string    `json:"message"`
+	PurgeAfter time.Time `json:"purge_after"`
+}
+
+// DataExportOutput is the zip archive of personal data encoded in base64
+type DataExportOutput struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Content     string `json:"content"`
+}
+
+// requestAccountDeletion delete the account of the current user after the grace period.
+// The user is signed out everywhere and receives a link to cancel the deletion until the purge
+func requestAccountDeletion(ctx *actionContext, payload []byte) (interface{}, error) {
+	if ctx.Access.UserID == "" {
+		return nil, util.ErrUnauthorized(errors.New("user is required"))
+	}
+	ctx.AuditTarget(audit.TargetAccount, ctx.Access.UserID)
+
+	if ctx.SessionVariables[access.XHasuraAPIKeyID] != "" {
+		return nil, util.ErrPermissionDenied(errors.New("api keys can't delete accounts"))
+	}
+
+	var input struct {
+		Data struct {
+			Password string `json:"password"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	var query struct {
+		Account *struct {
+			Email     string `graphql:"email"`
+			FullName  string `graphql:"fullName"`
+			LoginType string `graphql:"loginType"`
+		} `graphql:"account_by_pk(id: $id)"`
+	}
+
+	variables := map[string]interface{}{
+		"id": graphql.String(ctx.Access.UserID),
+	}
+
+	err = ctx.Controller.Query(context.Background(), &query, variables, graphql.OperationName("GetAccountToDelete"))
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Account == nil {
+		return nil, util.ErrBadRequest(errors.New("account not found"))
+	}
+
+	// accounts of third-party providers don't have a password to confirm
+	if query.Account.LoginType == defaultAccount {
+		if input.Data.Password == "" {
+			return nil, types.NewError("required:password", "password is required")
+		}
+		err = verifyCurrentPassword(ctx, query.Account.Email, query.Account.FullName, input.Data.Password)
+		if err == errIncorrectPassword {
+			return nil, types.NewError("invalid:password", "password is incorrect")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	purgeAfter, err := ctx.JwtAuth.ScheduleAccountDeletion(context.Background(), ctx.Access.UserID)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+	ctx.AuditMetadata("purge_after", purgeAfter)
+
+	err = sendAccountDeletionEmail(ctx, ctx.Access.UserID, query.Account.Email, query.Account.FullName, purgeAfter)
+	if err != nil {
+		ctx.Logger.WithError(err).WithField("account_id", ctx.Access.UserID).Error("failed to send account deletion email")
+	}
+
+	return AccountDeletionOutput{
+		Message:    "success",
+		PurgeAfter: purgeAfter,
+	}, nil
+}
+
+// cancelAccountDeletion restore the account with the token of the deletion email
+func cancelAccountDeletion(ctx *actionContext, payload []byte) (interface{}, error) {
+
+	var input struct {
+		Data struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	if input.Data.Token == "" {
+		return nil, types.NewError("required:token", "token is required")
+	}
+
+	accountID, err := ctx.JwtAuth.CancelAccountDeletion(context.Background(), input.Data.Token)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+	ctx.AuditTarget(audit.TargetAccount, accountID)
+
+	return map[string]string{
+		"message": "success",
+	}, nil
+}
+
+// exportMyData return a zip archive of the profile, file metadata, share history,
+// sessions and sign-in history of the current user
+func exportMyData(ctx *actionContext, payload []byte) (interface{}, error) {
+	if ctx.Access.UserID == "" {
+		return nil, util.ErrUnauthorized(errors.New("user is required"))
+	}
+	ctx.AuditTarget(audit.TargetAccount, ctx.Access.UserID)
+
+	data, err := ctx.JwtAuth.GetAccountData(context.Background(), ctx.Access.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	var buf bytes.Buffer
+	if err := data.WriteArchive(&buf, now); err != nil {
+		return nil, util.ErrInternal(err)
+	}
+
+	return DataExportOutput{
+		Filename:    fmt.Sprintf("nexlab-data-%s.zip", now.Format("20060102150405")),
+		ContentType: "application/zip",
+		Content:     base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// sendAccountDeletionEmail notify the owner of the scheduled deletion with the cancel link
+func sendAccountDeletionEmail(ctx *actionContext, accountID string, email string, fullName string, purgeAfter time.Time) error {
+	token, _, err := ctx.JwtAuth.EncodeCancelDeletionToken(accountID, purgeAfter)
+	if err != nil {
+		return err
+	}
+
+	cancelURL, err := buildTokenURL(ctx.Env.CancelDeletionURL, token)
+	if err != nil {
+		return err
+	}
+
+	return mailer.SendTemplate(context.Background(), ctx.Mailer, []string{email}, mailer.TemplateAccountDeletion, map[string]string{
+		"Name":       fullName,
+		"URL":        cancelURL,
+		"PurgeAfter": purgeAfter.UTC().Format(time.RFC1123),
+	})
+}
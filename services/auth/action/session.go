@@ -0,0 +1,110 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hgiasac/hasura-router/go/types"
+	"nexlab.tech/core/pkg/access"
+	"nexlab.tech/core/pkg/util"
+	"nexlab.tech/core/services/auth/audit"
+)
+
+const (
+	actionMySessions    = "mySessions"
+	actionRevokeSession = "revokeSession"
+)
+
+// SessionOutput is a device which is signed in to the account
+type SessionOutput struct {
+	ID             string    `json:"id"`
+	DeviceType     string    `json:"device_type"`
+	Browser        string    `json:"browser"`
+	BrowserVersion string    `json:"browser_version"`
+	OS             string    `json:"os"`
+	UserAgent      string    `json:"user_agent"`
+	IP             string    `json:"ip"`
+	CreatedAt      time.Time `json:"created_at"`
+	LastSeenAt     time.Time `json:"last_seen_at"`
+	Current        bool      `json:"current"`
+	// Impersonated marks sessions which an admin started on behalf of the user
+	Impersonated bool `json:"impersonated"`
+}
+
+// mySessions list the devices where the current user is signed in
+func mySessions(ctx *actionContext, payload []byte) (interface{}, error) {
+	if ctx.Access.UserID == "" {
+		return nil, util.ErrUnauthorized(errors.New("user is required"))
+	}
+
+	sessions, err := ctx.JwtAuth.GetActiveSessions(context.Background(), ctx.Access.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	currentSessionID := ctx.SessionVariables[access.XHasuraSessionID]
+	results := make([]SessionOutput, len(sessions))
+	for i, session := range sessions {
+		result := SessionOutput{
+			ID:           session.ID,
+			CreatedAt:    session.CreatedAt,
+			LastSeenAt:   session.LastSeenAt,
+			Current:      session.ID == currentSessionID,
+			Impersonated: session.ImpersonatorID != nil,
+		}
+		if session.IP != nil {
+			result.IP = *session.IP
+		}
+		if session.UserAgent != nil {
+			result.UserAgent = *session.UserAgent
+		}
+
+		ua := util.ParseUserAgent(result.UserAgent)
+		result.DeviceType = ua.DeviceType
+		result.Browser = ua.Browser
+		result.BrowserVersion = ua.BrowserVersion
+		result.OS = ua.OS
+		results[i] = result
+	}
+
+	return results, nil
+}
+
+// revokeSession sign out a session of the current user
+func revokeSession(ctx *actionContext, payload []byte) (interface{}, error) {
+	if ctx.Access.UserID == "" {
+		return nil, util.ErrUnauthorized(errors.New("user is required"))
+	}
+
+	var input struct {
+		Data struct {
+			SessionID string `json:"session_id"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	if input.Data.SessionID == "" {
+		return nil, types.NewError("required:session_id", "session_id is required")
+	}
+
+	ctx.AuditTarget(audit.TargetSession, input.Data.SessionID)
+	count, err := ctx.JwtAuth.RevokeSession(context.Background(), ctx.Access.UserID, input.Data.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if count == 0 {
+		return nil, util.ErrBadRequest(errors.New("session not found"))
+	}
+
+	return map[string]interface{}{
+		"message":          "success",
+		"revoked_sessions": count,
+	}, nil
+}
@@ -1,12 +1,20 @@
 package action
 
 import (
+	"context"
+	"errors"
+
 	"github.com/hasura/go-graphql-client"
 	"github.com/hgiasac/hasura-router/go/action"
 	"github.com/sirupsen/logrus"
 	"nexlab.tech/core/pkg/access"
 	"nexlab.tech/core/pkg/gql"
+	"nexlab.tech/core/pkg/util"
+	"nexlab.tech/core/services/auth/audit"
 	"nexlab.tech/core/services/auth/env"
+	"nexlab.tech/core/services/auth/mailer"
+	"nexlab.tech/core/services/auth/password"
+	"nexlab.tech/core/services/auth/provider"
 	"nexlab.tech/core/services/auth/utils"
 )
 
@@ -15,8 +23,15 @@ type Config struct {
 	Controller *graphql.Client
 	Env        *env.Environment
 	JwtAuth    *utils.JWTAuth
+	Providers  provider.Registry
+	Mailer     mailer.Mailer
+	MailQueue  *mailer.Queue
+	Passwords  *password.Policy
+	Audit      *audit.Store
 }
 
+type actionHandler func(ctx *actionContext, rawBody []byte) (interface{}, error)
+
 type actionContext struct {
 	*action.Context
 	Logger     *logrus.Entry
@@ -24,25 +39,120 @@ type actionContext struct {
 	Env        *env.Environment
 	Controller *gql.AccessClient
 	JwtAuth    *utils.JWTAuth
+	Providers  provider.Registry
+	Mailer     mailer.Mailer
+	MailQueue  *mailer.Queue
+	Passwords  *password.Policy
+	Audit      *audit.Store
+	auditEvent *audit.Event
 }
 
-// wrap extends action context with new fields
-func (ac Config) wrap(handler func(ctx *actionContext, rawBody []byte) (interface{}, error)) action.Action {
+// wrap extends action context with new fields and records the audit event of the action
+func (ac Config) wrap(name action.ActionName, handler actionHandler) action.Action {
 	return func(ctx *action.Context, rawBody []byte) (interface{}, error) {
 
-		acs, err := access.ParseSessionVariables(ctx.SessionVariables)
-		if err != nil {
-			return nil, err
+		event := &audit.Event{
+			Action:    string(name),
+			ActorID:   ctx.SessionVariables[access.XHasuraUserID],
+			ActorRole: ctx.SessionVariables[access.XHasuraRole],
+		}
+		if apiKeyID := ctx.SessionVariables[access.XHasuraAPIKeyID]; apiKeyID != "" {
+			event.Metadata = map[string]interface{}{
+				"api_key_id": apiKeyID,
+			}
+		}
+		impersonatorID := ctx.SessionVariables[access.XHasuraImpersonatorID]
+		if impersonatorID != "" {
+			if event.Metadata == nil {
+				event.Metadata = make(map[string]interface{})
+			}
+			event.Metadata["impersonator_id"] = impersonatorID
 		}
 
-		return handler(&actionContext{
+		actx := &actionContext{
 			Context:    ctx,
 			Logger:     logrus.NewEntry(logrus.New()),
-			Access:     acs,
 			Env:        ac.Env,
 			JwtAuth:    ac.JwtAuth,
-			Controller: gql.NewAccessClient(ac.Controller, acs),
-		}, rawBody)
+			Providers:  ac.Providers,
+			Mailer:     ac.Mailer,
+			MailQueue:  ac.MailQueue,
+			Passwords:  ac.Passwords,
+			Audit:      ac.Audit,
+			auditEvent: event,
+		}
+		sessionInfo := actx.SessionInfo()
+		event.IP = sessionInfo.IP
+		event.UserAgent = sessionInfo.UserAgent
+
+		acs, err := access.ParseSessionVariables(ctx.SessionVariables)
+		if err != nil {
+			ac.recordAudit(actx, err)
+			return nil, err
+		}
+		actx.Access = acs
+		actx.Controller = gql.NewAccessClient(ac.Controller, acs)
+
+		if impersonatorID != "" && impersonationDeniedActions[name] {
+			err = util.ErrPermissionDenied(errors.New("the action isn't allowed while impersonating"))
+			ac.recordAudit(actx, err)
+			return nil, err
+		}
+
+		result, err := handler(actx, rawBody)
+		ac.recordAudit(actx, err)
+
+		return result, err
+	}
+}
+
+// recordAudit write the audit event with the outcome of the action.
+// The audit log must not break the action so the failure is only logged
+func (ac Config) recordAudit(ctx *actionContext, err error) {
+	if ac.Audit == nil {
+		return
+	}
+
+	event := *ctx.auditEvent
+	event.Outcome = audit.OutcomeSuccess
+	if err != nil {
+		event.Outcome = audit.OutcomeFailure
+		event.Error = err.Error()
+	}
+
+	if auditErr := ac.Audit.Record(context.Background(), event); auditErr != nil {
+		ctx.Logger.WithFields(logrus.Fields{
+			"action":     event.Action,
+			"actor_id":   event.ActorID,
+			"target_id":  event.TargetID,
+			"request_id": ctx.Tracing.GetRequestId(),
+		}).Errorf("failed to record audit event: %s", auditErr)
+	}
+}
+
+// AuditTarget set the resource which the action is performed on
+func (ctx *actionContext) AuditTarget(targetType string, targetID string) {
+	ctx.auditEvent.TargetType = targetType
+	ctx.auditEvent.TargetID = targetID
+}
+
+// AuditMetadata add extra details to the audit event. Never put credentials here
+func (ctx *actionContext) AuditMetadata(key string, value interface{}) {
+	if ctx.auditEvent.Metadata == nil {
+		ctx.auditEvent.Metadata = make(map[string]interface{})
+	}
+	ctx.auditEvent.Metadata[key] = value
+}
+
+// SessionInfo get the client device from headers forwarded by Hasura
+func (ctx *actionContext) SessionInfo() utils.SessionInfo {
+	if ctx.Headers == nil {
+		return utils.SessionInfo{}
+	}
+
+	return utils.SessionInfo{
+		UserAgent: ctx.Headers.Get("User-Agent"),
+		IP:        ctx.Env.Proxies.ClientIP(ctx.Headers),
 	}
 }
 
@@ -0,0 +1,149 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/hgiasac/hasura-router/go/types"
+	"nexlab.tech/core/pkg/util"
+	"nexlab.tech/core/services/auth/audit"
+	"nexlab.tech/core/services/auth/mailer"
+	"nexlab.tech/core/services/auth/utils"
+)
+
+const (
+	actionVerifyEmail        = "verifyEmail"
+	actionResendVerification = "resendVerification"
+)
+
+// verifyEmail confirm the email address with the signed link token
+func verifyEmail(ctx *actionContext, payload []byte) (interface{}, error) {
+
+	var input struct {
+		Data struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	if input.Data.Token == "" {
+		return nil, types.NewError("required:token", "token is required")
+	}
+
+	claims, err := ctx.JwtAuth.DecodePurposeToken(utils.AudienceVerifyEmail, input.Data.Token)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+	ctx.AuditTarget(audit.TargetAccount, claims.Subject)
+
+	var mutation struct {
+		UpdateAccount struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"update_account(where: $where, _set: $set)"`
+	}
+
+	// the token is bound to the email so it can't verify an address changed after the link was sent
+	variables := map[string]interface{}{
+		"where": account_bool_exp{
+			"id": map[string]interface{}{
+				"_eq": claims.Subject,
+			},
+			"email": map[string]interface{}{
+				"_eq": claims.Binding,
+			},
+			"email_verified_at": map[string]interface{}{
+				"_is_null": true,
+			},
+		},
+		"set": account_set_input{
+			"email_verified_at": time.Now(),
+		},
+	}
+
+	err = ctx.Controller.Mutate(context.Background(), &mutation, variables, graphql.OperationName("VerifyEmail"))
+	if err != nil {
+		return nil, err
+	}
+
+	if mutation.UpdateAccount.AffectedRows == 0 {
+		return nil, util.ErrBadRequest(errors.New("invalid_verification_token"))
+	}
+
+	// the role of cached tokens changes after the verification
+	ctx.JwtAuth.InvalidateAccountTokens(claims.Subject)
+
+	return map[string]string{
+		"message": "success",
+	}, nil
+}
+
+// resendVerification send a new verification link to the current user
+func resendVerification(ctx *actionContext, payload []byte) (interface{}, error) {
+
+	if ctx.Access.UserID == "" {
+		return nil, util.ErrUnauthorized(errors.New("user is required"))
+	}
+	ctx.AuditTarget(audit.TargetAccount, ctx.Access.UserID)
+
+	var query struct {
+		Account *struct {
+			ID              string     `graphql:"id"`
+			Email           string     `graphql:"email"`
+			FullName        string     `graphql:"fullName"`
+			EmailVerifiedAt *time.Time `graphql:"email_verified_at"`
+		} `graphql:"account_by_pk(id: $id)"`
+	}
+
+	variables := map[string]interface{}{
+		"id": graphql.String(ctx.Access.UserID),
+	}
+
+	err := ctx.Controller.Query(context.Background(), &query, variables, graphql.OperationName("GetAccountVerification"))
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Account == nil {
+		return nil, util.ErrUnauthorized(errors.New("account not found"))
+	}
+
+	if query.Account.EmailVerifiedAt != nil {
+		return nil, types.NewError("email_already_verified", "the email is already verified")
+	}
+
+	err = sendVerificationEmail(ctx, query.Account.ID, query.Account.Email, query.Account.FullName)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"message": "success",
+	}, nil
+}
+
+// sendVerificationEmail email the signed verification link of the account
+func sendVerificationEmail(ctx *actionContext, accountID string, email string, fullName string) error {
+	token, expiresAt, err := ctx.JwtAuth.EncodePurposeToken(utils.AudienceVerifyEmail, accountID, email, ctx.Env.JWT.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	verifyURL, err := buildTokenURL(ctx.Env.EmailVerificationURL, token)
+	if err != nil {
+		return util.ErrInternal(err)
+	}
+
+	return mailer.SendTemplate(context.Background(), ctx.Mailer, []string{email}, mailer.TemplateVerifyEmail, map[string]string{
+		"Name":      fullName,
+		"Email":     email,
+		"URL":       verifyURL,
+		"ExpiresAt": expiresAt.UTC().Format(time.RFC1123),
+	})
+}
@@ -0,0 +1,349 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/hgiasac/hasura-router/go/types"
+	"nexlab.tech/core/pkg/access"
+	"nexlab.tech/core/pkg/util"
+	"nexlab.tech/core/services/auth/audit"
+	"nexlab.tech/core/services/auth/utils"
+	"nexlab.tech/core/services/auth/webauthn"
+)
+
+const (
+	actionBeginWebauthnRegistration  = "beginWebauthnRegistration"
+	actionFinishWebauthnRegistration = "finishWebauthnRegistration"
+	actionBeginWebauthnLogin         = "beginWebauthnLogin"
+	actionFinishWebauthnLogin        = "finishWebauthnLogin"
+	actionMyWebauthnCredentials      = "myWebauthnCredentials"
+	actionDeleteWebauthnCredential   = "deleteWebauthnCredential"
+	actionResetWebauthnCredentials   = "resetWebauthnCredentials"
+)
+
+// WebauthnChallengeOutput is a started ceremony.
+// Options are passed to navigator.credentials with base64url encoded binary values
+type WebauthnChallengeOutput struct {
+	ChallengeToken string      `json:"challenge_token"`
+	Options        interface{} `json:"options"`
+	ExpiresIn      int         `json:"expires_in"`
+}
+
+func newWebauthnChallengeOutput(challenge *utils.WebAuthnChallenge) WebauthnChallengeOutput {
+	return WebauthnChallengeOutput{
+		ChallengeToken: challenge.Token,
+		Options:        challenge.Options,
+		ExpiresIn:      int(time.Until(challenge.ExpiresAt) / time.Second),
+	}
+}
+
+// beginWebauthnRegistration create the options of a new passkey of the current user
+func beginWebauthnRegistration(ctx *actionContext, payload []byte) (interface{}, error) {
+
+	if ctx.Access.UserID == "" {
+		return nil, util.ErrUnauthorized(errors.New("user is required"))
+	}
+
+	if ctx.SessionVariables[access.XHasuraAPIKeyID] != "" {
+		return nil, util.ErrPermissionDenied(errors.New("passkeys can't be registered with API keys"))
+	}
+
+	var query struct {
+		Account *struct {
+			Email    string `graphql:"email"`
+			FullName string `graphql:"fullName"`
+		} `graphql:"account_by_pk(id: $id)"`
+	}
+
+	variables := map[string]interface{}{
+		"id": graphql.String(ctx.Access.UserID),
+	}
+
+	err := ctx.Controller.Query(context.Background(), &query, variables, graphql.OperationName("GetAccountEmail"))
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Account == nil {
+		return nil, util.ErrUnauthorized(errors.New("account not found"))
+	}
+
+	displayName := query.Account.FullName
+	if displayName == "" {
+		displayName = query.Account.Email
+	}
+
+	ctx.AuditTarget(audit.TargetAccount, ctx.Access.UserID)
+	challenge, err := ctx.JwtAuth.BeginWebAuthnRegistration(context.Background(), ctx.Access.UserID, query.Account.Email, displayName)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	return newWebauthnChallengeOutput(challenge), nil
+}
+
+// finishWebauthnRegistration verify the authenticator response and store the passkey of the current user
+func finishWebauthnRegistration(ctx *actionContext, payload []byte) (interface{}, error) {
+
+	if ctx.Access.UserID == "" {
+		return nil, util.ErrUnauthorized(errors.New("user is required"))
+	}
+
+	if ctx.SessionVariables[access.XHasuraAPIKeyID] != "" {
+		return nil, util.ErrPermissionDenied(errors.New("passkeys can't be registered with API keys"))
+	}
+
+	var input struct {
+		Data struct {
+			ChallengeToken string `json:"challenge_token"`
+			Name           string `json:"name"`
+			SecondFactor   bool   `json:"second_factor"`
+			Credential     struct {
+				ClientDataJSON    string `json:"client_data_json"`
+				AttestationObject string `json:"attestation_object"`
+			} `json:"credential"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	if input.Data.ChallengeToken == "" {
+		return nil, types.NewError("required:challenge_token", "challenge_token is required")
+	}
+
+	if input.Data.Name == "" {
+		return nil, types.NewError("required:name", "name is required")
+	}
+
+	var response webauthn.AttestationResponse
+	response.ClientDataJSON, err = webauthn.DecodeBytes(input.Data.Credential.ClientDataJSON)
+	if err != nil || len(response.ClientDataJSON) == 0 {
+		return nil, types.NewError("invalid:client_data_json", "client_data_json must be base64url encoded")
+	}
+	response.AttestationObject, err = webauthn.DecodeBytes(input.Data.Credential.AttestationObject)
+	if err != nil || len(response.AttestationObject) == 0 {
+		return nil, types.NewError("invalid:attestation_object", "attestation_object must be base64url encoded")
+	}
+
+	ctx.AuditTarget(audit.TargetAccount, ctx.Access.UserID)
+	credential, err := ctx.JwtAuth.FinishWebAuthnRegistration(context.Background(), ctx.Access.UserID, input.Data.ChallengeToken, input.Data.Name, input.Data.SecondFactor, response)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+	ctx.AuditMetadata("credential_id", credential.ID)
+	ctx.AuditMetadata("second_factor", credential.SecondFactor)
+
+	return credential, nil
+}
+
+// beginWebauthnLogin create the options of a passkey assertion.
+// With the MFA token of a password login, the passkey is the second factor of the account.
+// Otherwise the user signs in with any discoverable passkey
+func beginWebauthnLogin(ctx *actionContext, payload []byte) (interface{}, error) {
+
+	var input struct {
+		Data struct {
+			MfaToken string `json:"mfa_token"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	accountID := ""
+	if input.Data.MfaToken != "" {
+		accountID, err = ctx.JwtAuth.DecodeMFAChallenge(input.Data.MfaToken)
+		if err != nil {
+			return nil, util.ErrUnauthorized(err)
+		}
+		ctx.AuditTarget(audit.TargetAccount, accountID)
+	}
+
+	challenge, err := ctx.JwtAuth.BeginWebAuthnLogin(context.Background(), accountID)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	return newWebauthnChallengeOutput(challenge), nil
+}
+
+// finishWebauthnLogin exchange the passkey assertion for session tokens
+func finishWebauthnLogin(ctx *actionContext, payload []byte) (interface{}, error) {
+
+	var input struct {
+		Data struct {
+			ChallengeToken string `json:"challenge_token"`
+			MfaToken       string `json:"mfa_token"`
+			Credential     struct {
+				ID                string `json:"id"`
+				ClientDataJSON    string `json:"client_data_json"`
+				AuthenticatorData string `json:"authenticator_data"`
+				Signature         string `json:"signature"`
+				UserHandle        string `json:"user_handle"`
+			} `json:"credential"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	if input.Data.ChallengeToken == "" {
+		return nil, types.NewError("required:challenge_token", "challenge_token is required")
+	}
+
+	if input.Data.Credential.ID == "" {
+		return nil, types.NewError("required:id", "id is required")
+	}
+
+	var response webauthn.AssertionResponse
+	fields := []struct {
+		name  string
+		value string
+		dest  *[]byte
+	}{
+		{"client_data_json", input.Data.Credential.ClientDataJSON, &response.ClientDataJSON},
+		{"authenticator_data", input.Data.Credential.AuthenticatorData, &response.AuthenticatorData},
+		{"signature", input.Data.Credential.Signature, &response.Signature},
+		{"user_handle", input.Data.Credential.UserHandle, &response.UserHandle},
+	}
+	for _, field := range fields {
+		*field.dest, err = webauthn.DecodeBytes(field.value)
+		if err != nil {
+			return nil, types.NewError("invalid:"+field.name, field.name+" must be base64url encoded")
+		}
+	}
+
+	// the passkey is the second factor of the password login
+	if input.Data.MfaToken != "" {
+		accountID, err := ctx.JwtAuth.VerifyMFAWebAuthn(context.Background(), input.Data.MfaToken, input.Data.ChallengeToken, input.Data.Credential.ID, response, ctx.SessionInfo().IP)
+		if err != nil {
+			return nil, util.ErrUnauthorized(err)
+		}
+		ctx.AuditTarget(audit.TargetAccount, accountID)
+
+		return ctx.JwtAuth.EncodeToken(accountID, ctx.SessionInfo())
+	}
+
+	accountID, err := ctx.JwtAuth.FinishWebAuthnLogin(context.Background(), input.Data.ChallengeToken, input.Data.Credential.ID, response, "")
+	if err != nil {
+		return nil, util.ErrUnauthorized(err)
+	}
+	ctx.AuditTarget(audit.TargetAccount, accountID)
+
+	var query struct {
+		Account *struct {
+			Status string `graphql:"status"`
+		} `graphql:"account_by_pk(id: $id)"`
+	}
+
+	variables := map[string]interface{}{
+		"id": graphql.String(accountID),
+	}
+
+	err = ctx.Controller.Query(context.Background(), &query, variables, graphql.OperationName("GetAccountToLogin"))
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Account == nil {
+		return nil, util.ErrUnauthorized(errors.New("account not found"))
+	}
+
+	if err := utils.CheckAccountStatus(query.Account.Status); err != nil {
+		return nil, util.ErrUnauthorized(err)
+	}
+
+	// a user-verified passkey proves possession and the PIN or biometric, so no second factor is required
+	return ctx.JwtAuth.EncodeToken(accountID, ctx.SessionInfo())
+}
+
+// myWebauthnCredentials list the passkeys of the current user
+func myWebauthnCredentials(ctx *actionContext, payload []byte) (interface{}, error) {
+	if ctx.Access.UserID == "" {
+		return nil, util.ErrUnauthorized(errors.New("user is required"))
+	}
+
+	return ctx.JwtAuth.GetWebAuthnCredentials(context.Background(), ctx.Access.UserID)
+}
+
+// deleteWebauthnCredential remove a passkey of the current user
+func deleteWebauthnCredential(ctx *actionContext, payload []byte) (interface{}, error) {
+	if ctx.Access.UserID == "" {
+		return nil, util.ErrUnauthorized(errors.New("user is required"))
+	}
+
+	var input struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	if input.Data.ID == "" {
+		return nil, types.NewError("required:id", "id is required")
+	}
+
+	ctx.AuditTarget(audit.TargetWebAuthnCredential, input.Data.ID)
+	ok, err := ctx.JwtAuth.DeleteWebAuthnCredential(context.Background(), ctx.Access.UserID, input.Data.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, util.ErrBadRequest(errors.New("passkey not found"))
+	}
+
+	return map[string]string{
+		"message": "success",
+	}, nil
+}
+
+// resetWebauthnCredentials remove all passkeys of the account by admin, e.g. when the user lost the authenticator
+// of the second factor
+func resetWebauthnCredentials(ctx *actionContext, payload []byte) (interface{}, error) {
+
+	if !ctx.Access.IsAdmin() {
+		return nil, util.ErrPermissionDenied(errors.New("only admin can reset passkeys"))
+	}
+
+	var input struct {
+		Data struct {
+			AccountID string `json:"account_id"`
+		} `json:"data"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return nil, util.ErrBadRequest(err)
+	}
+
+	if input.Data.AccountID == "" {
+		return nil, types.NewError("required:account_id", "account_id is required")
+	}
+
+	ctx.AuditTarget(audit.TargetAccount, input.Data.AccountID)
+	count, err := ctx.JwtAuth.ResetWebAuthnCredentials(context.Background(), input.Data.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	ctx.AuditMetadata("deleted_credentials", count)
+
+	return map[string]string{
+		"message": "success",
+	}, nil
+}
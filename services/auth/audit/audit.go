@@ -0,0 +1,178 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+)
+
+type audit_events_bool_exp map[string]interface{}
+type audit_events_insert_input map[string]interface{}
+
+// event outcomes
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// ActorSystem is the actor role of events detected by the service itself
+const ActorSystem = "system"
+
+// target types
+const (
+	TargetAccount            = "account"
+	TargetSession            = "session"
+	TargetAPIKey             = "api_key"
+	TargetFile               = "file"
+	TargetSigningKey         = "signing_key"
+	TargetWebAuthnCredential = "webauthn_credential"
+)
+
+// MaxLimit is the maximum number of events returned by a query
+const MaxLimit = 10000
+
+// Event is a security relevant activity: who did what to which target, from where and with which result
+type Event struct {
+	ID         string                 `json:"id" graphql:"id"`
+	ActorID    string                 `json:"actor_id" graphql:"actor_id"`
+	ActorRole  string                 `json:"actor_role" graphql:"actor_role"`
+	Action     string                 `json:"action" graphql:"action"`
+	TargetType string                 `json:"target_type" graphql:"target_type"`
+	TargetID   string                 `json:"target_id" graphql:"target_id"`
+	IP         string                 `json:"ip" graphql:"ip"`
+	UserAgent  string                 `json:"user_agent" graphql:"user_agent"`
+	Outcome    string                 `json:"outcome" graphql:"outcome"`
+	Error      string                 `json:"error,omitempty" graphql:"error"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty" graphql:"metadata" scalar:"true"`
+	CreatedAt  time.Time              `json:"created_at" graphql:"created_at"`
+}
+
+// Filter narrows down audit events. Empty fields are ignored
+type Filter struct {
+	ActorID    string
+	Action     string
+	TargetType string
+	TargetID   string
+	Outcome    string
+	IP         string
+	From       *time.Time
+	To         *time.Time
+	Limit      int
+	Offset     int
+}
+
+func (f Filter) where() audit_events_bool_exp {
+	where := audit_events_bool_exp{}
+	equals := map[string]string{
+		"actor_id":    f.ActorID,
+		"action":      f.Action,
+		"target_type": f.TargetType,
+		"target_id":   f.TargetID,
+		"outcome":     f.Outcome,
+		"ip":          f.IP,
+	}
+	for column, value := range equals {
+		if value != "" {
+			where[column] = map[string]interface{}{
+				"_eq": value,
+			}
+		}
+	}
+
+	createdAt := map[string]interface{}{}
+	if f.From != nil {
+		createdAt["_gte"] = *f.From
+	}
+	if f.To != nil {
+		createdAt["_lt"] = *f.To
+	}
+	if len(createdAt) > 0 {
+		where["created_at"] = createdAt
+	}
+
+	return where
+}
+
+func (f Filter) limit() int {
+	if f.Limit <= 0 || f.Limit > MaxLimit {
+		return MaxLimit
+	}
+	return f.Limit
+}
+
+// Store persists audit events to the append-only audit_events table
+type Store struct {
+	client *graphql.Client
+}
+
+// New create an audit store with the admin graphql client
+func New(client *graphql.Client) *Store {
+	return &Store{client: client}
+}
+
+// Record append the event to the audit log
+func (s *Store) Record(ctx context.Context, event Event) error {
+	var mutation struct {
+		InsertAuditEvent struct {
+			ID string `graphql:"id"`
+		} `graphql:"insert_audit_events_one(object: $object)"`
+	}
+
+	if event.Outcome == "" {
+		event.Outcome = OutcomeSuccess
+	}
+
+	object := audit_events_insert_input{
+		"actor_id":    nullableString(event.ActorID),
+		"actor_role":  event.ActorRole,
+		"action":      event.Action,
+		"target_type": nullableString(event.TargetType),
+		"target_id":   nullableString(event.TargetID),
+		"ip":          nullableString(event.IP),
+		"user_agent":  nullableString(event.UserAgent),
+		"outcome":     event.Outcome,
+		"error":       nullableString(event.Error),
+	}
+	if len(event.Metadata) > 0 {
+		object["metadata"] = event.Metadata
+	}
+
+	variables := map[string]interface{}{
+		"object": object,
+	}
+
+	return s.client.Mutate(ctx, &mutation, variables, graphql.OperationName("InsertAuditEvent"))
+}
+
+// Query return events matching the filter, newest first, and the total count of matched events
+func (s *Store) Query(ctx context.Context, filter Filter) ([]Event, int, error) {
+	var query struct {
+		AuditEvents          []Event `graphql:"audit_events(where: $where, limit: $limit, offset: $offset, order_by: {created_at: desc})"`
+		AuditEventsAggregate struct {
+			Aggregate struct {
+				Count int `graphql:"count"`
+			} `graphql:"aggregate"`
+		} `graphql:"audit_events_aggregate(where: $where)"`
+	}
+
+	variables := map[string]interface{}{
+		"where":  filter.where(),
+		"limit":  graphql.Int(filter.limit()),
+		"offset": graphql.Int(filter.Offset),
+	}
+
+	err := s.client.Query(ctx, &query, variables, graphql.OperationName("QueryAuditEvents"))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return query.AuditEvents, query.AuditEventsAggregate.Aggregate.Count, nil
+}
+
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testEvents = []Event{
+	{
+		ID:         "1",
+		ActorID:    "acc-1",
+		ActorRole:  "user",
+		Action:     "login",
+		TargetType: TargetAccount,
+		TargetID:   "acc-1",
+		IP:         "10.0.0.1",
+		UserAgent:  "Mozilla/5.0, \"quoted\"",
+		Outcome:    OutcomeSuccess,
+		CreatedAt:  time.Date(2022, 10, 20, 8, 0, 0, 0, time.UTC),
+	},
+	{
+		ID:        "2",
+		ActorRole: "anonymous",
+		Action:    "login",
+		Outcome:   OutcomeFailure,
+		Error:     "invalid_password",
+		Metadata:  map[string]interface{}{"email": "foo@example.com"},
+		CreatedAt: time.Date(2022, 10, 20, 8, 1, 0, 0, time.UTC),
+	},
+}
+
+func TestFilterWhere(t *testing.T) {
+	from := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	where := Filter{
+		ActorID: "acc-1",
+		Outcome: OutcomeFailure,
+		From:    &from,
+	}.where()
+
+	assert.Equal(t, audit_events_bool_exp{
+		"actor_id":   map[string]interface{}{"_eq": "acc-1"},
+		"outcome":    map[string]interface{}{"_eq": OutcomeFailure},
+		"created_at": map[string]interface{}{"_gte": from},
+	}, where)
+	assert.Empty(t, Filter{}.where())
+
+	assert.Equal(t, MaxLimit, Filter{}.limit())
+	assert.Equal(t, MaxLimit, Filter{Limit: MaxLimit + 1}.limit())
+	assert.Equal(t, 50, Filter{Limit: 50}.limit())
+}
+
+func TestExportCSV(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Nil(t, Export(&buf, FormatCSV, testEvents))
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	assert.Nil(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, csvHeader, records[0])
+	assert.Equal(t, []string{
+		"1", "2022-10-20T08:00:00Z", "acc-1", "user", "login", "account", "acc-1",
+		"10.0.0.1", "Mozilla/5.0, \"quoted\"", "success", "", "",
+	}, records[1])
+	assert.Equal(t, "invalid_password", records[2][10])
+	assert.Equal(t, `{"email":"foo@example.com"}`, records[2][11])
+}
+
+func TestExportJSONL(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Nil(t, Export(&buf, FormatJSONL, testEvents))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+
+	var event Event
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &event))
+	assert.Equal(t, testEvents[1], event)
+}
+
+func TestExportUnsupportedFormat(t *testing.T) {
+	assert.EqualError(t, Export(&bytes.Buffer{}, "xml", testEvents), "unsupported export format xml, accepted values: csv, jsonl")
+
+	contentType, err := ContentType(FormatCSV)
+	assert.Nil(t, err)
+	assert.Equal(t, "text/csv", contentType)
+}
@@ -0,0 +1,105 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// export formats
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+var csvHeader = []string{
+	"id",
+	"created_at",
+	"actor_id",
+	"actor_role",
+	"action",
+	"target_type",
+	"target_id",
+	"ip",
+	"user_agent",
+	"outcome",
+	"error",
+	"metadata",
+}
+
+// ContentType return the MIME type of the export format
+func ContentType(format string) (string, error) {
+	switch format {
+	case FormatCSV:
+		return "text/csv", nil
+	case FormatJSONL:
+		return "application/x-ndjson", nil
+	default:
+		return "", fmt.Errorf("unsupported export format %s, accepted values: csv, jsonl", format)
+	}
+}
+
+// Export write the events in CSV or JSON lines format
+func Export(w io.Writer, format string, events []Event) error {
+	switch format {
+	case FormatCSV:
+		return exportCSV(w, events)
+	case FormatJSONL:
+		return exportJSONL(w, events)
+	default:
+		_, err := ContentType(format)
+		return err
+	}
+}
+
+func exportCSV(w io.Writer, events []Event) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		metadata := ""
+		if len(event.Metadata) > 0 {
+			bytes, err := json.Marshal(event.Metadata)
+			if err != nil {
+				return err
+			}
+			metadata = string(bytes)
+		}
+
+		err := writer.Write([]string{
+			event.ID,
+			event.CreatedAt.UTC().Format(time.RFC3339Nano),
+			event.ActorID,
+			event.ActorRole,
+			event.Action,
+			event.TargetType,
+			event.TargetID,
+			event.IP,
+			event.UserAgent,
+			event.Outcome,
+			event.Error,
+			metadata,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func exportJSONL(w io.Writer, events []Event) error {
+	encoder := json.NewEncoder(w)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,10 +1,17 @@
 package env
 
 import (
+	"errors"
 	"log"
+	"net/url"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 	"nexlab.tech/core/pkg/gql"
+	"nexlab.tech/core/pkg/util"
+	"nexlab.tech/core/services/auth/mailer"
+	"nexlab.tech/core/services/auth/password"
+	"nexlab.tech/core/services/auth/provider"
 	"nexlab.tech/core/services/auth/utils"
 )
 
@@ -14,6 +21,13 @@ const (
 	clientName       = "auth"
 )
 
+// unverified account policies
+const (
+	UnverifiedPolicyAllow      = "allow"
+	UnverifiedPolicyRestricted = "restricted"
+	UnverifiedPolicyDeny       = "deny"
+)
+
 // Environment variables data
 type Environment struct {
 	Port             string           `envconfig:"PORT" default:"8080"`
@@ -21,8 +35,52 @@ type Environment struct {
 	DefaultRole      string           `envconfig:"DEFAULT_ROLE" required:"true"`
 	ControllerClient gql.ClientConfig `envconfig:"CONTROLLER" required:"true"`
 	JWT              utils.JWTAuthConfig
-	Email            string `envconfig:"EMAIL" required:"true"`
-	Password         string `envconfig:"EMAIL_PASSWORD" required:"true"`
+	Providers        provider.Config
+	Mailer           mailer.Config
+	Password         password.Config
+	// ResetPasswordURL is the frontend page which receives the reset token in the token query parameter
+	ResetPasswordURL string `envconfig:"RESET_PASSWORD_URL"`
+	// EmailVerificationURL is the frontend page which receives the verification token in the token query parameter
+	EmailVerificationURL string `envconfig:"EMAIL_VERIFICATION_URL"`
+	// UnlockAccountURL is the frontend page which receives the unlock token of locked accounts
+	UnlockAccountURL string `envconfig:"UNLOCK_ACCOUNT_URL"`
+	// CancelDeletionURL is the frontend page which receives the token restoring accounts scheduled for deletion
+	CancelDeletionURL string `envconfig:"CANCEL_DELETION_URL"`
+	// MagicLinkURL is the frontend page which receives the passwordless login token
+	MagicLinkURL string `envconfig:"MAGIC_LINK_URL"`
+	// UnverifiedAccountPolicy limits accounts which haven't verified the email yet.
+	// allow: no limit, restricted: use UnverifiedRole, deny: reject requests
+	UnverifiedAccountPolicy string `envconfig:"UNVERIFIED_ACCOUNT_POLICY" default:"restricted"`
+	UnverifiedRole          string `envconfig:"UNVERIFIED_ROLE" default:"unverified"`
+	// AccountApprovalRequired keeps self-registered accounts pending until an admin activates them
+	AccountApprovalRequired bool `envconfig:"ACCOUNT_APPROVAL_REQUIRED" default:"false"`
+	// TrustedProxyCount is the number of proxies in front of Hasura which append the client address to X-Forwarded-For.
+	// Zero ignores forwarded headers, so the per-IP limits of logins, password resets and magic links are disabled
+	// and a warning is logged at startup
+	TrustedProxyCount int `envconfig:"TRUSTED_PROXY_COUNT" default:"0"`
+	// TrustedProxies are CIDRs of internal proxies whose X-Forwarded-For entries are skipped
+	TrustedProxies []string `envconfig:"TRUSTED_PROXIES"`
+	// ShutdownTimeout is how long requests in flight and queued emails are waited for on shutdown
+	ShutdownTimeout time.Duration `envconfig:"SHUTDOWN_TIMEOUT" default:"30s"`
+	// Proxies resolves the client IP address of requests
+	Proxies *util.TrustedProxies `ignored:"true"`
+}
+
+// VerificationRole return the role of the account with the unverified account policy.
+// The policy applies to every role, admins are verified when they are created
+func (env *Environment) VerificationRole(role string, emailVerified bool) (string, error) {
+	if emailVerified {
+		return role, nil
+	}
+
+	switch env.UnverifiedAccountPolicy {
+	case UnverifiedPolicyDeny:
+		return "", errors.New("email_not_verified")
+	case UnverifiedPolicyRestricted:
+		return env.UnverifiedRole, nil
+	default:
+		return role, nil
+	}
 }
 
 // GetEnv initialize and return environment variables
@@ -34,6 +92,39 @@ func GetEnv() *Environment {
 		log.Fatal(err)
 	}
 
+	switch env.UnverifiedAccountPolicy {
+	case UnverifiedPolicyAllow, UnverifiedPolicyRestricted, UnverifiedPolicyDeny:
+	default:
+		log.Fatalf("invalid UNVERIFIED_ACCOUNT_POLICY %s, accepted values: allow, restricted, deny", env.UnverifiedAccountPolicy)
+	}
+
+	// links are checked at startup, so that a missing URL doesn't fail only for existing accounts
+	links := map[string]string{
+		"RESET_PASSWORD_URL":     env.ResetPasswordURL,
+		"EMAIL_VERIFICATION_URL": env.EmailVerificationURL,
+		"UNLOCK_ACCOUNT_URL":     env.UnlockAccountURL,
+		"CANCEL_DELETION_URL":    env.CancelDeletionURL,
+		"MAGIC_LINK_URL":         env.MagicLinkURL,
+	}
+	for name, link := range links {
+		if link == "" {
+			log.Printf("%s isn't configured, emails which need the link fail", name)
+			continue
+		}
+		if u, err := url.Parse(link); err != nil || u.Scheme == "" || u.Host == "" {
+			log.Fatalf("invalid %s %s, an absolute URL is required", name, link)
+		}
+	}
+
+	env.Proxies, err = util.NewTrustedProxies(env.TrustedProxyCount, env.TrustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if env.TrustedProxyCount == 0 {
+		log.Printf("WARNING: TRUSTED_PROXY_COUNT is 0, the client IP is unknown and the per-IP limits of logins, password resets and magic links are DISABLED. " +
+			"Set it to the number of proxies in front of Hasura")
+	}
+
 	if env.ControllerClient.Headers == nil {
 		env.ControllerClient.Headers = map[string]string{
 			HasuraClientName: clientName,
@@ -0,0 +1,37 @@
+package env
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerificationRole(t *testing.T) {
+	for _, fixture := range []struct {
+		Policy   string
+		Role     string
+		Verified bool
+		Expected string
+		Error    string
+	}{
+		{UnverifiedPolicyAllow, "user", false, "user", ""},
+		{UnverifiedPolicyRestricted, "user", false, "unverified", ""},
+		{UnverifiedPolicyRestricted, "user", true, "user", ""},
+		{UnverifiedPolicyDeny, "user", false, "", "email_not_verified"},
+		{UnverifiedPolicyDeny, "admin", false, "", "email_not_verified"},
+		{UnverifiedPolicyDeny, "admin", true, "admin", ""},
+	} {
+		env := Environment{
+			UnverifiedAccountPolicy: fixture.Policy,
+			UnverifiedRole:          "unverified",
+		}
+
+		role, err := env.VerificationRole(fixture.Role, fixture.Verified)
+		assert.Equal(t, fixture.Expected, role, fixture.Policy)
+		if fixture.Error == "" {
+			assert.Nil(t, err, fixture.Policy)
+		} else {
+			assert.EqualError(t, err, fixture.Error, fixture.Policy)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// mailer drivers
+const (
+	DriverSMTP   = "smtp"
+	DriverLog    = "log"
+	DriverMemory = "memory"
+)
+
+// SMTP connection security modes
+const (
+	TLSNone     = "none"
+	TLSStartTLS = "starttls"
+	TLSImplicit = "tls"
+)
+
+// Config holds the mailer driver and SMTP settings
+type Config struct {
+	Driver string `envconfig:"MAILER_DRIVER" default:"smtp"`
+	// From is the sender address. Default is the SMTP username
+	From     string `envconfig:"MAIL_FROM"`
+	Host     string `envconfig:"SMTP_HOST" default:"smtp.gmail.com"`
+	Port     int    `envconfig:"SMTP_PORT" default:"587"`
+	TLS      string `envconfig:"SMTP_TLS" default:"starttls"`
+	Username string `envconfig:"EMAIL"`
+	Password string `envconfig:"EMAIL_PASSWORD"`
+	// Queue holds the background queue of notifications
+	Queue QueueConfig
+}
+
+// Validate check the required settings of the driver
+func (c Config) Validate() error {
+	switch c.Driver {
+	case DriverLog, DriverMemory:
+		return nil
+	case DriverSMTP:
+	default:
+		return fmt.Errorf("unsupported mailer driver %s", c.Driver)
+	}
+
+	if c.Host == "" {
+		return errors.New("SMTP_HOST is required")
+	}
+	if c.sender() == "" {
+		return errors.New("MAIL_FROM or EMAIL is required")
+	}
+	switch c.TLS {
+	case TLSNone, TLSStartTLS, TLSImplicit:
+	default:
+		return fmt.Errorf("invalid SMTP_TLS %s, accepted values: none, starttls, tls", c.TLS)
+	}
+
+	return nil
+}
+
+func (c Config) sender() string {
+	if c.From != "" {
+		return c.From
+	}
+
+	return c.Username
+}
+
+// Message is an email with HTML and plain text alternatives
+type Message struct {
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer sends email messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New construct the mailer of the configured driver
+func New(config Config) (Mailer, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch config.Driver {
+	case DriverLog:
+		return NewLogMailer(logrus.StandardLogger()), nil
+	case DriverMemory:
+		return NewMemoryMailer(), nil
+	default:
+		return NewSMTPMailer(config), nil
+	}
+}
+
+// SendTemplate render the template and send it to the recipients
+func SendTemplate(ctx context.Context, m Mailer, to []string, name string, data interface{}) error {
+	msg, err := Render(name, data)
+	if err != nil {
+		return err
+	}
+	msg.To = to
+
+	return m.Send(ctx, *msg)
+}
+
+// LogMailer writes messages to logs instead of sending them. It is useful for development
+type LogMailer struct {
+	logger *logrus.Logger
+}
+
+// NewLogMailer construct a log-only mailer
+func NewLogMailer(logger *logrus.Logger) *LogMailer {
+	return &LogMailer{logger}
+}
+
+// Send log the message
+func (lm *LogMailer) Send(ctx context.Context, msg Message) error {
+	lm.logger.WithFields(logrus.Fields{
+		"to":      strings.Join(msg.To, ", "),
+		"subject": msg.Subject,
+	}).Info(msg.Text)
+
+	return nil
+}
+
+// MemoryMailer keeps sent messages in memory so that tests can inspect them
+type MemoryMailer struct {
+	lock     sync.Mutex
+	messages []Message
+}
+
+// NewMemoryMailer construct an in-memory mailer
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+// Send store the message
+func (mm *MemoryMailer) Send(ctx context.Context, msg Message) error {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	mm.messages = append(mm.messages, msg)
+
+	return nil
+}
+
+// Messages return sent messages
+func (mm *MemoryMailer) Messages() []Message {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+
+	return append([]Message{}, mm.messages...)
+}
@@ -0,0 +1,80 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	msg, err := Render(TemplateShareFile, map[string]string{
+		"Name":       "Foo",
+		"SharerName": "<Bar>",
+		"Path":       "/docs",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, `<Bar> shared "/docs" with you`, msg.Subject)
+	assert.Contains(t, msg.Text, "<Bar> shared")
+	assert.Contains(t, msg.HTML, "&lt;Bar&gt; shared")
+
+	_, err = Render("unknown", nil)
+	assert.EqualError(t, err, "email template unknown not found")
+}
+
+func TestBuildMessage(t *testing.T) {
+	body, err := buildMessage("Nexlab <no-reply@nexlab.tech>", Message{
+		To:      []string{"foo@example.com"},
+		Subject: "Xin chào",
+		Text:    "hello",
+		HTML:    "<p>hello</p>",
+	})
+	assert.Nil(t, err)
+
+	msg, err := mail.ReadMessage(bytes.NewReader(body))
+	assert.Nil(t, err)
+
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	assert.Equal(t, "Xin chào", subject)
+	assert.Equal(t, "foo@example.com", msg.Header.Get("To"))
+	assert.Contains(t, msg.Header.Get("Message-ID"), "@nexlab.tech>")
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	assert.Nil(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	var contents []string
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		content, _ := ioutil.ReadAll(part)
+		contents = append(contents, part.Header.Get("Content-Type")+": "+string(content))
+	}
+	assert.Equal(t, []string{
+		"text/plain; charset=utf-8: hello",
+		"text/html; charset=utf-8: <p>hello</p>",
+	}, contents)
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := NewMemoryMailer()
+	err := SendTemplate(context.Background(), m, []string{"foo@example.com"}, TemplateResetPassword, map[string]string{
+		"Name": "Foo",
+		"URL":  "https://example.com/reset?token=abc",
+	})
+	assert.Nil(t, err)
+
+	messages := m.Messages()
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "Reset your password", messages[0].Subject)
+	assert.Equal(t, []string{"foo@example.com"}, messages[0].To)
+	assert.Contains(t, messages[0].HTML, `href="https://example.com/reset?token=abc"`)
+}
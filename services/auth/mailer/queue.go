@@ -0,0 +1,137 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrQueueFull is returned when the queue can't take more messages
+	ErrQueueFull = errors.New("mail queue is full")
+	// ErrQueueClosed is returned when messages are enqueued after the queue is closed
+	ErrQueueClosed = errors.New("mail queue is closed")
+)
+
+// QueueConfig holds the capacity of the background mail queue
+type QueueConfig struct {
+	Size    int `envconfig:"MAIL_QUEUE_SIZE" default:"100"`
+	Workers int `envconfig:"MAIL_QUEUE_WORKERS" default:"2"`
+	// SendTimeout bounds every message so that a stuck mail server doesn't block the workers
+	SendTimeout time.Duration `envconfig:"MAIL_SEND_TIMEOUT" default:"30s"`
+}
+
+// Queue sends messages in the background with a fixed number of workers,
+// for notifications which the response doesn't need to wait for. Failures are only logged
+type Queue struct {
+	mailer   Mailer
+	config   QueueConfig
+	logger   *logrus.Logger
+	messages chan Message
+	workers  sync.WaitGroup
+	lock     sync.RWMutex
+	closed   bool
+}
+
+// NewQueue construct the queue of the mailer and start its workers
+func NewQueue(m Mailer, config QueueConfig, logger *logrus.Logger) *Queue {
+	if config.Size <= 0 {
+		config.Size = 1
+	}
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+
+	q := &Queue{
+		mailer:   m,
+		config:   config,
+		logger:   logger,
+		messages: make(chan Message, config.Size),
+	}
+	q.workers.Add(config.Workers)
+	for i := 0; i < config.Workers; i++ {
+		go q.work()
+	}
+
+	return q
+}
+
+// Enqueue add the message to the queue without blocking
+func (q *Queue) Enqueue(msg Message) error {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	select {
+	case q.messages <- msg:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// EnqueueTemplate render the template and add it to the queue
+func (q *Queue) EnqueueTemplate(to []string, name string, data interface{}) error {
+	msg, err := Render(name, data)
+	if err != nil {
+		return err
+	}
+	msg.To = to
+
+	return q.Enqueue(*msg)
+}
+
+// Close stop accepting messages and wait until the queued ones are sent,
+// or the context is done
+func (q *Queue) Close(ctx context.Context) error {
+	q.lock.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.messages)
+	}
+	q.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) work() {
+	defer q.workers.Done()
+
+	for msg := range q.messages {
+		q.send(msg)
+	}
+}
+
+func (q *Queue) send(msg Message) {
+	ctx := context.Background()
+	if q.config.SendTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.config.SendTimeout)
+		defer cancel()
+	}
+
+	if err := q.mailer.Send(ctx, msg); err != nil {
+		q.logger.WithError(err).WithFields(logrus.Fields{
+			"to":      strings.Join(msg.To, ", "),
+			"subject": msg.Subject,
+		}).Error("failed to send queued email")
+	}
+}
@@ -0,0 +1,47 @@
+package mailer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingMailer holds every message until it is released
+type blockingMailer struct {
+	release chan struct{}
+	sent    chan Message
+}
+
+func (bm *blockingMailer) Send(ctx context.Context, msg Message) error {
+	<-bm.release
+	bm.sent <- msg
+	return nil
+}
+
+func TestQueue(t *testing.T) {
+	bm := &blockingMailer{
+		release: make(chan struct{}),
+		sent:    make(chan Message, 3),
+	}
+	queue := NewQueue(bm, QueueConfig{Size: 1, Workers: 1}, logrus.New())
+
+	// the worker holds the first message and the second fills the queue
+	assert.Nil(t, queue.Enqueue(Message{Subject: "first"}))
+	for queue.Enqueue(Message{Subject: "second"}) == ErrQueueFull {
+	}
+	assert.Equal(t, ErrQueueFull, queue.Enqueue(Message{Subject: "third"}))
+
+	close(bm.release)
+	assert.Nil(t, queue.Close(context.Background()))
+	assert.Equal(t, ErrQueueClosed, queue.Enqueue(Message{Subject: "fourth"}))
+
+	// queued messages are sent before Close returns
+	close(bm.sent)
+	var subjects []string
+	for msg := range bm.sent {
+		subjects = append(subjects, msg.Subject)
+	}
+	assert.Equal(t, []string{"first", "second"}, subjects)
+}
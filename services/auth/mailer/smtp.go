@@ -0,0 +1,194 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPMailer sends messages through a SMTP server
+type SMTPMailer struct {
+	config Config
+}
+
+// NewSMTPMailer construct the SMTP mailer
+func NewSMTPMailer(config Config) *SMTPMailer {
+	return &SMTPMailer{config}
+}
+
+// Send deliver the message to the SMTP server
+func (sm *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if len(msg.To) == 0 {
+		return fmt.Errorf("message %s has no recipient", msg.Subject)
+	}
+
+	from := sm.config.sender()
+	body, err := buildMessage(from, msg)
+	if err != nil {
+		return err
+	}
+
+	client, err := sm.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if sm.config.Username != "" {
+		auth := smtp.PlainAuth("", sm.config.Username, sm.config.Password, sm.config.Host)
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(addressOf(from)); err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		if err := client.Rcpt(addressOf(to)); err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(body); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// dial open the connection with the configured security mode
+func (sm *SMTPMailer) dial(ctx context.Context) (*smtp.Client, error) {
+	address := net.JoinHostPort(sm.config.Host, strconv.Itoa(sm.config.Port))
+	tlsConfig := &tls.Config{ServerName: sm.config.Host}
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+
+	var conn net.Conn
+	var err error
+	if sm.config.TLS == TLSImplicit {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: tlsConfig}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the deadline of the context bounds the whole SMTP session, not only the dial
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	client, err := smtp.NewClient(conn, sm.config.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if sm.config.TLS == TLSStartTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+// addressOf return the email address without the display name
+func addressOf(value string) string {
+	address, err := mail.ParseAddress(value)
+	if err != nil {
+		return value
+	}
+
+	return address.Address
+}
+
+// buildMessage encode the message in MIME format with plain text and HTML alternatives
+func buildMessage(from string, msg Message) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	headers := []string{
+		"From: " + from,
+		"To: " + strings.Join(msg.To, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", msg.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: " + messageID(from),
+		"MIME-Version: 1.0",
+		fmt.Sprintf("Content-Type: multipart/alternative; boundary=%q", writer.Boundary()),
+	}
+	buf.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	parts := []struct {
+		ContentType string
+		Content     string
+	}{
+		{"text/plain; charset=utf-8", msg.Text},
+		// the last part is the preferred alternative
+		{"text/html; charset=utf-8", msg.HTML},
+	}
+	for _, part := range parts {
+		if part.Content == "" {
+			continue
+		}
+
+		partWriter, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              []string{part.ContentType},
+			"Content-Transfer-Encoding": []string{"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		qp := quotedprintable.NewWriter(partWriter)
+		if _, err := qp.Write([]byte(part.Content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func messageID(from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(addressOf(from), "@"); at >= 0 {
+		domain = addressOf(from)[at+1:]
+	}
+
+	random := make([]byte, 16)
+	_, _ = rand.Read(random)
+
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(random), domain)
+}
@@ -0,0 +1,63 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// template names
+const (
+	TemplatePasswordChanged = "password_changed"
+	TemplateResetPassword   = "reset_password"
+	TemplateShareFile       = "share_file"
+	TemplateVerifyEmail     = "verify_email"
+	TemplateUnlockAccount   = "unlock_account"
+	TemplateAccountDeletion = "account_deletion"
+	TemplateMagicLink       = "magic_link"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+var (
+	textTemplates = texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/*.txt"))
+	htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/*.html"))
+)
+
+// Render build the message from the template name.
+// The subject is the "subject" block of the plain text template
+func Render(name string, data interface{}) (*Message, error) {
+	var subject, text, html bytes.Buffer
+
+	textTemplate := textTemplates.Lookup(name + ".txt")
+	if textTemplate == nil {
+		return nil, errTemplateNotFound(name)
+	}
+	if err := textTemplate.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if err := textTemplates.ExecuteTemplate(&subject, name+".subject", data); err != nil {
+		return nil, err
+	}
+
+	if htmlTemplate := htmlTemplates.Lookup(name + ".html"); htmlTemplate != nil {
+		if err := htmlTemplate.Execute(&html, data); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
+
+type errTemplateNotFound string
+
+func (e errTemplateNotFound) Error() string {
+	return "email template " + string(e) + " not found"
+}
@@ -0,0 +1,95 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// prefix length of the hash ranges, the same as the Pwned Passwords range API
+const hashPrefixLength = 5
+
+//go:embed breached.txt.gz
+var embeddedBreachedList []byte
+
+// breachedList is a set of SHA-1 hashes of leaked passwords.
+// Hashes are grouped into ranges by prefix so a lookup only touches suffixes of one range,
+// and the list can be replaced with a k-anonymity range dump
+type breachedList struct {
+	ranges map[string][]string
+}
+
+// loadBreachedList read the embedded list, or the gzip file at path if set.
+// Each line is an uppercase hex SHA-1 hash, optionally followed by :count as in Pwned Passwords dumps
+func loadBreachedList(path string) (*breachedList, error) {
+	var reader io.Reader
+	if path == "" {
+		reader = bytes.NewReader(embeddedBreachedList)
+	} else {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid breached password list: %s", err)
+	}
+	defer gz.Close()
+
+	return parseBreachedList(gz)
+}
+
+func parseBreachedList(reader io.Reader) (*breachedList, error) {
+	list := &breachedList{
+		ranges: map[string][]string{},
+	}
+
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		hash := strings.TrimSpace(scanner.Text())
+		if index := strings.IndexByte(hash, ':'); index >= 0 {
+			hash = hash[:index]
+		}
+		if hash == "" {
+			continue
+		}
+		if len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("invalid breached password hash at line %d", line)
+		}
+		hash = strings.ToUpper(hash)
+		prefix := hash[:hashPrefixLength]
+		list.ranges[prefix] = append(list.ranges[prefix], hash[hashPrefixLength:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, suffixes := range list.ranges {
+		sort.Strings(suffixes)
+	}
+
+	return list, nil
+}
+
+// Contains check if the password hash is in the list
+func (bl *breachedList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	suffixes := bl.ranges[hash[:hashPrefixLength]]
+	suffix := hash[hashPrefixLength:]
+	index := sort.SearchStrings(suffixes, suffix)
+
+	return index < len(suffixes) && suffixes[index] == suffix
+}
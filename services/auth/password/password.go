@@ -0,0 +1,177 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/hgiasac/hasura-router/go/types"
+)
+
+// ErrCodeWeakPassword prefixes the error code of policy violations.
+// The failed rules follow the colon, separated by commas, e.g weak_password:min_length,digit
+const ErrCodeWeakPassword = "weak_password"
+
+// password policy rules
+const (
+	RuleMinLength   = "min_length"
+	RuleMaxLength   = "max_length"
+	RuleLowercase   = "lowercase"
+	RuleUppercase   = "uppercase"
+	RuleDigit       = "digit"
+	RuleSymbol      = "symbol"
+	RuleSameAsEmail = "same_as_email"
+	RuleSameAsName  = "same_as_name"
+	RuleBreached    = "breached"
+)
+
+// Config holds the password policy settings
+type Config struct {
+	MinLength        int  `envconfig:"PASSWORD_MIN_LENGTH" default:"8"`
+	MaxLength        int  `envconfig:"PASSWORD_MAX_LENGTH" default:"128"`
+	RequireLowercase bool `envconfig:"PASSWORD_REQUIRE_LOWERCASE" default:"false"`
+	RequireUppercase bool `envconfig:"PASSWORD_REQUIRE_UPPERCASE" default:"false"`
+	RequireDigit     bool `envconfig:"PASSWORD_REQUIRE_DIGIT" default:"false"`
+	RequireSymbol    bool `envconfig:"PASSWORD_REQUIRE_SYMBOL" default:"false"`
+	CheckBreached    bool `envconfig:"PASSWORD_CHECK_BREACHED" default:"true"`
+	// BreachedListFile replaces the embedded breached password list.
+	// The file is gzip compressed with one SHA-1 hash per line
+	BreachedListFile string `envconfig:"PASSWORD_BREACHED_LIST_FILE"`
+}
+
+// Identity is the personal information the password must not be equal to
+type Identity struct {
+	Email    string
+	FullName string
+}
+
+// Policy validates new passwords
+type Policy struct {
+	config   Config
+	breached *breachedList
+}
+
+// New create the password policy and load the breached password list if enabled
+func New(config Config) (*Policy, error) {
+	if config.MaxLength > 0 && config.MinLength > config.MaxLength {
+		return nil, fmt.Errorf("PASSWORD_MIN_LENGTH %d is greater than PASSWORD_MAX_LENGTH %d", config.MinLength, config.MaxLength)
+	}
+
+	policy := &Policy{config: config}
+	if config.CheckBreached {
+		list, err := loadBreachedList(config.BreachedListFile)
+		if err != nil {
+			return nil, err
+		}
+		policy.breached = list
+	}
+
+	return policy, nil
+}
+
+// Check return the rules which the password violates
+func (p *Policy) Check(password string, identity Identity) []string {
+	var violations []string
+	length := len([]rune(password))
+	if length < p.config.MinLength || length == 0 {
+		violations = append(violations, RuleMinLength)
+	}
+	if p.config.MaxLength > 0 && length > p.config.MaxLength {
+		violations = append(violations, RuleMaxLength)
+	}
+
+	var hasLower, hasUpper, hasDigit, hasSymbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		case unicode.IsPunct(c), unicode.IsSymbol(c), unicode.IsSpace(c):
+			hasSymbol = true
+		}
+	}
+	if p.config.RequireLowercase && !hasLower {
+		violations = append(violations, RuleLowercase)
+	}
+	if p.config.RequireUppercase && !hasUpper {
+		violations = append(violations, RuleUppercase)
+	}
+	if p.config.RequireDigit && !hasDigit {
+		violations = append(violations, RuleDigit)
+	}
+	if p.config.RequireSymbol && !hasSymbol {
+		violations = append(violations, RuleSymbol)
+	}
+
+	normalized := normalize(password)
+	if email := normalize(identity.Email); email != "" {
+		localPart := email
+		if index := strings.LastIndexByte(email, '@'); index > 0 {
+			localPart = email[:index]
+		}
+		if normalized == email || normalized == localPart {
+			violations = append(violations, RuleSameAsEmail)
+		}
+	}
+	if name := normalize(identity.FullName); name != "" && normalized == name {
+		violations = append(violations, RuleSameAsName)
+	}
+
+	if p.breached != nil && password != "" && p.breached.Contains(password) {
+		violations = append(violations, RuleBreached)
+	}
+
+	return violations
+}
+
+// Validate check the password against the policy.
+// The returned error code names every failed rule so clients can render specific messages
+func (p *Policy) Validate(password string, identity Identity) error {
+	violations := p.Check(password, identity)
+	if len(violations) == 0 {
+		return nil
+	}
+
+	messages := make([]string, len(violations))
+	for i, rule := range violations {
+		messages[i] = p.ruleMessage(rule)
+	}
+
+	return types.NewError(
+		fmt.Sprintf("%s:%s", ErrCodeWeakPassword, strings.Join(violations, ",")),
+		strings.Join(messages, "; "),
+	)
+}
+
+func (p *Policy) ruleMessage(rule string) string {
+	switch rule {
+	case RuleMinLength:
+		return fmt.Sprintf("password must have at least %d characters", p.config.MinLength)
+	case RuleMaxLength:
+		return fmt.Sprintf("password must have at most %d characters", p.config.MaxLength)
+	case RuleLowercase:
+		return "password must contain a lowercase letter"
+	case RuleUppercase:
+		return "password must contain an uppercase letter"
+	case RuleDigit:
+		return "password must contain a digit"
+	case RuleSymbol:
+		return "password must contain a symbol"
+	case RuleSameAsEmail:
+		return "password must not be the email"
+	case RuleSameAsName:
+		return "password must not be the full name"
+	case RuleBreached:
+		return "password has appeared in a data breach"
+	}
+
+	return rule
+}
+
+// normalize ignore case and whitespaces when comparing with personal information
+func normalize(value string) string {
+	return strings.ToLower(strings.Join(strings.Fields(value), ""))
+}
@@ -0,0 +1,82 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hgiasac/hasura-router/go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	policy, err := New(Config{
+		MinLength:        10,
+		MaxLength:        20,
+		RequireLowercase: true,
+		RequireUppercase: true,
+		RequireDigit:     true,
+		RequireSymbol:    true,
+		CheckBreached:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity := Identity{
+		Email:    "John.Doe@example.com",
+		FullName: "John Doe",
+	}
+
+	for _, fixture := range []struct {
+		Password   string
+		Violations []string
+	}{
+		{"Correct-Horse-7", nil},
+		{"", []string{RuleMinLength, RuleLowercase, RuleUppercase, RuleDigit, RuleSymbol}},
+		{"short", []string{RuleMinLength, RuleUppercase, RuleDigit, RuleSymbol}},
+		{"Aa1!" + strings.Repeat("x", 20), []string{RuleMaxLength}},
+		{"JOHNDOE123!", []string{RuleLowercase}},
+		{"john.doe@example.com", []string{RuleUppercase, RuleDigit, RuleSameAsEmail}},
+		{"JOHN.DOE", []string{RuleMinLength, RuleLowercase, RuleDigit, RuleSameAsEmail}},
+		{"John   Doe", []string{RuleDigit, RuleSameAsName}},
+		{"P@ssw0rd", []string{RuleMinLength, RuleBreached}},
+	} {
+		assert.Equal(t, fixture.Violations, policy.Check(fixture.Password, identity), fixture.Password)
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	policy, err := New(Config{
+		MinLength:     8,
+		RequireDigit:  true,
+		CheckBreached: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, policy.Validate("unusual passphrase 42", Identity{}))
+
+	err = policy.Validate("abc", Identity{})
+	assert.Equal(t, types.NewError("weak_password:min_length,digit", "password must have at least 8 characters; password must contain a digit"), err)
+
+	err = policy.Validate("password123", Identity{})
+	assert.Equal(t, "weak_password:breached", err.(types.Error).Code)
+}
+
+func TestBreachedList(t *testing.T) {
+	list, err := parseBreachedList(strings.NewReader("5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493\n\n7c4a8d09ca3762af61e59520943dc26494f8941b\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, list.Contains("password"))
+	assert.True(t, list.Contains("123456"))
+	assert.False(t, list.Contains("Password"))
+
+	_, err = parseBreachedList(strings.NewReader("5BAA61E4\n"))
+	assert.EqualError(t, err, "invalid breached password hash at line 1")
+
+	_, err = New(Config{MinLength: 10, MaxLength: 8})
+	assert.EqualError(t, err, "PASSWORD_MIN_LENGTH 10 is greater than PASSWORD_MAX_LENGTH 8")
+}
@@ -0,0 +1,97 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+)
+
+// facebook verifies user access tokens with the Graph API
+type facebook struct {
+	appID       string
+	appSecret   string
+	debugURL    string
+	userInfoURL string
+	client      httpClient
+}
+
+// NewFacebook construct the Facebook identity provider
+func NewFacebook(config Config, client httpClient) Provider {
+	return &facebook{
+		appID:       config.FacebookAppID,
+		appSecret:   config.FacebookAppSecret,
+		debugURL:    config.FacebookDebugURL,
+		userInfoURL: config.FacebookUserInfoURL,
+		client:      client,
+	}
+}
+
+func (f *facebook) Name() string {
+	return Facebook
+}
+
+// Verify check that the access token is valid and issued to this app, then fetch the user profile.
+// Facebook only returns confirmed emails so the email is verified if it exists
+func (f *facebook) Verify(ctx context.Context, token string) (*Identity, error) {
+	if f.appID == "" || f.appSecret == "" {
+		return nil, errors.New("facebook login isn't configured")
+	}
+
+	var debug struct {
+		Data struct {
+			AppID     string `json:"app_id"`
+			UserID    string `json:"user_id"`
+			IsValid   bool   `json:"is_valid"`
+			ExpiresAt int64  `json:"expires_at"`
+		} `json:"data"`
+	}
+
+	debugQuery := url.Values{
+		"input_token":  []string{token},
+		"access_token": []string{f.appID + "|" + f.appSecret},
+	}
+	if err := getJSON(ctx, f.client, f.debugURL+"?"+debugQuery.Encode(), &debug); err != nil {
+		return nil, err
+	}
+
+	if !debug.Data.IsValid || debug.Data.AppID != f.appID || debug.Data.UserID == "" {
+		return nil, errInvalidToken
+	}
+	// zero expiry is a long-lived token which never expires
+	if debug.Data.ExpiresAt > 0 && debug.Data.ExpiresAt <= time.Now().Unix() {
+		return nil, errTokenExpired
+	}
+
+	var profile struct {
+		ID      string `json:"id"`
+		Name    string `json:"name"`
+		Email   string `json:"email"`
+		Picture struct {
+			Data struct {
+				URL string `json:"url"`
+			} `json:"data"`
+		} `json:"picture"`
+	}
+
+	profileQuery := url.Values{
+		"fields":       []string{"id,name,email,picture"},
+		"access_token": []string{token},
+	}
+	if err := getJSON(ctx, f.client, f.userInfoURL+"?"+profileQuery.Encode(), &profile); err != nil {
+		return nil, err
+	}
+
+	if profile.ID != debug.Data.UserID {
+		return nil, errInvalidToken
+	}
+
+	return &Identity{
+		Provider:      Facebook,
+		Subject:       profile.ID,
+		Email:         profile.Email,
+		EmailVerified: profile.Email != "",
+		FullName:      profile.Name,
+		Avatar:        profile.Picture.Data.URL,
+	}, nil
+}
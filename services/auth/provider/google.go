@@ -0,0 +1,112 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	jose "github.com/dvsekhvalnov/jose2go"
+)
+
+type googleClaims struct {
+	Issuer        string      `json:"iss"`
+	Subject       string      `json:"sub"`
+	Audience      string      `json:"aud"`
+	ExpiresAt     int64       `json:"exp"`
+	Email         string      `json:"email"`
+	EmailVerified interface{} `json:"email_verified"`
+	Name          string      `json:"name"`
+	Picture       string      `json:"picture"`
+}
+
+// google verifies Google ID tokens against Google's public keys
+type google struct {
+	clientIDs []string
+	issuers   []string
+	keys      *jwksCache
+}
+
+// NewGoogle construct the Google identity provider
+func NewGoogle(config Config, client httpClient) Provider {
+	return &google{
+		clientIDs: config.GoogleClientIDs,
+		issuers:   config.GoogleIssuers,
+		keys:      newJWKSCache(config.GoogleJWKSURL, client),
+	}
+}
+
+func (g *google) Name() string {
+	return Google
+}
+
+// Verify validate the signature, issuer, audience and expiry of the ID token
+func (g *google) Verify(ctx context.Context, token string) (*Identity, error) {
+	if len(g.clientIDs) == 0 {
+		return nil, errors.New("google login isn't configured")
+	}
+
+	var keyErr error
+	payload, _, err := jose.Decode(token, func(headers map[string]interface{}, payload string) interface{} {
+		if alg, _ := headers["alg"].(string); alg != jose.RS256 {
+			return errInvalidToken
+		}
+		kid, _ := headers["kid"].(string)
+		key, err := g.keys.find(ctx, kid)
+		if err != nil {
+			keyErr = err
+			return err
+		}
+
+		return key
+	})
+	if keyErr != nil {
+		return nil, keyErr
+	}
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	var claims googleClaims
+	if err := json.Unmarshal([]byte(payload), &claims); err != nil {
+		return nil, errInvalidToken
+	}
+
+	if !containsString(g.issuers, claims.Issuer) || !containsString(g.clientIDs, claims.Audience) || claims.Subject == "" {
+		return nil, errInvalidToken
+	}
+	if claims.ExpiresAt <= time.Now().Unix() {
+		return nil, errTokenExpired
+	}
+
+	return &Identity{
+		Provider:      Google,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: isTrue(claims.EmailVerified),
+		FullName:      claims.Name,
+		Avatar:        claims.Picture,
+	}, nil
+}
+
+// isTrue accept boolean claims which are encoded as either boolean or string
+func isTrue(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,150 @@
+package provider
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// public keys of providers are refreshed after this interval
+	jwksRefreshInterval = time.Hour
+	// minimum interval between refreshes triggered by unknown key ids
+	jwksForceRefreshInterval = time.Minute
+)
+
+type httpClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+// jwksCache fetches and caches the public keys of a JWKS endpoint
+type jwksCache struct {
+	url       string
+	client    httpClient
+	lock      sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+func newJWKSCache(url string, client httpClient) *jwksCache {
+	return &jwksCache{
+		url:    url,
+		client: client,
+	}
+}
+
+// find return the public key by id. The key set is fetched again if the key is unknown,
+// because providers rotate their keys regularly
+func (jc *jwksCache) find(ctx context.Context, kid string) (interface{}, error) {
+	jc.lock.Lock()
+	defer jc.lock.Unlock()
+
+	age := time.Since(jc.fetchedAt)
+	if key, ok := jc.keys[kid]; ok && age < jwksRefreshInterval {
+		return key, nil
+	}
+
+	if jc.keys == nil || age > jwksForceRefreshInterval {
+		if err := jc.fetch(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	key, ok := jc.keys[kid]
+	if !ok {
+		return nil, errors.New("unknown identity token key")
+	}
+
+	return key, nil
+}
+
+func (jc *jwksCache) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jc.url, nil)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := doJSON(jc.client, req, &result); err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %s", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range result.Keys {
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+
+	jc.keys = keys
+	jc.fetchedAt = time.Now()
+
+	return nil
+}
+
+// publicKey decode RSA and EC public keys
+func (jwk jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, ok := map[string]elliptic.Curve{
+			"P-256": elliptic.P256(),
+			"P-384": elliptic.P384(),
+			"P-521": elliptic.P521(),
+		}[jwk.Curve]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Curve)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %s", jwk.KeyType)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(bytes), nil
+}
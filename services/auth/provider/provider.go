@@ -0,0 +1,116 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// provider names which match the loginType of accounts
+const (
+	Google   = "google"
+	Facebook = "facebook"
+)
+
+var (
+	errInvalidToken     = errors.New("invalid_identity_token")
+	errEmailNotVerified = errors.New("email_not_verified")
+	errTokenExpired     = errors.New("identity_token_expired")
+)
+
+// Config holds credentials and endpoints of identity providers.
+// The endpoints can be replaced by local stand-ins in tests
+type Config struct {
+	// GoogleClientIDs are OAuth client ids which Google ID tokens must be issued to
+	GoogleClientIDs []string `envconfig:"GOOGLE_CLIENT_IDS"`
+	GoogleJWKSURL   string   `envconfig:"GOOGLE_JWKS_URL" default:"https://www.googleapis.com/oauth2/v3/certs"`
+	GoogleIssuers   []string `envconfig:"GOOGLE_ISSUERS" default:"https://accounts.google.com,accounts.google.com"`
+
+	FacebookAppID       string        `envconfig:"FACEBOOK_APP_ID"`
+	FacebookAppSecret   string        `envconfig:"FACEBOOK_APP_SECRET"`
+	FacebookDebugURL    string        `envconfig:"FACEBOOK_DEBUG_TOKEN_URL" default:"https://graph.facebook.com/debug_token"`
+	FacebookUserInfoURL string        `envconfig:"FACEBOOK_USERINFO_URL" default:"https://graph.facebook.com/me"`
+	RequestTimeout      time.Duration `envconfig:"IDENTITY_PROVIDER_TIMEOUT" default:"10s"`
+}
+
+// Identity is the account profile verified by the identity provider
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	FullName      string
+	Avatar        string
+}
+
+// Provider verifies tokens issued by a third-party identity provider
+type Provider interface {
+	// Name return the login type of the provider
+	Name() string
+	// Verify validate the token and return the identity of its owner.
+	// The email of the returned identity is always verified
+	Verify(ctx context.Context, token string) (*Identity, error)
+}
+
+// Registry holds identity providers by name
+type Registry map[string]Provider
+
+// New construct the registry of supported identity providers
+func New(config Config) Registry {
+	client := &http.Client{
+		Timeout: config.RequestTimeout,
+	}
+
+	return Registry{
+		Google:   NewGoogle(config, client),
+		Facebook: NewFacebook(config, client),
+	}
+}
+
+// Verify validate the token with the provider of the login type
+func (r Registry) Verify(ctx context.Context, loginType string, token string) (*Identity, error) {
+	p, ok := r[loginType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported login type %s", loginType)
+	}
+	if token == "" {
+		return nil, errInvalidToken
+	}
+
+	identity, err := p.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, errEmailNotVerified
+	}
+
+	return identity, nil
+}
+
+// getJSON send a GET request and decode the JSON response
+func getJSON(ctx context.Context, client httpClient, url string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	return doJSON(client, req, result)
+}
+
+func doJSON(client httpClient, req *http.Request, result interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("%s responded with status %d", req.URL.Host, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
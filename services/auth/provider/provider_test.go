@@ -0,0 +1,143 @@
+package provider
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jose "github.com/dvsekhvalnov/jose2go"
+	"github.com/stretchr/testify/assert"
+)
+
+func newGoogleStandIn(t *testing.T, key *rsa.PrivateKey) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{
+					"kty": "RSA",
+					"kid": "test",
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				},
+			},
+		})
+	}))
+}
+
+func signGoogleToken(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	payload := map[string]interface{}{
+		"iss":            "https://accounts.google.com",
+		"sub":            "1234",
+		"aud":            "client-id",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"email":          "foo@example.com",
+		"email_verified": true,
+		"name":           "Foo",
+	}
+	for k, v := range claims {
+		payload[k] = v
+	}
+
+	bytes, _ := json.Marshal(payload)
+	token, err := jose.SignBytes(bytes, jose.RS256, key, jose.Header("kid", "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestGoogleVerify(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	server := newGoogleStandIn(t, key)
+	defer server.Close()
+
+	registry := New(Config{
+		GoogleClientIDs: []string{"client-id"},
+		GoogleJWKSURL:   server.URL,
+		GoogleIssuers:   []string{"https://accounts.google.com"},
+		RequestTimeout:  time.Second,
+	})
+
+	identity, err := registry.Verify(context.Background(), Google, signGoogleToken(t, key, nil))
+	assert.Nil(t, err)
+	assert.Equal(t, &Identity{
+		Provider:      Google,
+		Subject:       "1234",
+		Email:         "foo@example.com",
+		EmailVerified: true,
+		FullName:      "Foo",
+	}, identity)
+
+	for _, fixture := range []struct {
+		Name  string
+		Token string
+		Error string
+	}{
+		{"audience", signGoogleToken(t, key, map[string]interface{}{"aud": "other"}), "invalid_identity_token"},
+		{"issuer", signGoogleToken(t, key, map[string]interface{}{"iss": "https://evil.com"}), "invalid_identity_token"},
+		{"expiry", signGoogleToken(t, key, map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()}), "identity_token_expired"},
+		{"email_verified", signGoogleToken(t, key, map[string]interface{}{"email_verified": "false"}), "email_not_verified"},
+		{"signature", signGoogleToken(t, otherKey, nil), "invalid_identity_token"},
+	} {
+		_, err := registry.Verify(context.Background(), Google, fixture.Token)
+		if assert.Error(t, err, fixture.Name) {
+			assert.Contains(t, err.Error(), fixture.Error, fixture.Name)
+		}
+	}
+}
+
+func TestFacebookVerify(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug_token", func(w http.ResponseWriter, r *http.Request) {
+		appID := "app-id"
+		if r.URL.Query().Get("input_token") == "other-app-token" {
+			appID = "other-app"
+		}
+		assert.Equal(t, "app-id|secret", r.URL.Query().Get("access_token"))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"app_id":     appID,
+				"user_id":    "5678",
+				"is_valid":   true,
+				"expires_at": time.Now().Add(time.Hour).Unix(),
+			},
+		})
+	})
+	mux.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":    "5678",
+			"name":  "Bar",
+			"email": "bar@example.com",
+		})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	registry := New(Config{
+		FacebookAppID:       "app-id",
+		FacebookAppSecret:   "secret",
+		FacebookDebugURL:    server.URL + "/debug_token",
+		FacebookUserInfoURL: server.URL + "/me",
+		RequestTimeout:      time.Second,
+	})
+
+	identity, err := registry.Verify(context.Background(), Facebook, "user-token")
+	assert.Nil(t, err)
+	assert.Equal(t, "bar@example.com", identity.Email)
+	assert.Equal(t, "5678", identity.Subject)
+
+	_, err = registry.Verify(context.Background(), Facebook, "other-app-token")
+	assert.EqualError(t, err, "invalid_identity_token")
+
+	_, err = registry.Verify(context.Background(), "twitter", "user-token")
+	assert.EqualError(t, err, "unsupported login type twitter")
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,12 +15,17 @@ import (
 	"github.com/hgiasac/hasura-router/go/tracing"
 	"github.com/sirupsen/logrus"
 	"nexlab.tech/core/pkg/access"
+	"nexlab.tech/core/services/auth/audit"
+	"nexlab.tech/core/services/auth/utils"
 )
 
 var (
 	internalErrorMessage = []byte("{\"message\": \"internal error\"}")
 )
 
+// actionImpersonatedRequest is the audit action of requests made with impersonation tokens
+const actionImpersonatedRequest = "impersonatedRequest"
+
 type account_bool_exp map[string]interface{}
 
 type rawAuthRequestBody struct {
@@ -30,6 +37,8 @@ type authRequestBody struct {
 	APIKey    string
 	AuthToken string
 	UserAgent string
+	// Request is the GraphQL request which Hasura authorizes
+	Request map[string]interface{}
 }
 
 type authHandler struct {
@@ -50,64 +59,223 @@ func (ah *authHandler) Post(w http.ResponseWriter, r *http.Request) {
 	}
 
 	headers := stringMapToHeader(rawBody.Headers)
-	body := authRequestBody{}
+	body := authRequestBody{
+		Request: rawBody.Request,
+	}
 	if headers != nil {
 		body.AuthToken = headers.Get("Authorization")
+		body.APIKey = headers.Get("X-Api-Key")
 		body.UserAgent = headers.Get("User-Agent")
 	}
 
-	tracer = tracer.WithField("body", rawBody)
-	resp, err := ah.authorizeUser(body, headers, debugLog)
+	tracer = tracer.WithField("body", rawBody.redacted())
+	resp, maxAge, err := ah.authorizeUser(body, headers, debugLog)
 
 	if err != nil {
 		onError(w, tracer, err)
 	} else {
+		setCacheControl(w, maxAge)
 		onSuccess(w, tracer, resp)
 	}
 }
 
 // validate token
-// get account, user, app_id and permissions
-func (ah *authHandler) authorizeUser(data authRequestBody, headers http.Header, debugLog *logrus.Entry) (map[string]string, error) {
+// get account, user, app_id and permissions.
+// It also returns how long Hasura can cache the response
+func (ah *authHandler) authorizeUser(data authRequestBody, headers http.Header, debugLog *logrus.Entry) (map[string]string, time.Duration, error) {
+
+	if data.APIKey != "" {
+		if data.AuthToken != "" {
+			return nil, 0, errors.New("api key and authorization token can't be used together")
+		}
+		return ah.authorizeAPIKey(data.APIKey, debugLog)
+	}
 
 	if data.AuthToken == "" {
 
 		return map[string]string{
 			access.XHasuraRole:        string(access.RoleAnonymous),
 			access.XHasuraCurrentTime: time.Now().UTC().Format(time.RFC3339),
-		}, nil
+		}, 0, nil
 	}
 
 	token := strings.Split(data.AuthToken, " ")
 
 	if len(token) != 2 {
-		return nil, fmt.Errorf("invalid authorization header %s", data.AuthToken)
+		return nil, 0, fmt.Errorf("invalid authorization header %s", data.AuthToken)
 	}
 
-	jwtPayload, err := ah.config.JwtAuth.DecodeToken(token[1])
-
+	jwtAuth := ah.config.JwtAuth
+	jwtPayload, err := jwtAuth.ParseToken(token[1])
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	// refresh and purpose tokens can't be used as access tokens
+	if jwtPayload.Audience != "access" {
+		return nil, 0, errors.New("token_mismatch")
+	}
+
+	impersonatorID := jwtPayload.ImpersonatorID()
+	maxAge := ah.config.env.JWT.VerifyCacheMaxAge
+	if cached, ok := jwtAuth.GetVerifiedToken(jwtPayload); ok {
+		debugLog.Debug("verified token cache hit")
+		if impersonatorID != "" {
+			return ah.auditImpersonatedRequest(jwtPayload.Subject, jwtPayload.SessionID, impersonatorID, cached.Variables, data, headers)
+		}
+		return cached.Variables, cached.MaxAge(maxAge), nil
+	}
+
+	if err := jwtAuth.ValidateToken(jwtPayload); err != nil {
+		return nil, 0, err
 	}
 
 	userId := string(jwtPayload.Subject)
 	accountInfo, err := findAccoutById(userId, ah)
 
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	return map[string]string{
-		access.XHasuraUserID: userId,
-		access.XHasuraRole:   accountInfo["role"],
-	}, nil
+	if err := utils.CheckAccountStatus(accountInfo["status"]); err != nil {
+		return nil, 0, err
+	}
+
+	role, err := ah.applyVerificationPolicy(accountInfo)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	variables := map[string]string{
+		access.XHasuraUserID:    userId,
+		access.XHasuraRole:      role,
+		access.XHasuraSessionID: jwtPayload.SessionID,
+	}
+
+	if impersonatorID != "" {
+		// the impersonator must still be an active admin
+		impersonator, err := findAccoutById(impersonatorID, ah)
+		if err != nil {
+			return nil, 0, err
+		}
+		if impersonator["role"] != string(access.RoleAdmin) || utils.CheckAccountStatus(impersonator["status"]) != nil {
+			return nil, 0, errors.New("impersonator_not_allowed")
+		}
+		variables[access.XHasuraImpersonatorID] = impersonatorID
+	}
+
+	verified := jwtAuth.CacheVerifiedToken(jwtPayload, variables)
+	if impersonatorID != "" {
+		return ah.auditImpersonatedRequest(userId, jwtPayload.SessionID, impersonatorID, verified.Variables, data, headers)
+	}
+
+	return verified.Variables, verified.MaxAge(maxAge), nil
+}
+
+// auditImpersonatedRequest record the request made with an impersonation token.
+// Responses aren't cached by Hasura so every request is audited,
+// and the request is rejected if the audit event can't be written
+func (ah *authHandler) auditImpersonatedRequest(accountID string, sessionID string, impersonatorID string, variables map[string]string, data authRequestBody, headers http.Header) (map[string]string, time.Duration, error) {
+	if ah.config.Audit == nil {
+		return nil, 0, errors.New("impersonation requires the audit log")
+	}
+
+	metadata := map[string]interface{}{
+		"session_id": sessionID,
+	}
+	if operationName, ok := data.Request["operationName"].(string); ok && operationName != "" {
+		metadata["operation_name"] = operationName
+	}
+	if query, ok := data.Request["query"].(string); ok && query != "" {
+		metadata["query"] = query
+	}
+
+	err := ah.config.Audit.Record(context.Background(), audit.Event{
+		ActorID:    impersonatorID,
+		ActorRole:  string(access.RoleAdmin),
+		Action:     actionImpersonatedRequest,
+		TargetType: audit.TargetAccount,
+		TargetID:   accountID,
+		IP:         ah.config.env.Proxies.ClientIP(headers),
+		UserAgent:  data.UserAgent,
+		Metadata:   metadata,
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to record impersonated request: %s", err)
+	}
+
+	return variables, 0, nil
+}
+
+// authorizeAPIKey map the API key to the session variables of its account.
+// The key role replaces the account role if it is still allowed for the account
+func (ah *authHandler) authorizeAPIKey(key string, debugLog *logrus.Entry) (map[string]string, time.Duration, error) {
+	jwtAuth := ah.config.JwtAuth
+	maxAge := ah.config.env.JWT.VerifyCacheMaxAge
+	if cached, ok := jwtAuth.GetVerifiedAPIKey(key); ok {
+		debugLog.Debug("verified api key cache hit")
+		return cached.Variables, cached.MaxAge(maxAge), nil
+	}
+
+	apiKey, err := jwtAuth.VerifyAPIKey(context.Background(), key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	accountInfo, err := findAccoutById(apiKey.AccountID, ah)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := utils.CheckAccountStatus(accountInfo["status"]); err != nil {
+		return nil, 0, err
+	}
+
+	role, err := ah.applyVerificationPolicy(accountInfo)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// the key acts with its own role, which can't exceed the current role of the account
+	if apiKey.Role != role {
+		if !utils.APIKeyRoleAllowed(role, apiKey.Role) {
+			return nil, 0, errors.New("api_key_role_not_allowed")
+		}
+		role = apiKey.Role
+	}
+
+	verified := jwtAuth.CacheVerifiedAPIKey(key, apiKey, map[string]string{
+		access.XHasuraUserID:   apiKey.AccountID,
+		access.XHasuraRole:     role,
+		access.XHasuraAPIKeyID: apiKey.ID,
+	})
+
+	return verified.Variables, verified.MaxAge(maxAge), nil
+}
+
+// setCacheControl let Hasura cache the webhook response for maxAge.
+// Responses which must not be cached are marked as no-store
+func setCacheControl(w http.ResponseWriter, maxAge time.Duration) {
+	seconds := int(maxAge / time.Second)
+	if seconds <= 0 {
+		w.Header().Set("Cache-Control", "no-store")
+		return
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", seconds))
+}
+
+// applyVerificationPolicy return the role of the account with the unverified account policy
+func (ah *authHandler) applyVerificationPolicy(accountInfo map[string]string) (string, error) {
+	return ah.config.env.VerificationRole(accountInfo["role"], accountInfo["email_verified"] == "true")
 }
 
 func findAccoutById(userId string, ah *authHandler) (map[string]string, error) {
 	var query struct {
 		Accounts []struct {
-			Email string `graphql:"email"`
-			Role  string `graphql:"role"`
+			Email           string     `graphql:"email"`
+			Role            string     `graphql:"role"`
+			Status          string     `graphql:"status"`
+			EmailVerifiedAt *time.Time `graphql:"email_verified_at"`
 		} `graphql:"account(where: $where, limit: 1)"`
 	}
 
@@ -125,11 +293,16 @@ func findAccoutById(userId string, ah *authHandler) (map[string]string, error) {
 		return nil, err
 	}
 
+	if len(query.Accounts) == 0 {
+		return nil, errors.New("account not found")
+	}
 	result := query.Accounts[0]
 
 	return map[string]string{
-		"role":  result.Role,
-		"email": result.Email,
+		"role":           result.Role,
+		"email":          result.Email,
+		"status":         result.Status,
+		"email_verified": strconv.FormatBool(result.EmailVerifiedAt != nil),
 	}, nil
 }
 
@@ -143,7 +316,7 @@ func createDebugTracing(r *http.Request) (*logrus.Entry, *tracing.Tracing) {
 		"type":         "authorization",
 		"request_id":   requestId,
 		"method":       r.Method,
-		"http_headers": r.Header,
+		"http_headers": redactHeader(r.Header),
 	}
 	debugLog := logrus.WithFields(fields)
 	tracer := tracing.New(requestId).WithFields(fields)
@@ -180,6 +353,41 @@ func onSuccess(w http.ResponseWriter, tracer *tracing.Tracing, respData map[stri
 	}
 }
 
+// credential headers which are masked in logs
+var redactedHeaders = map[string]bool{
+	"Authorization": true,
+	"X-Api-Key":     true,
+	"Cookie":        true,
+}
+
+// redacted return a copy of the body whose credential headers are masked, so that it can be logged
+func (rb rawAuthRequestBody) redacted() rawAuthRequestBody {
+	headers := make(map[string]string, len(rb.Headers))
+	for k, v := range rb.Headers {
+		if redactedHeaders[http.CanonicalHeaderKey(k)] && v != "" {
+			v = "[REDACTED]"
+		}
+		headers[k] = v
+	}
+
+	return rawAuthRequestBody{
+		Headers: headers,
+		Request: rb.Request,
+	}
+}
+
+// redactHeader return a copy of the header whose credentials are masked
+func redactHeader(header http.Header) http.Header {
+	result := header.Clone()
+	for k := range result {
+		if redactedHeaders[k] {
+			result.Set(k, "[REDACTED]")
+		}
+	}
+
+	return result
+}
+
 func stringMapToHeader(header map[string]string) http.Header {
 	result := make(http.Header)
 	for k, v := range header {
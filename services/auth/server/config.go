@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
+
 	goGql "github.com/hasura/go-graphql-client"
+	"github.com/sirupsen/logrus"
 	"nexlab.tech/core/pkg/gql"
+	"nexlab.tech/core/services/auth/audit"
 	"nexlab.tech/core/services/auth/env"
+	"nexlab.tech/core/services/auth/mailer"
+	"nexlab.tech/core/services/auth/password"
+	"nexlab.tech/core/services/auth/provider"
 	"nexlab.tech/core/services/auth/utils"
 )
 
@@ -11,16 +18,58 @@ type initConfig struct {
 	env        *env.Environment
 	controller *goGql.Client
 	JwtAuth    *utils.JWTAuth
+	Providers  provider.Registry
+	Mailer     mailer.Mailer
+	MailQueue  *mailer.Queue
+	Passwords  *password.Policy
+	Audit      *audit.Store
 }
 
 // NewInitConfig construct global initial configurations
 func NewInitConfig(envVar *env.Environment) (*initConfig, error) {
 
 	controllerClient := gql.NewClient(envVar.ControllerClient)
-	jwtConfig := utils.NewJWTAuth(envVar.JWT, controllerClient)
+	jwtConfig, err := utils.NewJWTAuth(envVar.JWT, controllerClient)
+	if err != nil {
+		return nil, err
+	}
+
+	jwtConfig.SetRolePolicy(envVar.VerificationRole)
+
+	mailService, err := mailer.New(envVar.Mailer)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordPolicy, err := password.New(envVar.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	auditStore := audit.New(controllerClient)
+	jwtConfig.OnSecurityEvent(func(event utils.SecurityEvent) {
+		err := auditStore.Record(context.Background(), audit.Event{
+			ActorID:    event.AccountID,
+			ActorRole:  audit.ActorSystem,
+			Action:     event.Type,
+			TargetType: audit.TargetSession,
+			TargetID:   event.SessionID,
+			Outcome:    audit.OutcomeFailure,
+			Metadata:   event.Metadata,
+		})
+		if err != nil {
+			logrus.Errorf("failed to record security event %s: %s", event.Type, err)
+		}
+	})
+
 	return &initConfig{
 		env:        envVar,
 		controller: controllerClient,
 		JwtAuth:    jwtConfig,
+		Providers:  provider.New(envVar.Providers),
+		Mailer:     mailService,
+		MailQueue:  mailer.NewQueue(mailService, envVar.Mailer.Queue, logrus.StandardLogger()),
+		Passwords:  passwordPolicy,
+		Audit:      auditStore,
 	}, nil
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"nexlab.tech/core/services/auth/audit"
+)
+
+// actionPurgeAccount is the audit action of accounts purged after the deletion grace period
+const actionPurgeAccount = "purgeAccount"
+
+// runAccountPurge purge accounts whose deletion grace period is over on every interval.
+// Every instance runs it, the purge conditions are checked in the delete mutation so concurrent runs are safe
+func runAccountPurge(cfg *initConfig) {
+	interval := cfg.env.JWT.Deletion.PurgeInterval
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purgeAccounts(cfg)
+		<-ticker.C
+	}
+}
+
+// runRecordPrune delete login attempts after the retention and claims of expired single-use tokens on every interval
+func runRecordPrune(cfg *initConfig) {
+	interval := cfg.env.JWT.Login.PruneInterval
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		count, err := cfg.JwtAuth.PruneLoginAttempts(context.Background())
+		if err != nil {
+			logrus.Errorf("failed to prune login attempts: %s", err)
+		} else if count > 0 {
+			logrus.Infof("pruned %d login attempts", count)
+		}
+
+		count, err = cfg.JwtAuth.PruneUsedTokens(context.Background())
+		if err != nil {
+			logrus.Errorf("failed to prune used tokens: %s", err)
+		} else if count > 0 {
+			logrus.Infof("pruned %d used tokens", count)
+		}
+		<-ticker.C
+	}
+}
+
+func purgeAccounts(cfg *initConfig) {
+	ctx := context.Background()
+	ids, err := cfg.JwtAuth.PurgeDeletedAccounts(ctx)
+	if err != nil {
+		logrus.Errorf("failed to purge deleted accounts: %s", err)
+		return
+	}
+
+	for _, id := range ids {
+		err := cfg.Audit.Record(ctx, audit.Event{
+			ActorRole:  audit.ActorSystem,
+			Action:     actionPurgeAccount,
+			TargetType: audit.TargetAccount,
+			TargetID:   id,
+			Outcome:    audit.OutcomeSuccess,
+		})
+		if err != nil {
+			logrus.WithField("account_id", id).Errorf("failed to record account purge: %s", err)
+		}
+	}
+
+	if len(ids) > 0 {
+		logrus.Infof("purged %d deleted accounts", len(ids))
+	}
+}
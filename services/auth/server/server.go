@@ -1,7 +1,16 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -30,6 +39,11 @@ func actionsHandler(cfg *initConfig) gin.HandlerFunc {
 		Env:        cfg.env,
 		Controller: cfg.controller,
 		JwtAuth:    cfg.JwtAuth,
+		Providers:  cfg.Providers,
+		Mailer:     cfg.Mailer,
+		MailQueue:  cfg.MailQueue,
+		Passwords:  cfg.Passwords,
+		Audit:      cfg.Audit,
 	})
 
 	if err != nil {
@@ -47,7 +61,36 @@ func verifyTokenPostHandler(cfg *initConfig) gin.HandlerFunc {
 	}
 }
 
+func jwksHandler(cfg *initConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		jwks, err := cfg.JwtAuth.JWKS()
+		if err != nil {
+			c.JSON(500, gin.H{"message": err.Error()})
+			return
+		}
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(cfg.env.JWT.JWKSMaxAge/time.Second)))
+		c.JSON(200, jwks)
+	}
+}
+
+// printHasuraJWTSecret print the HASURA_GRAPHQL_JWT_SECRET value for the current signing key
+func printHasuraJWTSecret(cfg *initConfig) {
+	secret, err := cfg.JwtAuth.HasuraJWTSecret()
+	if err != nil {
+		log.Fatalf("failed to generate hasura jwt secret: %s", err)
+	}
+
+	bytes, err := json.Marshal(secret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(bytes))
+}
+
 func main() {
+	hasuraJWTSecret := flag.Bool("hasura-jwt-secret", false, "print HASURA_GRAPHQL_JWT_SECRET config and exit")
+	flag.Parse()
+
 	envVar := env.GetEnv()
 	logging.InitLogger(envVar.LogLevel)
 
@@ -58,14 +101,46 @@ func main() {
 		log.Fatalf("failed to initialize service: %s", err)
 	}
 
+	if *hasuraJWTSecret {
+		printHasuraJWTSecret(cfg)
+		return
+	}
+
+	go runAccountPurge(cfg)
+	go runRecordPrune(cfg)
+
 	r := gin.New()
 	r.Use(gin.Recovery())
 
 	r.POST("/events", eventsHandler(cfg))
 	r.POST("/actions", actionsHandler(cfg))
 	r.POST("/verify-token", verifyTokenPostHandler(cfg))
+	r.GET("/.well-known/jwks.json", jwksHandler(cfg))
 	r.GET("/healthz", func(c *gin.Context) {
 		c.JSON(200, version.GetVersion())
 	})
-	log.Fatal(r.Run("0.0.0.0:" + envVar.Port))
+
+	server := &http.Server{
+		Addr:    "0.0.0.0:" + envVar.Port,
+		Handler: r,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// requests in flight and queued emails are finished before the service exits
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+
+	ctx, cancel := context.WithTimeout(context.Background(), envVar.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logrus.Errorf("failed to shut down the server: %s", err)
+	}
+	if err := cfg.MailQueue.Close(ctx); err != nil {
+		logrus.Errorf("failed to send queued emails: %s", err)
+	}
 }
@@ -0,0 +1,110 @@
+// Package totp implements time-based one-time passwords (RFC 6238)
+// compatible with common authenticator apps
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// default parameters which authenticator apps support
+const (
+	DefaultDigits = 6
+	DefaultPeriod = 30 * time.Second
+	// DefaultSkew is the number of periods accepted before and after the current one to tolerate clock drift
+	DefaultSkew = 1
+	secretSize  = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret create a random base32 encoded secret
+func GenerateSecret() (string, error) {
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(secret), nil
+}
+
+// URI return the otpauth:// URI which is encoded in the enrollment QR code
+func URI(issuer string, accountName string, secret string) string {
+	label := url.PathEscape(issuer + ":" + accountName)
+	query := url.Values{
+		"secret":    []string{secret},
+		"issuer":    []string{issuer},
+		"algorithm": []string{"SHA1"},
+		"digits":    []string{fmt.Sprint(DefaultDigits)},
+		"period":    []string{fmt.Sprint(int(DefaultPeriod / time.Second))},
+	}
+
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, query.Encode())
+}
+
+// Counter return the time step of the timestamp
+func Counter(t time.Time) int64 {
+	return t.Unix() / int64(DefaultPeriod/time.Second)
+}
+
+// Code generate the one-time password of the secret at the timestamp
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return hotp(key, uint64(Counter(t)), DefaultDigits), nil
+}
+
+// Validate check the code against the current time step and its skew window.
+// It returns the matched counter so that callers can reject replays of used codes
+func Validate(secret string, code string, t time.Time) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil || len(code) != DefaultDigits {
+		return 0, false
+	}
+
+	current := Counter(t)
+	for i := -DefaultSkew; i <= DefaultSkew; i++ {
+		counter := current + int64(i)
+		expected := hotp(key, uint64(counter), DefaultDigits)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+
+	return 0, false
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	return encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+}
+
+// hotp compute the HMAC-based one-time password (RFC 4226)
+func hotp(key []byte, counter uint64, digits int) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, value%modulo)
+}
@@ -0,0 +1,59 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// test vectors of RFC 6238 appendix B with the SHA1 key
+func TestHOTPVectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	for _, fixture := range []struct {
+		Time int64
+		Code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	} {
+		counter := Counter(time.Unix(fixture.Time, 0))
+		assert.Equal(t, fixture.Code, hotp(key, uint64(counter), 8), fixture.Time)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.Nil(t, err)
+
+	now := time.Unix(1665800000, 0)
+	code, err := Code(secret, now)
+	assert.Nil(t, err)
+	assert.Len(t, code, DefaultDigits)
+
+	counter, ok := Validate(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, Counter(now), counter)
+
+	// the previous period is accepted for clock drift
+	_, ok = Validate(secret, code, now.Add(DefaultPeriod))
+	assert.True(t, ok)
+
+	_, ok = Validate(secret, code, now.Add(3*DefaultPeriod))
+	assert.False(t, ok)
+
+	_, ok = Validate(secret, "abc", now)
+	assert.False(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Nexlab", "foo@example.com", "JBSWY3DPEHPK3PXP")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Nexlab:foo@example.com?"), uri)
+	assert.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+	assert.Contains(t, uri, "issuer=Nexlab")
+}
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+)
+
+// AccountProfile is the profile of the account without credentials
+type AccountProfile struct {
+	ID              string     `graphql:"id" json:"id"`
+	Email           string     `graphql:"email" json:"email"`
+	FullName        string     `graphql:"fullName" json:"fullName"`
+	Phone           *string    `graphql:"phone" json:"phone"`
+	Birthday        *string    `graphql:"birthday" json:"birthday"`
+	AvatarURL       *string    `graphql:"avatar_url" json:"avatar_url"`
+	Role            string     `graphql:"role" json:"role"`
+	Status          string     `graphql:"status" json:"status"`
+	LoginType       string     `graphql:"loginType" json:"loginType"`
+	EmailVerifiedAt *time.Time `graphql:"email_verified_at" json:"email_verified_at"`
+	TOTPEnabledAt   *time.Time `graphql:"totp_enabled_at" json:"totp_enabled_at"`
+	CreatedAt       time.Time  `graphql:"created_at" json:"created_at"`
+	UpdatedAt       time.Time  `graphql:"updated_at" json:"updated_at"`
+}
+
+// FileRecord is the metadata of a file uploaded by the account
+type FileRecord struct {
+	ID        string    `graphql:"id" json:"id"`
+	Name      string    `graphql:"name" json:"name"`
+	Path      string    `graphql:"path" json:"path"`
+	Extension string    `graphql:"extension" json:"extension"`
+	Size      int       `graphql:"size" json:"size"`
+	URL       string    `graphql:"url" json:"url"`
+	Status    string    `graphql:"status" json:"status"`
+	CreatedAt time.Time `graphql:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `graphql:"updatedAt" json:"updatedAt"`
+}
+
+// ShareRecord is a file shared with or by the account
+type ShareRecord struct {
+	FileID    string `graphql:"fileId" json:"fileId"`
+	AccountID string `graphql:"accountId" json:"accountId"`
+	Status    string `graphql:"status" json:"status"`
+	File      struct {
+		Name string `graphql:"name" json:"name"`
+		Path string `graphql:"path" json:"path"`
+	} `graphql:"file" json:"file"`
+	Account struct {
+		Email string `graphql:"email" json:"email"`
+	} `graphql:"account" json:"account"`
+	CreatedAt time.Time `graphql:"createdAt" json:"createdAt"`
+}
+
+// SessionRecord is a device which signed in to the account
+type SessionRecord struct {
+	ID         string     `graphql:"id" json:"id"`
+	UserAgent  *string    `graphql:"user_agent" json:"user_agent"`
+	IP         *string    `graphql:"ip" json:"ip"`
+	CreatedAt  time.Time  `graphql:"created_at" json:"created_at"`
+	LastSeenAt time.Time  `graphql:"last_seen_at" json:"last_seen_at"`
+	RevokedAt  *time.Time `graphql:"revoked_at" json:"revoked_at"`
+}
+
+// LoginRecord is a sign-in attempt of the account
+type LoginRecord struct {
+	IP        *string   `graphql:"ip" json:"ip"`
+	Success   bool      `graphql:"success" json:"success"`
+	CreatedAt time.Time `graphql:"created_at" json:"created_at"`
+}
+
+// AccountData is the personal data of the account which the owner can export
+type AccountData struct {
+	Profile        *AccountProfile      `graphql:"account_by_pk(id: $id)"`
+	Files          []FileRecord         `graphql:"files(where: {createdBy: {_eq: $id}}, order_by: {createdAt: asc})"`
+	SharesReceived []ShareRecord        `graphql:"shares_received: shares(where: {accountId: {_eq: $id}}, order_by: {createdAt: asc})"`
+	SharesGranted  []ShareRecord        `graphql:"shares_granted: shares(where: {file: {createdBy: {_eq: $id}}}, order_by: {createdAt: asc})"`
+	Sessions       []SessionRecord      `graphql:"sessions(where: {account_id: {_eq: $id}}, order_by: {created_at: asc})"`
+	APIKeys        []APIKey             `graphql:"api_keys(where: {account_id: {_eq: $id}}, order_by: {created_at: asc})"`
+	LoginHistory   []LoginRecord        `graphql:"login_attempts(where: {account_id: {_eq: $id}}, order_by: {created_at: asc})"`
+	Passkeys       []WebAuthnCredential `graphql:"webauthn_credentials(where: {account_id: {_eq: $id}}, order_by: {created_at: asc})"`
+}
+
+// GetAccountData collect the personal data of the account.
+// It uses the service client because the data spans tables which user roles can't fully read
+func (ja *JWTAuth) GetAccountData(ctx context.Context, accountID string) (*AccountData, error) {
+	var query AccountData
+
+	variables := map[string]interface{}{
+		"id": graphql.String(accountID),
+	}
+
+	err := ja.controller.Query(ctx, &query, variables, graphql.OperationName("GetAccountData"))
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Profile == nil {
+		return nil, errors.New("account not found")
+	}
+
+	return &query, nil
+}
+
+// WriteArchive write the data as a zip archive of JSON files
+func (ad AccountData) WriteArchive(w io.Writer, exportedAt time.Time) error {
+	entries := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", ad.Profile},
+		{"files.json", ad.Files},
+		{"shares_received.json", ad.SharesReceived},
+		{"shares_granted.json", ad.SharesGranted},
+		{"sessions.json", ad.Sessions},
+		{"api_keys.json", ad.APIKeys},
+		{"login_history.json", ad.LoginHistory},
+		{"passkeys.json", ad.Passkeys},
+	}
+
+	archive := zip.NewWriter(w)
+	for _, entry := range entries {
+		file, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     entry.name,
+			Method:   zip.Deflate,
+			Modified: exportedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(entry.data); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountDataWriteArchive(t *testing.T) {
+	exportedAt := time.Date(2022, 10, 21, 8, 0, 0, 0, time.UTC)
+	data := AccountData{
+		Profile: &AccountProfile{
+			ID:    "acc-1",
+			Email: "foo@example.com",
+		},
+		Files: []FileRecord{
+			{ID: "file-1", Name: "report", Extension: "pdf"},
+		},
+		SharesReceived: []ShareRecord{},
+	}
+
+	var buf bytes.Buffer
+	assert.Nil(t, data.WriteArchive(&buf, exportedAt))
+
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.Nil(t, err)
+
+	contents := map[string][]byte{}
+	for _, file := range reader.File {
+		assert.True(t, file.Modified.Equal(exportedAt))
+		rc, err := file.Open()
+		assert.Nil(t, err)
+		content, err := ioutil.ReadAll(rc)
+		assert.Nil(t, err)
+		rc.Close()
+		contents[file.Name] = content
+	}
+
+	assert.Len(t, contents, 8)
+
+	var profile AccountProfile
+	assert.Nil(t, json.Unmarshal(contents["profile.json"], &profile))
+	assert.Equal(t, "foo@example.com", profile.Email)
+
+	var files []FileRecord
+	assert.Nil(t, json.Unmarshal(contents["files.json"], &files))
+	assert.Equal(t, data.Files, files)
+
+	assert.JSONEq(t, "[]", string(contents["shares_received.json"]))
+	assert.NotContains(t, string(contents["profile.json"]), "password")
+}
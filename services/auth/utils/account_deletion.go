@@ -0,0 +1,215 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+)
+
+// AudienceCancelDeletion is the audience of tokens in account deletion emails
+const AudienceCancelDeletion = "cancel_deletion"
+
+var (
+	errCancelDeletionTokenStale = errors.New("invalid_cancel_deletion_token")
+)
+
+// AccountDeletionConfig holds the schedule of self-service account deletion
+type AccountDeletionConfig struct {
+	// GracePeriod is how long the owner can cancel the deletion before the account is purged
+	GracePeriod time.Duration `envconfig:"ACCOUNT_DELETION_GRACE_PERIOD" default:"720h"`
+	// PurgeInterval is how often the service purges accounts after the grace period, zero disables it
+	PurgeInterval  time.Duration `envconfig:"ACCOUNT_PURGE_INTERVAL" default:"1h"`
+	PurgeBatchSize int           `envconfig:"ACCOUNT_PURGE_BATCH_SIZE" default:"100"`
+}
+
+// ScheduleAccountDeletion mark the active account deleted and schedule the purge after the grace period.
+// Live sessions are revoked. It returns the purge time
+func (ja *JWTAuth) ScheduleAccountDeletion(ctx context.Context, accountID string) (time.Time, error) {
+	now := time.Now()
+	purgeAfter := now.Add(ja.config.Deletion.GracePeriod).Truncate(time.Second)
+
+	var mutation struct {
+		UpdateAccounts struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"update_account(where: $where, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": account_bool_exp{
+			"id": map[string]interface{}{
+				"_eq": accountID,
+			},
+			"status": map[string]interface{}{
+				"_eq": AccountStatusActive,
+			},
+		},
+		"set": account_set_input{
+			"status":      AccountStatusDeleted,
+			"deleted_at":  now,
+			"purge_after": purgeAfter,
+		},
+	}
+
+	err := ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("ScheduleAccountDeletion"))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if mutation.UpdateAccounts.AffectedRows == 0 {
+		return time.Time{}, errors.New("account not found or not active")
+	}
+
+	if _, err := ja.RevokeAccountSessions(ctx, accountID, ""); err != nil {
+		return time.Time{}, err
+	}
+	ja.InvalidateAccountTokens(accountID)
+
+	return purgeAfter, nil
+}
+
+// EncodeCancelDeletionToken create the token of the cancel link which is valid until the purge
+func (ja *JWTAuth) EncodeCancelDeletionToken(accountID string, purgeAfter time.Time) (string, time.Time, error) {
+	// the token is bound to the deletion request so it can't cancel a later request
+	return ja.EncodePurposeToken(AudienceCancelDeletion, accountID, purgeAfter.UTC().Format(time.RFC3339), time.Until(purgeAfter))
+}
+
+// CancelAccountDeletion restore the account with the token of the deletion email during the grace period
+func (ja *JWTAuth) CancelAccountDeletion(ctx context.Context, token string) (string, error) {
+	claims, err := ja.DecodePurposeToken(AudienceCancelDeletion, token)
+	if err != nil {
+		return "", err
+	}
+
+	purgeAfter, err := time.Parse(time.RFC3339, claims.Binding)
+	if err != nil {
+		return "", errCancelDeletionTokenStale
+	}
+
+	var mutation struct {
+		UpdateAccounts struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"update_account(where: $where, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": account_bool_exp{
+			"id": map[string]interface{}{
+				"_eq": claims.Subject,
+			},
+			"status": map[string]interface{}{
+				"_eq": AccountStatusDeleted,
+			},
+			"purge_after": map[string]interface{}{
+				"_gte": purgeAfter,
+				"_lt":  purgeAfter.Add(time.Second),
+				"_gt":  time.Now(),
+			},
+		},
+		"set": account_set_input{
+			"status":      AccountStatusActive,
+			"deleted_at":  nil,
+			"purge_after": nil,
+		},
+	}
+
+	err = ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("CancelAccountDeletion"))
+	if err != nil {
+		return "", err
+	}
+
+	if mutation.UpdateAccounts.AffectedRows == 0 {
+		return "", errCancelDeletionTokenStale
+	}
+	ja.InvalidateAccountTokens(claims.Subject)
+
+	return claims.Subject, nil
+}
+
+// PurgeDeletedAccounts permanently remove a batch of accounts whose grace period is over.
+// Sessions, credentials, files and shares of the accounts are removed by the cascade foreign keys,
+// and references in records of other accounts are cleared. The stored objects of removed files are queued
+// in file_object_deletions for the storage cleanup. Accounts deleted by admins without purge_after are kept.
+// It returns the ids of purged accounts
+func (ja *JWTAuth) PurgeDeletedAccounts(ctx context.Context) ([]string, error) {
+	now := time.Now()
+	where := account_bool_exp{
+		"status": map[string]interface{}{
+			"_eq": AccountStatusDeleted,
+		},
+		"purge_after": map[string]interface{}{
+			"_lte": now,
+		},
+	}
+
+	var query struct {
+		Accounts []struct {
+			ID    string `graphql:"id"`
+			Email string `graphql:"email"`
+		} `graphql:"account(where: $where, limit: $limit, order_by: {purge_after: asc})"`
+	}
+
+	variables := map[string]interface{}{
+		"where": where,
+		"limit": graphql.Int(ja.config.Deletion.PurgeBatchSize),
+	}
+
+	err := ja.controller.Query(ctx, &query, variables, graphql.OperationName("FindAccountsToPurge"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(query.Accounts) == 0 {
+		return []string{}, nil
+	}
+
+	ids := make([]string, len(query.Accounts))
+	emails := make([]string, len(query.Accounts))
+	for i, account := range query.Accounts {
+		ids[i] = account.ID
+		emails[i] = account.Email
+	}
+
+	// the conditions are checked again in case the deletion was cancelled meanwhile
+	where["id"] = map[string]interface{}{
+		"_in": ids,
+	}
+
+	var mutation struct {
+		DeleteLoginAttempts struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"delete_login_attempts(where: $login_attempts)"`
+		DeleteAccounts struct {
+			Returning []struct {
+				ID string `graphql:"id"`
+			} `graphql:"returning"`
+		} `graphql:"delete_account(where: $where)"`
+	}
+
+	mutationVariables := map[string]interface{}{
+		"where": where,
+		// failed logins of the email without account id aren't removed by the cascade
+		"login_attempts": login_attempts_bool_exp{
+			"account_id": map[string]interface{}{
+				"_is_null": true,
+			},
+			"email": map[string]interface{}{
+				"_in": emails,
+			},
+		},
+	}
+
+	err = ja.controller.Mutate(ctx, &mutation, mutationVariables, graphql.OperationName("PurgeAccounts"))
+	if err != nil {
+		return nil, err
+	}
+
+	purged := make([]string, len(mutation.DeleteAccounts.Returning))
+	for i, account := range mutation.DeleteAccounts.Returning {
+		purged[i] = account.ID
+		ja.InvalidateAccountTokens(account.ID)
+	}
+
+	return purged, nil
+}
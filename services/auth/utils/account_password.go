@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hasura/go-graphql-client"
+)
+
+var (
+	errPasswordNotMatch = errors.New("password_not_match")
+)
+
+// VerifyAccountPassword compare the password with the stored hash of the account.
+// Hashes aren't exposed to user roles, so they are read with the service client
+func (ja *JWTAuth) VerifyAccountPassword(ctx context.Context, accountID string, password string) error {
+	var query struct {
+		Account *struct {
+			Password string `graphql:"password"`
+		} `graphql:"account_by_pk(id: $id)"`
+	}
+
+	variables := map[string]interface{}{
+		"id": graphql.String(accountID),
+	}
+
+	err := ja.controller.Query(ctx, &query, variables, graphql.OperationName("GetAccountPassword"))
+	if err != nil {
+		return err
+	}
+
+	if query.Account == nil || query.Account.Password == "" || ja.ComparePassword(query.Account.Password, password) != nil {
+		return errPasswordNotMatch
+	}
+
+	return nil
+}
+
+// SetAccountPassword hash and store the new password of the account
+func (ja *JWTAuth) SetAccountPassword(ctx context.Context, accountID string, password string) error {
+	passwordHashed, err := ja.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	var mutation struct {
+		UpdateAccount struct {
+			ID string `graphql:"id"`
+		} `graphql:"update_account_by_pk(pk_columns: $pk_columns, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"pk_columns": account_pk_columns_input{
+			"id": accountID,
+		},
+		"set": account_set_input{
+			"password": passwordHashed,
+		},
+	}
+
+	return ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("SetAccountPassword"))
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+)
+
+// account statuses.
+// Pending accounts wait for approval, suspended accounts can be reactivated,
+// and deleted accounts are kept for references only
+const (
+	AccountStatusPending   = "pending"
+	AccountStatusActive    = "active"
+	AccountStatusSuspended = "suspended"
+	AccountStatusDeleted   = "deleted"
+)
+
+var (
+	errAccountPending   = errors.New("account_pending")
+	errAccountSuspended = errors.New("account_suspended")
+	errAccountDeleted   = errors.New("account_deleted")
+)
+
+// accountStatusTransitions are the statuses which each status can change to
+var accountStatusTransitions = map[string][]string{
+	AccountStatusPending:   {AccountStatusActive, AccountStatusDeleted},
+	AccountStatusActive:    {AccountStatusSuspended, AccountStatusDeleted},
+	AccountStatusSuspended: {AccountStatusActive, AccountStatusDeleted},
+	AccountStatusDeleted:   {},
+}
+
+// CheckAccountStatus return the error code of accounts which can't sign in
+func CheckAccountStatus(status string) error {
+	switch status {
+	case AccountStatusActive:
+		return nil
+	case AccountStatusPending:
+		return errAccountPending
+	case AccountStatusSuspended:
+		return errAccountSuspended
+	case AccountStatusDeleted:
+		return errAccountDeleted
+	}
+
+	return fmt.Errorf("invalid account status %s", status)
+}
+
+// accountStatusesTo return the statuses which can change to the status
+func accountStatusesTo(status string) []string {
+	var results []string
+	for from, targets := range accountStatusTransitions {
+		for _, target := range targets {
+			if target == status {
+				results = append(results, from)
+			}
+		}
+	}
+	sort.Strings(results)
+
+	return results
+}
+
+// ChangeAccountStatus move the account to the status if the transition is allowed.
+// Live sessions are revoked when the account is no longer active. It returns the number of revoked sessions
+func (ja *JWTAuth) ChangeAccountStatus(ctx context.Context, accountID string, status string) (int, error) {
+	fromStatuses := accountStatusesTo(status)
+	if len(fromStatuses) == 0 {
+		return 0, fmt.Errorf("invalid account status %s", status)
+	}
+
+	set := account_set_input{
+		"status": status,
+	}
+	if status == AccountStatusDeleted {
+		set["deleted_at"] = time.Now()
+	}
+
+	var mutation struct {
+		UpdateAccounts struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"update_account(where: $where, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": account_bool_exp{
+			"id": map[string]interface{}{
+				"_eq": accountID,
+			},
+			"status": map[string]interface{}{
+				"_in": fromStatuses,
+			},
+		},
+		"set": set,
+	}
+
+	err := ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("ChangeAccountStatus"))
+	if err != nil {
+		return 0, err
+	}
+
+	if mutation.UpdateAccounts.AffectedRows == 0 {
+		return 0, errors.New("account not found or the status can't be changed")
+	}
+
+	count := 0
+	if status != AccountStatusActive {
+		count, err = ja.RevokeAccountSessions(ctx, accountID, "")
+		if err != nil {
+			return 0, err
+		}
+	}
+	ja.InvalidateAccountTokens(accountID)
+
+	return count, nil
+}
@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAccountStatus(t *testing.T) {
+	assert.Nil(t, CheckAccountStatus(AccountStatusActive))
+	assert.EqualError(t, CheckAccountStatus(AccountStatusPending), "account_pending")
+	assert.EqualError(t, CheckAccountStatus(AccountStatusSuspended), "account_suspended")
+	assert.EqualError(t, CheckAccountStatus(AccountStatusDeleted), "account_deleted")
+	assert.EqualError(t, CheckAccountStatus(""), "invalid account status ")
+}
+
+func TestAccountStatusTransitions(t *testing.T) {
+	assert.Equal(t, []string{AccountStatusPending, AccountStatusSuspended}, accountStatusesTo(AccountStatusActive))
+	assert.Equal(t, []string{AccountStatusActive}, accountStatusesTo(AccountStatusSuspended))
+	assert.Equal(t, []string{AccountStatusActive, AccountStatusPending, AccountStatusSuspended}, accountStatusesTo(AccountStatusDeleted))
+	assert.Empty(t, accountStatusesTo(AccountStatusPending))
+}
@@ -0,0 +1,278 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+	"nexlab.tech/core/pkg/access"
+)
+
+type api_keys_bool_exp map[string]interface{}
+type api_keys_insert_input map[string]interface{}
+type api_keys_set_input map[string]interface{}
+type api_keys_pk_columns_input map[string]interface{}
+
+// API keys look like nxk_<prefix>_<secret>.
+// The prefix identifies the key in listings and lookups, only the hash of the whole key is stored.
+// Verified keys share the token cache with sessions keyed by apiKeyCachePrefix
+const (
+	apiKeyScheme      = "nxk"
+	apiKeyPrefixBytes = 4
+	apiKeySecretBytes = 24
+	apiKeyCachePrefix = "apikey:"
+)
+
+var errInvalidAPIKey = errors.New("invalid_api_key")
+
+// APIKey is an account-scoped credential for machine-to-machine access.
+// The only restriction of a key is its role, there are no finer scopes
+type APIKey struct {
+	ID         string     `graphql:"id" json:"id"`
+	AccountID  string     `graphql:"account_id" json:"account_id"`
+	Name       string     `graphql:"name" json:"name"`
+	Prefix     string     `graphql:"prefix" json:"prefix"`
+	Role       string     `graphql:"role" json:"role"`
+	ExpiresAt  *time.Time `graphql:"expires_at" json:"expires_at"`
+	LastUsedAt *time.Time `graphql:"last_used_at" json:"last_used_at"`
+	RevokedAt  *time.Time `graphql:"revoked_at" json:"revoked_at"`
+	CreatedAt  time.Time  `graphql:"created_at" json:"created_at"`
+}
+
+// APIKeyInput is the settings of a new API key
+type APIKeyInput struct {
+	Name      string
+	Role      string
+	ExpiresAt *time.Time
+}
+
+// APIKeyRoleAllowed check if the key role doesn't exceed the account role.
+// Admins can issue keys restricted to the user role
+func APIKeyRoleAllowed(accountRole string, keyRole string) bool {
+	return keyRole == accountRole ||
+		(accountRole == string(access.RoleAdmin) && keyRole == string(access.RoleUser))
+}
+
+// CreateAPIKey issue a new API key of the account. The plain key is only returned here
+func (ja *JWTAuth) CreateAPIKey(ctx context.Context, accountID string, input APIKeyInput) (string, *APIKey, error) {
+	prefixBytes := make([]byte, apiKeyPrefixBytes)
+	secretBytes := make([]byte, apiKeySecretBytes)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", nil, err
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", nil, err
+	}
+
+	prefix := hex.EncodeToString(prefixBytes)
+	key := strings.Join([]string{apiKeyScheme, prefix, hex.EncodeToString(secretBytes)}, "_")
+
+	object := api_keys_insert_input{
+		"account_id": accountID,
+		"name":       input.Name,
+		"prefix":     prefix,
+		"key_hash":   hashOpaqueToken(key),
+		"role":       input.Role,
+		"expires_at": input.ExpiresAt,
+	}
+
+	var mutation struct {
+		InsertAPIKey APIKey `graphql:"insert_api_keys_one(object: $object)"`
+	}
+
+	variables := map[string]interface{}{
+		"object": object,
+	}
+
+	err := ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("CreateAPIKey"))
+	if err != nil {
+		return "", nil, err
+	}
+
+	return key, &mutation.InsertAPIKey, nil
+}
+
+// ListAPIKeys return the keys of the account, including revoked and expired ones
+func (ja *JWTAuth) ListAPIKeys(ctx context.Context, accountID string) ([]APIKey, error) {
+	var query struct {
+		APIKeys []APIKey `graphql:"api_keys(where: $where, order_by: {created_at: desc})"`
+	}
+
+	variables := map[string]interface{}{
+		"where": api_keys_bool_exp{
+			"account_id": map[string]interface{}{
+				"_eq": accountID,
+			},
+		},
+	}
+
+	err := ja.controller.Query(ctx, &query, variables, graphql.OperationName("ListAPIKeys"))
+	if err != nil {
+		return nil, err
+	}
+
+	if query.APIKeys == nil {
+		return []APIKey{}, nil
+	}
+
+	return query.APIKeys, nil
+}
+
+// RevokeAPIKey revoke the key of the account. Any account's key is revoked if accountID is empty
+func (ja *JWTAuth) RevokeAPIKey(ctx context.Context, accountID string, keyID string) (bool, error) {
+	where := api_keys_bool_exp{
+		"id": map[string]interface{}{
+			"_eq": keyID,
+		},
+		"revoked_at": map[string]interface{}{
+			"_is_null": true,
+		},
+	}
+	if accountID != "" {
+		where["account_id"] = map[string]interface{}{
+			"_eq": accountID,
+		}
+	}
+
+	var mutation struct {
+		UpdateAPIKeys struct {
+			Returning []struct {
+				AccountID string `graphql:"account_id"`
+			} `graphql:"returning"`
+		} `graphql:"update_api_keys(where: $where, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": where,
+		"set": api_keys_set_input{
+			"revoked_at": time.Now(),
+		},
+	}
+
+	err := ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("RevokeAPIKey"))
+	if err != nil {
+		return false, err
+	}
+
+	for _, item := range mutation.UpdateAPIKeys.Returning {
+		ja.invalidateVerifiedTokens(item.AccountID, apiKeyCachePrefix+keyID, "")
+	}
+
+	return len(mutation.UpdateAPIKeys.Returning) > 0, nil
+}
+
+// VerifyAPIKey find the active key and record its usage
+func (ja *JWTAuth) VerifyAPIKey(ctx context.Context, key string) (*APIKey, error) {
+	parts := strings.Split(key, "_")
+	if len(parts) != 3 || parts[0] != apiKeyScheme || parts[1] == "" || parts[2] == "" {
+		return nil, errInvalidAPIKey
+	}
+
+	var query struct {
+		APIKeys []struct {
+			APIKey
+			KeyHash string `graphql:"key_hash"`
+		} `graphql:"api_keys(where: $where, limit: 1)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": api_keys_bool_exp{
+			"prefix": map[string]interface{}{
+				"_eq": parts[1],
+			},
+		},
+	}
+
+	err := ja.controller.Query(ctx, &query, variables, graphql.OperationName("FindAPIKey"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(query.APIKeys) == 0 {
+		return nil, errInvalidAPIKey
+	}
+
+	item := query.APIKeys[0]
+	if subtle.ConstantTimeCompare([]byte(item.KeyHash), []byte(hashOpaqueToken(key))) != 1 ||
+		item.RevokedAt != nil ||
+		(item.ExpiresAt != nil && !item.ExpiresAt.After(time.Now())) {
+		return nil, errInvalidAPIKey
+	}
+
+	if item.LastUsedAt == nil || time.Since(*item.LastUsedAt) > sessionTouchInterval {
+		if err := ja.touchAPIKey(ctx, item.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return &item.APIKey, nil
+}
+
+func (ja *JWTAuth) touchAPIKey(ctx context.Context, keyID string) error {
+	var mutation struct {
+		UpdateAPIKey struct {
+			ID string `graphql:"id"`
+		} `graphql:"update_api_keys_by_pk(pk_columns: $pk_columns, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"pk_columns": api_keys_pk_columns_input{
+			"id": keyID,
+		},
+		"set": api_keys_set_input{
+			"last_used_at": time.Now(),
+		},
+	}
+
+	return ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("TouchAPIKey"))
+}
+
+// GetVerifiedAPIKey return the cached session variables of the API key
+func (ja *JWTAuth) GetVerifiedAPIKey(key string) (*VerifiedToken, bool) {
+	if ja.verifyCache == nil {
+		return nil, false
+	}
+
+	cacheKey := apiKeyCachePrefix + hashOpaqueToken(key)
+	value, ok := ja.verifyCache.Get(cacheKey)
+	if !ok {
+		return nil, false
+	}
+
+	result := value.(*VerifiedToken)
+	if !result.ExpiresAt.After(time.Now()) {
+		ja.verifyCache.Delete(cacheKey)
+		return nil, false
+	}
+
+	return result, true
+}
+
+// CacheVerifiedAPIKey store the session variables of the verified key until the cache TTL or key expiry
+func (ja *JWTAuth) CacheVerifiedAPIKey(key string, apiKey *APIKey, variables map[string]string) *VerifiedToken {
+	expiresAt := time.Now().Add(ja.config.VerifyCacheTTL)
+	if apiKey.ExpiresAt != nil && apiKey.ExpiresAt.Before(expiresAt) {
+		expiresAt = *apiKey.ExpiresAt
+	}
+
+	result := &VerifiedToken{
+		AccountID: apiKey.AccountID,
+		SessionID: apiKeyCachePrefix + apiKey.ID,
+		Variables: variables,
+		ExpiresAt: expiresAt,
+	}
+	if ja.verifyCache == nil {
+		return result
+	}
+
+	if ttl := time.Until(expiresAt); ttl > 0 {
+		ja.verifyCache.Set(apiKeyCachePrefix+hashOpaqueToken(key), result, ttl)
+	}
+
+	return result
+}
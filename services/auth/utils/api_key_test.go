@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeyRoleAllowed(t *testing.T) {
+	for _, fixture := range []struct {
+		AccountRole string
+		KeyRole     string
+		Allowed     bool
+	}{
+		{"user", "user", true},
+		{"admin", "admin", true},
+		{"admin", "user", true},
+		{"user", "admin", false},
+		{"unverified", "user", false},
+		{"admin", "anonymous", false},
+	} {
+		assert.Equal(t, fixture.Allowed, APIKeyRoleAllowed(fixture.AccountRole, fixture.KeyRole), "%s -> %s", fixture.AccountRole, fixture.KeyRole)
+	}
+}
+
+func TestVerifyAPIKeyFormat(t *testing.T) {
+	ja := &JWTAuth{}
+	for _, key := range []string{"", "secret", "nxk_abcd", "nxk__secret", "pk_abcd_secret", "nxk_abcd_secret_more"} {
+		_, err := ja.VerifyAPIKey(context.Background(), key)
+		assert.Equal(t, errInvalidAPIKey, err, key)
+	}
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jose "github.com/dvsekhvalnov/jose2go"
+	"github.com/hasura/go-graphql-client"
+)
+
+// fakeController serves canned GraphQL data by operation name
+// and records the variables of every request
+type fakeController struct {
+	responses map[string]string
+	requests  map[string][]map[string]interface{}
+	lock      sync.Mutex
+}
+
+func newFakeController(t *testing.T, responses map[string]string) (*fakeController, *graphql.Client) {
+	fake := &fakeController{
+		responses: responses,
+		requests:  map[string][]map[string]interface{}{},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Query     string                 `json:"query"`
+			Variables map[string]interface{} `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// the query starts with the operation type and name, e.g. mutation ConsumeMagicLink($where: ...)
+		fields := strings.FieldsFunc(body.Query, func(c rune) bool {
+			return c == ' ' || c == '(' || c == '{'
+		})
+		name := ""
+		if len(fields) > 1 {
+			name = fields[1]
+		}
+
+		fake.lock.Lock()
+		fake.requests[name] = append(fake.requests[name], body.Variables)
+		data, ok := fake.responses[name]
+		fake.lock.Unlock()
+
+		if !ok {
+			fmt.Fprintf(w, `{"errors":[{"message":"unexpected operation %s"}]}`, name)
+			return
+		}
+		fmt.Fprintf(w, `{"data":%s}`, data)
+	}))
+	t.Cleanup(server.Close)
+
+	return fake, graphql.NewClient(server.URL, server.Client())
+}
+
+// variables return the variables of the requests of the operation
+func (fc *fakeController) variables(name string) []map[string]interface{} {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	return fc.requests[name]
+}
+
+// newTestJWTAuth create the auth service with a static HMAC key and the controller
+func newTestJWTAuth(t *testing.T, controller *graphql.Client, config JWTAuthConfig) *JWTAuth {
+	key, err := newSigningKey(jose.HS256, "test", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.Issuer = "test"
+	config.KeyReloadInterval = time.Hour
+
+	return &JWTAuth{
+		config:       config,
+		controller:   controller,
+		bootstrapKey: key,
+		ring:         newKeyRing(key),
+	}
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	jose "github.com/dvsekhvalnov/jose2go"
+)
+
+// EdDSA is the JWS algorithm name of Ed25519 signatures (RFC 8037)
+const EdDSA = "EdDSA"
+
+func init() {
+	jose.RegisterJws(&edDSA{})
+}
+
+// edDSA implements the EdDSA signing algorithm which isn't shipped with jose2go
+type edDSA struct{}
+
+func (alg *edDSA) Name() string {
+	return EdDSA
+}
+
+func (alg *edDSA) Verify(securedInput, signature []byte, key interface{}) error {
+	pubKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return errors.New("EdDSA.Verify(): expects key to be 'ed25519.PublicKey'")
+	}
+
+	if !ed25519.Verify(pubKey, securedInput, signature) {
+		return errors.New("EdDSA.Verify(): Signature is not valid.")
+	}
+
+	return nil
+}
+
+func (alg *edDSA) Sign(securedInput []byte, key interface{}) ([]byte, error) {
+	privKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("EdDSA.Sign(): expects key to be 'ed25519.PrivateKey'")
+	}
+
+	return ed25519.Sign(privKey, securedInput), nil
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+)
+
+// HasuraClaims represents session variables which Hasura reads from the access token
+type HasuraClaims struct {
+	DefaultRole  string   `json:"x-hasura-default-role"`
+	AllowedRoles []string `json:"x-hasura-allowed-roles"`
+	UserID       string   `json:"x-hasura-user-id"`
+	SessionID    string   `json:"x-hasura-session-id,omitempty"`
+}
+
+// SetRolePolicy set the policy which maps the account role to the role of its session,
+// e.g. the restricted role of accounts which haven't verified the email yet.
+// Hasura claims embed the result, so it must match the webhook
+func (ja *JWTAuth) SetRolePolicy(policy func(role string, emailVerified bool) (string, error)) {
+	ja.rolePolicy = policy
+}
+
+// hasuraClaims build the Hasura claims of the account session.
+// The claims are checked when the token is issued only, so the account status and role policy
+// are applied here like the webhook does on every request
+func (ja *JWTAuth) hasuraClaims(ctx context.Context, uid string, sessionID string) (*HasuraClaims, error) {
+	var query struct {
+		Account *struct {
+			ID              string     `graphql:"id"`
+			Role            string     `graphql:"role"`
+			Status          string     `graphql:"status"`
+			EmailVerifiedAt *time.Time `graphql:"email_verified_at"`
+		} `graphql:"account_by_pk(id: $id)"`
+	}
+
+	variables := map[string]interface{}{
+		"id": graphql.String(uid),
+	}
+
+	err := ja.controller.Query(ctx, &query, variables, graphql.OperationName("GetAccountRole"))
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Account == nil {
+		return nil, errors.New("account not found")
+	}
+
+	if err := CheckAccountStatus(query.Account.Status); err != nil {
+		return nil, err
+	}
+
+	role := query.Account.Role
+	if ja.rolePolicy != nil {
+		role, err = ja.rolePolicy(role, query.Account.EmailVerifiedAt != nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &HasuraClaims{
+		DefaultRole:  role,
+		AllowedRoles: []string{role},
+		UserID:       uid,
+		SessionID:    sessionID,
+	}, nil
+}
+
+// HasuraJWTSecret generate the HASURA_GRAPHQL_JWT_SECRET config
+// so that Hasura verifies access tokens without calling the webhook.
+// Hasura fetches the public keys from the JWKS url to follow key rotation.
+// Revoked sessions and suspended accounts keep access until their access tokens expire in this mode
+func (ja *JWTAuth) HasuraJWTSecret() (map[string]interface{}, error) {
+	if isHMACAlgorithm(ja.config.Algorithm) {
+		return nil, fmt.Errorf("hasura jwt mode requires an asymmetric algorithm, %s secrets can't be published", ja.config.Algorithm)
+	}
+	if ja.config.JWKSURL == "" {
+		return nil, errors.New("JWT_JWKS_URL is required")
+	}
+
+	result := map[string]interface{}{
+		"jwk_url":  ja.config.JWKSURL,
+		"audience": "access",
+	}
+	if ja.config.Issuer != "" {
+		result["issuer"] = ja.config.Issuer
+	}
+
+	return result, nil
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hasura/go-graphql-client"
+)
+
+var errImpersonationJWTMode = errors.New("impersonation is not available in hasura jwt mode")
+
+// ImpersonationConfig holds the lifetime of impersonation tokens
+type ImpersonationConfig struct {
+	// DefaultTTL is the lifetime of impersonation tokens when the admin doesn't request one
+	DefaultTTL time.Duration `envconfig:"IMPERSONATION_TTL" default:"15m"`
+	// MaxTTL is the longest lifetime which the admin can request
+	MaxTTL time.Duration `envconfig:"IMPERSONATION_MAX_TTL" default:"1h"`
+}
+
+// ttl return the lifetime of the token with the requested value
+func (ic ImpersonationConfig) ttl(requested time.Duration) (time.Duration, error) {
+	if requested < 0 {
+		return 0, fmt.Errorf("invalid impersonation ttl %s", requested)
+	}
+	if requested == 0 {
+		requested = ic.DefaultTTL
+	}
+	if ic.MaxTTL > 0 && requested > ic.MaxTTL {
+		return 0, fmt.Errorf("impersonation ttl must not exceed %s", ic.MaxTTL)
+	}
+
+	return requested, nil
+}
+
+// ActorClaim identifies the party acting on behalf of the token subject (RFC 8693)
+type ActorClaim struct {
+	Subject string `json:"sub"`
+}
+
+// ImpersonatorID return the admin id of impersonation tokens
+func (jp jwtPayload) ImpersonatorID() string {
+	if jp.Actor == nil {
+		return ""
+	}
+	return jp.Actor.Subject
+}
+
+// ImpersonationToken is the access token of an impersonation session
+type ImpersonationToken struct {
+	*AccessToken
+	SessionID string    `json:"session_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Impersonate start a session of the account on behalf of the admin.
+// The access token carries the act claim and can't be refreshed, so the session ends with the token.
+// Impersonation is refused in Hasura JWT mode, because the webhook enforces and audits
+// the restrictions of impersonation sessions
+func (ja *JWTAuth) Impersonate(ctx context.Context, impersonatorID string, accountID string, reason string, ttl time.Duration, info SessionInfo) (*ImpersonationToken, error) {
+	if ja.config.HasuraClaims {
+		return nil, errImpersonationJWTMode
+	}
+
+	ttl, err := ja.config.Impersonation.ttl(ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	var mutation struct {
+		InsertSession struct {
+			ID string `graphql:"id"`
+		} `graphql:"insert_sessions_one(object: $object)"`
+	}
+
+	variables := map[string]interface{}{
+		"object": sessions_insert_input{
+			"account_id":           accountID,
+			"impersonator_id":      impersonatorID,
+			"impersonation_reason": reason,
+			"user_agent":           info.UserAgent,
+			"ip":                   info.IP,
+		},
+	}
+
+	err = ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("CreateImpersonationSession"))
+	if err != nil {
+		return nil, err
+	}
+	sessionID := mutation.InsertSession.ID
+
+	now := time.Now()
+	exp := now.Add(ttl)
+	payload := jwtPayload{
+		JwtID:          uuid.New().String(),
+		Issuer:         ja.config.Issuer,
+		Subject:        accountID,
+		Audience:       "access",
+		IssuedAt:       now.Unix(),
+		NotBeforeTime:  now.Unix(),
+		ExpirationTime: exp.Unix(),
+		SessionID:      sessionID,
+		Actor: &ActorClaim{
+			Subject: impersonatorID,
+		},
+	}
+
+	token, err := ja.sign(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ImpersonationToken{
+		AccessToken: &AccessToken{
+			AccessToken: token,
+			TokenType:   "jwt",
+			ExpiresIn:   int(ttl / time.Second),
+		},
+		SessionID: sessionID,
+		ExpiresAt: exp,
+	}, nil
+}
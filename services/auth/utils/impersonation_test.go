@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImpersonationTTL(t *testing.T) {
+	config := ImpersonationConfig{
+		DefaultTTL: 15 * time.Minute,
+		MaxTTL:     time.Hour,
+	}
+
+	ttl, err := config.ttl(0)
+	assert.Nil(t, err)
+	assert.Equal(t, 15*time.Minute, ttl)
+
+	ttl, err = config.ttl(5 * time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 5*time.Minute, ttl)
+
+	_, err = config.ttl(2 * time.Hour)
+	assert.EqualError(t, err, "impersonation ttl must not exceed 1h0m0s")
+
+	_, err = config.ttl(-time.Minute)
+	assert.EqualError(t, err, "invalid impersonation ttl -1m0s")
+}
+
+func TestImpersonatorID(t *testing.T) {
+	var payload jwtPayload
+	assert.Nil(t, json.Unmarshal([]byte(`{"sub":"user","act":{"sub":"admin"}}`), &payload))
+	assert.Equal(t, "admin", payload.ImpersonatorID())
+
+	payload = jwtPayload{Subject: "user"}
+	assert.Equal(t, "", payload.ImpersonatorID())
+
+	raw, err := json.Marshal(payload)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(raw), `"act"`)
+}
@@ -5,19 +5,19 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
 	"time"
 
 	jose "github.com/dvsekhvalnov/jose2go"
 	"github.com/google/uuid"
 	"github.com/hasura/go-graphql-client"
 	"golang.org/x/crypto/bcrypt"
-	"nexlab.tech/core/pkg/util"
+	"nexlab.tech/core/pkg/cache"
+	"nexlab.tech/core/services/auth/webauthn"
 )
 
-type account_bool_exp map[string]interface{}
-type account_set_input map[string]interface{}
-type account_pk_columns_input map[string]interface{}
-
 type jwtPayload struct {
 	Issuer         string `json:"iss"`
 	Subject        string `json:"sub"`
@@ -26,7 +26,14 @@ type jwtPayload struct {
 	NotBeforeTime  int64  `json:"nbt"`
 	IssuedAt       int64  `json:"iat"`
 	JwtID          string `json:"jti"`
-	RandomHash     string `json:"rdh"`
+	SessionID      string `json:"sid"`
+	// Binding is the state which a purpose token is bound to, e.g. the email address to verify.
+	// The token is rejected once the state changes
+	Binding string `json:"bnd,omitempty"`
+	// Actor is the admin who acts on behalf of the subject in impersonation tokens
+	Actor *ActorClaim `json:"act,omitempty"`
+	// Hasura claims are set to access tokens in Hasura JWT mode only
+	HasuraClaims *HasuraClaims `json:"https://hasura.io/jwt/claims,omitempty"`
 }
 
 type AccessToken struct {
@@ -44,67 +51,198 @@ type JWTAuthConfig struct {
 	RefreshTTL time.Duration `envconfig:"SESSION_REFRESH_TTL" default:"0ms"`
 	Issuer     string        `envconfig:"JWT_ISSUER"`
 	Algorithm  string        `envconfig:"JWT_ALGORITHM" default:"HS256"`
+	// PEM encoded private key for asymmetric algorithms (RS*, PS*, ES*, EdDSA)
+	PrivateKey     string `envconfig:"JWT_PRIVATE_KEY"`
+	PrivateKeyFile string `envconfig:"JWT_PRIVATE_KEY_FILE"`
+	// KeyID is set to the kid header. Default is the JWK thumbprint of the key
+	KeyID string `envconfig:"JWT_KEY_ID"`
+	// previous keys keep verifying tokens after the configured key is replaced
+	PreviousSessionKeys []string `envconfig:"SESSION_PREVIOUS_KEYS"`
+	PreviousKeyFiles    []string `envconfig:"JWT_PREVIOUS_KEY_FILES"`
+	// KeyOverlap is how long a rotated key keeps verifying tokens. Default is the longest token TTL
+	KeyOverlap        time.Duration `envconfig:"JWT_KEY_OVERLAP"`
+	KeyReloadInterval time.Duration `envconfig:"JWT_KEY_RELOAD_INTERVAL" default:"1m"`
+	// HasuraClaims add the Hasura claims namespace to access tokens
+	// so that Hasura can verify them in stateless JWT mode
+	HasuraClaims bool `envconfig:"JWT_HASURA_CLAIMS" default:"false"`
+	// JWKSURL is the public JWKS endpoint of this service which Hasura fetches in JWT mode
+	JWKSURL string `envconfig:"JWT_JWKS_URL"`
+	// JWKSMaxAge is the Cache-Control max-age of the JWKS endpoint.
+	// Rotated keys are published in JWKS for this long and a reload interval before they sign tokens
+	JWKSMaxAge time.Duration `envconfig:"JWT_JWKS_MAX_AGE" default:"5m"`
+	// verified access tokens are cached in memory by jti to skip database lookups of the webhook.
+	// Revocations on other instances take effect after the TTL at most
+	VerifyCacheTTL  time.Duration `envconfig:"VERIFY_CACHE_TTL" default:"30s"`
+	VerifyCacheSize int           `envconfig:"VERIFY_CACHE_SIZE" default:"10000"`
+	// VerifyCacheMaxAge is the Cache-Control max-age of webhook responses which Hasura caches.
+	// Hasura's cache can't be invalidated, so revoked tokens are accepted until it expires. Zero disables it
+	VerifyCacheMaxAge time.Duration `envconfig:"VERIFY_CACHE_MAX_AGE" default:"0s"`
+	// PasswordResetTTL is how long password reset tokens are valid
+	PasswordResetTTL time.Duration `envconfig:"PASSWORD_RESET_TTL" default:"30m"`
+	// PasswordResetMaxPerAccount is the number of reset emails which an account can receive
+	// during PasswordResetRateWindow, zero disables it
+	PasswordResetMaxPerAccount int `envconfig:"PASSWORD_RESET_MAX_PER_ACCOUNT" default:"3"`
+	// PasswordResetMaxPerIP is the number of resets which an IP address can request during PasswordResetRateWindow, zero disables it
+	PasswordResetMaxPerIP   int           `envconfig:"PASSWORD_RESET_MAX_PER_IP" default:"20"`
+	PasswordResetRateWindow time.Duration `envconfig:"PASSWORD_RESET_RATE_WINDOW" default:"1h"`
+	// EmailVerificationTTL is how long email verification links are valid
+	EmailVerificationTTL time.Duration `envconfig:"EMAIL_VERIFICATION_TTL" default:"24h"`
+	// MFATokenTTL is how long the login challenge waits for the second factor
+	MFATokenTTL time.Duration `envconfig:"MFA_TOKEN_TTL" default:"5m"`
+	// TOTPIssuer is the name displayed by authenticator apps
+	TOTPIssuer string `envconfig:"TOTP_ISSUER" default:"Nexlab"`
+	// Login holds the brute-force protection thresholds
+	Login LoginThrottleConfig
+	// PasswordHash holds the algorithm of new password hashes
+	PasswordHash PasswordHashConfig
+	// Deletion holds the grace period and purge schedule of deleted accounts
+	Deletion AccountDeletionConfig
+	// Impersonation holds the lifetime of impersonation tokens
+	Impersonation ImpersonationConfig
+	// MagicLink holds the lifetime and rate limits of passwordless login links
+	MagicLink MagicLinkConfig
+	// WebAuthn holds the relying party of passkeys
+	WebAuthn WebAuthnConfig
 }
 
 func (jac JWTAuthConfig) Validate() error {
-	if jac.SessionKey == "" {
-		return errors.New("SESSION_KEY is required")
+	if isHMACAlgorithm(jac.Algorithm) {
+		if jac.SessionKey == "" {
+			return errors.New("SESSION_KEY is required")
+		}
+	} else if jac.PrivateKey == "" && jac.PrivateKeyFile == "" {
+		return fmt.Errorf("JWT_PRIVATE_KEY or JWT_PRIVATE_KEY_FILE is required for %s algorithm", jac.Algorithm)
 	}
 	if jac.Issuer == "" {
 		return errors.New("JWT_ISSUER is required")
 	}
+	// Hasura fetches the public keys from JWKS, HMAC secrets must not leave the service
+	if jac.HasuraClaims {
+		if isHMACAlgorithm(jac.Algorithm) {
+			return fmt.Errorf("JWT_HASURA_CLAIMS requires an asymmetric algorithm, got %s", jac.Algorithm)
+		}
+		if jac.JWKSURL == "" {
+			return errors.New("JWT_JWKS_URL is required with JWT_HASURA_CLAIMS")
+		}
+	}
 
 	return nil
 }
 
+// loadSigningKey read the configured signing key
+func (jac JWTAuthConfig) loadSigningKey() (*signingKey, error) {
+	if isHMACAlgorithm(jac.Algorithm) {
+		return newSigningKey(jac.Algorithm, jac.KeyID, []byte(jac.SessionKey))
+	}
+
+	content := []byte(jac.PrivateKey)
+	if jac.PrivateKeyFile != "" {
+		var err error
+		content, err = ioutil.ReadFile(jac.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT_PRIVATE_KEY_FILE: %s", err)
+		}
+	}
+
+	return newSigningKey(jac.Algorithm, jac.KeyID, content)
+}
+
 type JWTAuth struct {
-	config     JWTAuthConfig
-	controller *graphql.Client
+	config       JWTAuthConfig
+	controller   *graphql.Client
+	bootstrapKey *signingKey
+	previousKeys []*signingKey
+	ring         *keyRing
+	keyLock      sync.RWMutex
+	verifyCache  *cache.LRU
+	hashers      *passwordHashers
+	// relying party of passkeys, nil if WebAuthn is disabled
+	webauthn *webauthn.RelyingParty
+	// callbacks of security events
+	securityHooks []func(event SecurityEvent)
+	// maps the account role to the role of Hasura claims
+	rolePolicy func(role string, emailVerified bool) (string, error)
 }
 
-func NewJWTAuth(config JWTAuthConfig, controller *graphql.Client) *JWTAuth {
+func NewJWTAuth(config JWTAuthConfig, controller *graphql.Client) (*JWTAuth, error) {
 	if config.Cost == 0 {
 		config.Cost = bcrypt.DefaultCost
 	}
 	if config.Algorithm == "" {
 		config.Algorithm = jose.HS256
 	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 
-	return &JWTAuth{
-		config:     config,
-		controller: controller,
+	key, err := config.loadSigningKey()
+	if err != nil {
+		return nil, err
 	}
-}
 
-func (ja *JWTAuth) EncryptPassword(password string) ([]byte, error) {
-	return bcrypt.GenerateFromPassword([]byte(password), ja.config.Cost)
-}
+	previousKeys, err := config.loadPreviousKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	hashers, err := config.newPasswordHashers()
+	if err != nil {
+		return nil, err
+	}
+
+	relyingParty, err := config.WebAuthn.newRelyingParty()
+	if err != nil {
+		return nil, err
+	}
 
-func (ja *JWTAuth) EncodeToken(uid string) (*AccessToken, error) {
+	return &JWTAuth{
+		config:       config,
+		controller:   controller,
+		bootstrapKey: key,
+		previousKeys: previousKeys,
+		verifyCache:  config.newVerifyCache(),
+		hashers:      hashers,
+		webauthn:     relyingParty,
+	}, nil
+}
 
-	var query struct {
-		Accounts []struct {
-			ID         string `graphql:"id"`
-			RandomHash string `graphql:"randomHash"`
-		} `graphql:"account(where: $where, limit: 1)"`
+// JWKS return the public keys that verify tokens issued by this service.
+// The set is empty for HMAC algorithms because the shared secret must not be published
+func (ja *JWTAuth) JWKS() (*JSONWebKeySet, error) {
+	result := &JSONWebKeySet{
+		Keys: []JSONWebKey{},
 	}
 
-	variables := map[string]interface{}{
-		"where": account_bool_exp{
-			"id": map[string]interface{}{
-				"_eq": uid,
-			},
-		},
+	ring := ja.keyRing()
+	for _, key := range ring.verifiers {
+		if key.IsSymmetric() {
+			continue
+		}
+		jwk, err := key.JWK()
+		if err != nil {
+			return nil, err
+		}
+		result.Keys = append(result.Keys, *jwk)
 	}
+	sort.Slice(result.Keys, func(i, j int) bool {
+		return result.Keys[i].KeyID < result.Keys[j].KeyID
+	})
 
-	err := ja.controller.Query(context.Background(), &query, variables)
+	return result, nil
+}
 
+// EncodeToken start a new session of the account on the device and issue its tokens
+func (ja *JWTAuth) EncodeToken(uid string, info SessionInfo) (*AccessToken, error) {
+	sessionID, err := ja.createSession(context.Background(), uid, info)
 	if err != nil {
 		return nil, err
 	}
 
-	randomHash := query.Accounts[0].RandomHash
+	return ja.encodeSessionToken(uid, sessionID)
+}
 
+// encodeSessionToken issue access and refresh tokens of the existing session
+func (ja *JWTAuth) encodeSessionToken(uid string, sessionID string) (*AccessToken, error) {
 	now := time.Now()
 	exp := now.Add(ja.config.TTL)
 	jwtID := uuid.New().String()
@@ -116,18 +254,18 @@ func (ja *JWTAuth) EncodeToken(uid string) (*AccessToken, error) {
 		IssuedAt:       now.Unix(),
 		NotBeforeTime:  now.Unix(),
 		ExpirationTime: exp.Unix(),
-		RandomHash:     randomHash,
+		SessionID:      sessionID,
 	}
 
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		return nil, err
+	if ja.config.HasuraClaims {
+		claims, err := ja.hasuraClaims(context.Background(), uid, sessionID)
+		if err != nil {
+			return nil, err
+		}
+		payload.HasuraClaims = claims
 	}
-	token, err := jose.SignBytes(payloadBytes, ja.config.Algorithm, []byte(ja.config.SessionKey),
-		jose.Header("typ", "JWT"),
-		jose.Header("alg", ja.config.Algorithm),
-	)
 
+	token, err := ja.sign(payload)
 	if err != nil {
 		return nil, err
 	}
@@ -135,6 +273,7 @@ func (ja *JWTAuth) EncodeToken(uid string) (*AccessToken, error) {
 	// encode refresh token if the expiry is set
 	var refreshToken string
 	if ja.config.RefreshTTL >= ja.config.TTL {
+		refreshExp := now.Add(ja.config.RefreshTTL)
 		refreshPayload := jwtPayload{
 			JwtID:          ja.genRefreshTokenID(jwtID),
 			Issuer:         ja.config.Issuer,
@@ -142,20 +281,16 @@ func (ja *JWTAuth) EncodeToken(uid string) (*AccessToken, error) {
 			Audience:       "refresh",
 			IssuedAt:       now.Unix(),
 			NotBeforeTime:  now.Unix(),
-			ExpirationTime: now.Add(ja.config.RefreshTTL).Unix(),
-			RandomHash:     randomHash,
+			ExpirationTime: refreshExp.Unix(),
+			SessionID:      sessionID,
 		}
 
-		refreshPayloadBytes, err := json.Marshal(refreshPayload)
+		refreshToken, err = ja.sign(refreshPayload)
 		if err != nil {
 			return nil, err
 		}
 
-		refreshToken, err = jose.SignBytes(refreshPayloadBytes, ja.config.Algorithm, []byte(ja.config.SessionKey),
-			jose.Header("typ", "JWT"),
-			jose.Header("alg", ja.config.Algorithm),
-		)
-
+		err = ja.storeRefreshToken(context.Background(), refreshPayload.JwtID, sessionID, refreshExp)
 		if err != nil {
 			return nil, err
 		}
@@ -169,10 +304,8 @@ func (ja *JWTAuth) EncodeToken(uid string) (*AccessToken, error) {
 	}, nil
 }
 
-func (ja *JWTAuth) ComparePassword(hashedPassword, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-}
-
+// RefreshToken issue new tokens of the same session.
+// Refresh tokens are single use, replaying a consumed token revokes the session
 func (ja *JWTAuth) RefreshToken(refreshToken string, accessToken string) (*AccessToken, error) {
 	decodedRefreshToken, err := ja.DecodeToken(refreshToken)
 	if err != nil {
@@ -190,46 +323,26 @@ func (ja *JWTAuth) RefreshToken(refreshToken string, accessToken string) (*Acces
 
 	if decodedRefreshToken.JwtID != ja.genRefreshTokenID(decodedToken.JwtID) ||
 		decodedRefreshToken.Subject != decodedToken.Subject ||
+		decodedRefreshToken.SessionID != decodedToken.SessionID ||
 		decodedRefreshToken.IssuedAt != decodedToken.IssuedAt {
 		return nil, errors.New("token_mismatch")
 	}
 
-	// revoke old token
-	randomUUID := uuid.New().String()
-	randomHashed, err := ja.EncryptPassword(randomUUID)
-
-	if err != nil {
-		return nil, util.ErrBadRequest(err)
-	}
-
-	var query struct {
-		UpdatePassword struct {
-			ID       string `graphql:"id"`
-			Password string `graphql:"password"`
-		} `graphql:"update_account_by_pk(pk_columns: $pk_columns, _set: $set)"`
-	}
-
-	variables := map[string]interface{}{
-		"pk_columns": account_pk_columns_input{
-			"id": decodedRefreshToken.Subject,
-		},
-		"set": account_set_input{
-			"randomHash": string(randomHashed),
-		},
+	if err := ja.consumeRefreshToken(context.Background(), decodedRefreshToken); err != nil {
+		return nil, err
 	}
-
-	err = ja.controller.Mutate(context.Background(), &query, variables)
-
-	if err != nil {
-		return nil, util.ErrBadRequest(err)
+	if ja.verifyCache != nil {
+		ja.verifyCache.Delete(decodedToken.JwtID)
 	}
 
-	return ja.EncodeToken(decodedRefreshToken.Subject)
+	return ja.encodeSessionToken(decodedRefreshToken.Subject, decodedRefreshToken.SessionID)
 }
 
-func (ja *JWTAuth) DecodeToken(token string) (*jwtPayload, error) {
+// ParseToken verify the token signature and issuer only.
+// The token may be expired or its session may be revoked
+func (ja *JWTAuth) ParseToken(token string) (*jwtPayload, error) {
 
-	bytes, _, err := jose.DecodeBytes(token, []byte(ja.config.SessionKey))
+	bytes, _, err := jose.DecodeBytes(token, ja.verificationKey)
 	if err != nil {
 		return nil, err
 	}
@@ -245,39 +358,67 @@ func (ja *JWTAuth) DecodeToken(token string) (*jwtPayload, error) {
 		return &result, errors.New("jwt_invalid_issuer")
 	}
 
-	if result.ExpirationTime <= time.Now().Unix() || !ja.checkRandomHash(result.Subject, result.RandomHash) {
-		return &result, errors.New("token_expired")
-	}
-
 	return &result, nil
 }
 
-func (ja *JWTAuth) checkRandomHash(accountId string, randomHash string) bool {
-	var query struct {
-		Accounts []struct {
-			ID         string `graphql:"id"`
-			RandomHash string `graphql:"randomHash"`
-		} `graphql:"account(where: $where, limit: 1)"`
+// DecodeToken verify the token signature, expiry and its session
+func (ja *JWTAuth) DecodeToken(token string) (*jwtPayload, error) {
+
+	result, err := ja.ParseToken(token)
+	if err != nil {
+		return result, err
 	}
 
-	variables := map[string]interface{}{
-		"where": account_bool_exp{
-			"id": map[string]interface{}{
-				"_eq": accountId,
-			},
-			"randomHash": map[string]interface{}{
-				"_eq": randomHash,
-			},
-		},
+	return result, ja.ValidateToken(result)
+}
+
+// ValidateToken verify the expiry and session of the parsed token
+func (ja *JWTAuth) ValidateToken(result *jwtPayload) error {
+	if result.ExpirationTime <= time.Now().Unix() {
+		return errors.New("token_expired")
 	}
 
-	err := ja.controller.Query(context.Background(), &query, variables)
+	return ja.checkSession(context.Background(), result.Subject, result.SessionID, result.ImpersonatorID())
+}
 
+// sign encode the payload and sign it with the current signing key
+func (ja *JWTAuth) sign(payload interface{}) (string, error) {
+	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
-		return false
+		return "", err
+	}
+
+	key := ja.keyRing().current
+	return jose.SignBytes(payloadBytes, key.Algorithm, key.private,
+		jose.Header("typ", "JWT"),
+		jose.Header("kid", key.ID),
+	)
+}
+
+// verificationKey select the public key by the kid header.
+// Tokens issued before kid was introduced fall back to the configured key until it expires after a rotation.
+// The alg header must match the key to prevent algorithm confusion attacks
+func (ja *JWTAuth) verificationKey(headers map[string]interface{}, payload string) interface{} {
+	ring := ja.keyRing()
+	kid, _ := headers["kid"].(string)
+	if kid == "" {
+		kid = ja.bootstrapKey.ID
+	}
+
+	key := ring.find(kid)
+	// the key may be rotated by another instance
+	if key == nil && time.Since(ring.loadedAt) > keyRingForceReloadInterval {
+		key = ja.reloadKeyRing().find(kid)
+	}
+	if key == nil {
+		return errors.New("jwt_unknown_key")
+	}
+
+	if alg, _ := headers["alg"].(string); alg != key.Algorithm {
+		return errors.New("jwt_invalid_algorithm")
 	}
 
-	return len(query.Accounts) > 0
+	return key.public
 }
 
 func (ja *JWTAuth) genRefreshTokenID(id string) string {
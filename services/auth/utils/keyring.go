@@ -0,0 +1,386 @@
+package utils
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	jose "github.com/dvsekhvalnov/jose2go"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+type jwt_keys_bool_exp map[string]interface{}
+type jwt_keys_insert_input map[string]interface{}
+type jwt_keys_set_input map[string]interface{}
+
+// minimum interval between reloads triggered by unknown key ids
+const keyRingForceReloadInterval = 5 * time.Second
+
+// keyRing holds the current signing key
+// and the previous keys which still verify tokens during the overlap window
+type keyRing struct {
+	current   *signingKey
+	verifiers map[string]*signingKey
+	loadedAt  time.Time
+}
+
+func newKeyRing(current *signingKey, previous ...*signingKey) *keyRing {
+	ring := &keyRing{
+		current:   current,
+		verifiers: map[string]*signingKey{},
+		loadedAt:  time.Now(),
+	}
+	ring.add(current)
+	for _, key := range previous {
+		ring.add(key)
+	}
+
+	return ring
+}
+
+func (kr *keyRing) add(key *signingKey) {
+	if !key.ExpiresAt.IsZero() && key.ExpiresAt.Before(time.Now()) {
+		return
+	}
+	kr.verifiers[key.ID] = key
+}
+
+// find return the verification key by id if it isn't expired yet
+func (kr *keyRing) find(kid string) *signingKey {
+	key, ok := kr.verifiers[kid]
+	if !ok || (!key.ExpiresAt.IsZero() && key.ExpiresAt.Before(time.Now())) {
+		return nil
+	}
+
+	return key
+}
+
+// KeyRotationResult represents the signing key state after rotation
+type KeyRotationResult struct {
+	KeyID     string
+	Algorithm string
+	// ActivatesAt is when the new key starts signing tokens
+	ActivatesAt       time.Time
+	PreviousKeyID     string
+	PreviousExpiresAt time.Time
+}
+
+// keyOverlap return how long retired keys keep verifying tokens.
+// Default is the longest token lifetime so no issued token is invalidated by the rotation
+func (ja *JWTAuth) keyOverlap() time.Duration {
+	if ja.config.KeyOverlap > 0 {
+		return ja.config.KeyOverlap
+	}
+	if ja.config.RefreshTTL > ja.config.TTL {
+		return ja.config.RefreshTTL
+	}
+
+	return ja.config.TTL
+}
+
+// keyActivationDelay return how long a new key is published in JWKS before it signs tokens,
+// so that verifiers which cache JWKS and other instances know the key when they receive its tokens
+func (ja *JWTAuth) keyActivationDelay() time.Duration {
+	return ja.config.JWKSMaxAge + ja.config.KeyReloadInterval
+}
+
+// keyRing return the cached key ring, reloading rotated keys from the database when it is stale
+func (ja *JWTAuth) keyRing() *keyRing {
+	ja.keyLock.RLock()
+	ring := ja.ring
+	ja.keyLock.RUnlock()
+
+	if ring != nil && time.Since(ring.loadedAt) < ja.config.KeyReloadInterval {
+		return ring
+	}
+
+	return ja.reloadKeyRing()
+}
+
+func (ja *JWTAuth) reloadKeyRing() *keyRing {
+	ring, err := ja.loadKeyRing()
+
+	ja.keyLock.Lock()
+	defer ja.keyLock.Unlock()
+	if err != nil {
+		logrus.WithError(err).Error("failed to load JWT signing keys")
+		// keep the last keys and back off until the next reload interval
+		// so that requests with unknown key ids don't hit the database on every call
+		fallback := newKeyRing(ja.bootstrapKey, ja.previousKeys...)
+		if ja.ring != nil {
+			retry := *ja.ring
+			retry.loadedAt = time.Now()
+			fallback = &retry
+		}
+		ja.ring = fallback
+		return fallback
+	}
+	ja.ring = ring
+
+	return ring
+}
+
+// loadKeyRing combine configured keys with the keys rotated at runtime.
+// The configured key is the signing key until the first rotation happens
+func (ja *JWTAuth) loadKeyRing() (*keyRing, error) {
+	if ja.controller == nil {
+		return newKeyRing(ja.bootstrapKey, ja.previousKeys...), nil
+	}
+
+	var query struct {
+		Keys []struct {
+			ID        string     `graphql:"id"`
+			Algorithm string     `graphql:"algorithm"`
+			Secret    string     `graphql:"secret"`
+			CreatedAt time.Time  `graphql:"created_at"`
+			RetiredAt *time.Time `graphql:"retired_at"`
+		} `graphql:"jwt_keys(where: $where, order_by: {created_at: desc})"`
+		KeysAggregate struct {
+			Aggregate struct {
+				Min struct {
+					CreatedAt *time.Time `graphql:"created_at"`
+				} `graphql:"min"`
+			} `graphql:"aggregate"`
+		} `graphql:"jwt_keys_aggregate"`
+	}
+
+	overlap := ja.keyOverlap()
+	variables := map[string]interface{}{
+		"where": jwt_keys_bool_exp{
+			"_or": []map[string]interface{}{
+				{
+					"retired_at": map[string]interface{}{
+						"_is_null": true,
+					},
+				},
+				{
+					"retired_at": map[string]interface{}{
+						"_gt": time.Now().Add(-overlap),
+					},
+				},
+			},
+		},
+	}
+
+	err := ja.controller.Query(context.Background(), &query, variables)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var current *signingKey
+	var previous []*signingKey
+	for _, item := range query.Keys {
+		content := []byte(item.Secret)
+		if isHMACAlgorithm(item.Algorithm) {
+			content, err = base64.StdEncoding.DecodeString(item.Secret)
+			if err != nil {
+				return nil, fmt.Errorf("invalid secret of key %s: %s", item.ID, err)
+			}
+		}
+
+		key, err := newSigningKey(item.Algorithm, item.ID, content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %s", item.ID, err)
+		}
+
+		if item.RetiredAt != nil {
+			key.ExpiresAt = item.RetiredAt.Add(overlap)
+		}
+		// keys which aren't active yet only verify tokens, so they are published in JWKS ahead of time
+		if current == nil && !item.CreatedAt.After(now) && (item.RetiredAt == nil || item.RetiredAt.After(now)) {
+			current = key
+			continue
+		}
+		previous = append(previous, key)
+	}
+
+	previous = append(previous, ja.previousKeys...)
+	if firstRotation := query.KeysAggregate.Aggregate.Min.CreatedAt; firstRotation != nil {
+		// the configured key is retired since the first rotation
+		bootstrapKey := *ja.bootstrapKey
+		bootstrapKey.ExpiresAt = firstRotation.Add(overlap)
+		previous = append(previous, &bootstrapKey)
+	}
+
+	if current == nil {
+		current = ja.bootstrapKey
+	}
+
+	return newKeyRing(current, previous...), nil
+}
+
+// RotateKey generate a new signing key with the current algorithm.
+// The new key is published in JWKS immediately but only signs tokens after the activation delay.
+// The previous key is retired then and keeps verifying tokens during the overlap window
+func (ja *JWTAuth) RotateKey(ctx context.Context) (*KeyRotationResult, error) {
+	if ja.controller == nil {
+		return nil, errors.New("key rotation requires the controller client")
+	}
+
+	previous := ja.keyRing().current
+	algorithm := previous.Algorithm
+	secret, content, err := generateKeySecret(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := newSigningKey(algorithm, uuid.New().String(), content)
+	if err != nil {
+		return nil, err
+	}
+
+	activatesAt := time.Now().Add(ja.keyActivationDelay())
+	var mutation struct {
+		RetireKeys struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"update_jwt_keys(where: $where, _set: $set)"`
+		InsertKey struct {
+			ID string `graphql:"id"`
+		} `graphql:"insert_jwt_keys_one(object: $object)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": jwt_keys_bool_exp{
+			"retired_at": map[string]interface{}{
+				"_is_null": true,
+			},
+		},
+		"set": jwt_keys_set_input{
+			"retired_at": activatesAt,
+		},
+		"object": jwt_keys_insert_input{
+			"id":         key.ID,
+			"algorithm":  key.Algorithm,
+			"secret":     secret,
+			"created_at": activatesAt,
+		},
+	}
+
+	err = ja.controller.Mutate(ctx, &mutation, variables)
+	if err != nil {
+		return nil, err
+	}
+
+	ja.reloadKeyRing()
+
+	return &KeyRotationResult{
+		KeyID:             key.ID,
+		Algorithm:         key.Algorithm,
+		ActivatesAt:       activatesAt,
+		PreviousKeyID:     previous.ID,
+		PreviousExpiresAt: activatesAt.Add(ja.keyOverlap()),
+	}, nil
+}
+
+// generateKeySecret create new key material for the algorithm.
+// It returns the value to be stored and the content to construct the signing key
+func generateKeySecret(algorithm string) (string, []byte, error) {
+	if isHMACAlgorithm(algorithm) {
+		secret := make([]byte, 64)
+		if _, err := rand.Read(secret); err != nil {
+			return "", nil, err
+		}
+		return base64.StdEncoding.EncodeToString(secret), secret, nil
+	}
+
+	var privateKey interface{}
+	var err error
+	switch {
+	case strings.HasPrefix(algorithm, "RS"), strings.HasPrefix(algorithm, "PS"):
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case algorithm == jose.ES256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case algorithm == jose.ES384:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case algorithm == jose.ES512:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case algorithm == EdDSA:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return "", nil, fmt.Errorf("unsupported algorithm %s", algorithm)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return "", nil, err
+	}
+	content := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	return string(content), content, nil
+}
+
+// loadPreviousKeys read the configured keys which only verify tokens
+func (jac JWTAuthConfig) loadPreviousKeys() ([]*signingKey, error) {
+	var results []*signingKey
+	for _, secret := range jac.PreviousSessionKeys {
+		algorithm := jac.Algorithm
+		if !isHMACAlgorithm(algorithm) {
+			algorithm = jose.HS256
+		}
+		key, err := newSigningKey(algorithm, "", []byte(secret))
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, key)
+	}
+
+	for _, path := range jac.PreviousKeyFiles {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read previous key %s: %s", path, err)
+		}
+
+		key, err := newSigningKey(jac.Algorithm, "", content)
+		if err != nil {
+			// the previous key may use a different algorithm
+			privateKey, parseErr := parsePrivateKey(content)
+			if parseErr != nil {
+				return nil, parseErr
+			}
+			key, err = newSigningKey(defaultAlgorithm(privateKey), "", content)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid previous key %s: %s", path, err)
+		}
+		results = append(results, key)
+	}
+
+	return results, nil
+}
+
+// defaultAlgorithm return the most common signing algorithm of the private key type
+func defaultAlgorithm(privateKey interface{}) string {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return jose.RS256
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P384():
+			return jose.ES384
+		case elliptic.P521():
+			return jose.ES512
+		default:
+			return jose.ES256
+		}
+	case ed25519.PrivateKey:
+		return EdDSA
+	}
+
+	return ""
+}
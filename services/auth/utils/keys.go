@@ -0,0 +1,237 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// JSONWebKey represents a public key in JWK format (RFC 7517)
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet represents a JWK set document
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// signingKey holds the key material of a JWT signing algorithm
+type signingKey struct {
+	ID        string
+	Algorithm string
+	// private key is []byte for HMAC, *rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey
+	private interface{}
+	// public key is []byte for HMAC, *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey
+	public interface{}
+	// ExpiresAt is the end of the verification period of a retired key. Zero value never expires
+	ExpiresAt time.Time
+}
+
+// IsSymmetric check if the key is a shared secret which must never be published
+func (sk *signingKey) IsSymmetric() bool {
+	return isHMACAlgorithm(sk.Algorithm)
+}
+
+// JWK return the public JSON web key. Symmetric keys are never exported
+func (sk *signingKey) JWK() (*JSONWebKey, error) {
+	if sk.IsSymmetric() {
+		return nil, errors.New("symmetric keys can't be published")
+	}
+
+	jwk, err := publicJWK(sk.public)
+	if err != nil {
+		return nil, err
+	}
+	jwk.KeyID = sk.ID
+	jwk.Use = "sig"
+	jwk.Algorithm = sk.Algorithm
+
+	return jwk, nil
+}
+
+// newSigningKey construct the signing key from the algorithm and key content.
+// HMAC algorithms use the raw secret, the others require a PEM encoded private key
+func newSigningKey(algorithm string, keyID string, content []byte) (*signingKey, error) {
+	sk := &signingKey{
+		ID:        keyID,
+		Algorithm: algorithm,
+	}
+
+	if isHMACAlgorithm(algorithm) {
+		if len(content) == 0 {
+			return nil, errors.New("empty HMAC secret")
+		}
+		sk.private = content
+		sk.public = content
+	} else {
+		privateKey, err := parsePrivateKey(content)
+		if err != nil {
+			return nil, err
+		}
+
+		publicKey, err := publicKeyOf(algorithm, privateKey)
+		if err != nil {
+			return nil, err
+		}
+		sk.private = privateKey
+		sk.public = publicKey
+	}
+
+	if sk.ID == "" {
+		thumbprint, err := keyThumbprint(sk.public)
+		if err != nil {
+			return nil, err
+		}
+		sk.ID = thumbprint
+	}
+
+	return sk, nil
+}
+
+func isHMACAlgorithm(algorithm string) bool {
+	return strings.HasPrefix(algorithm, "HS")
+}
+
+// parsePrivateKey decode PEM content in PKCS#8, PKCS#1 or SEC 1 format.
+// Escaped new lines are accepted so the key can be inlined in environment variables
+func parsePrivateKey(content []byte) (crypto.PrivateKey, error) {
+	content = []byte(strings.ReplaceAll(string(content), `\n`, "\n"))
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("invalid PEM private key")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unsupported private key type %s", block.Type)
+}
+
+// publicKeyOf validate the private key against the algorithm and return its public key
+func publicKeyOf(algorithm string, privateKey crypto.PrivateKey) (crypto.PublicKey, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		if !strings.HasPrefix(algorithm, "RS") && !strings.HasPrefix(algorithm, "PS") {
+			break
+		}
+		return &key.PublicKey, nil
+	case *ecdsa.PrivateKey:
+		if !strings.HasPrefix(algorithm, "ES") {
+			break
+		}
+		expectedCurve := map[string]elliptic.Curve{
+			"ES256": elliptic.P256(),
+			"ES384": elliptic.P384(),
+			"ES512": elliptic.P521(),
+		}[algorithm]
+		if expectedCurve != key.Curve {
+			return nil, fmt.Errorf("algorithm %s doesn't match curve %s", algorithm, key.Curve.Params().Name)
+		}
+		return &key.PublicKey, nil
+	case ed25519.PrivateKey:
+		if algorithm != EdDSA {
+			break
+		}
+		return key.Public(), nil
+	}
+
+	return nil, fmt.Errorf("private key type %T doesn't match algorithm %s", privateKey, algorithm)
+}
+
+// publicJWK encode the public key to JWK format without metadata fields
+func publicJWK(publicKey interface{}) (*JSONWebKey, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return &JSONWebKey{
+			KeyType: "RSA",
+			N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		return &JSONWebKey{
+			KeyType: "EC",
+			Curve:   key.Curve.Params().Name,
+			X:       base64.RawURLEncoding.EncodeToString(padBytes(key.X.Bytes(), size)),
+			Y:       base64.RawURLEncoding.EncodeToString(padBytes(key.Y.Bytes(), size)),
+		}, nil
+	case ed25519.PublicKey:
+		return &JSONWebKey{
+			KeyType: "OKP",
+			Curve:   "Ed25519",
+			X:       base64.RawURLEncoding.EncodeToString(key),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported public key type %T", publicKey)
+}
+
+// keyThumbprint compute the JWK thumbprint (RFC 7638) to be used as default key id
+func keyThumbprint(publicKey interface{}) (string, error) {
+	var members map[string]string
+	if secret, ok := publicKey.([]byte); ok {
+		members = map[string]string{
+			"k":   base64.RawURLEncoding.EncodeToString(secret),
+			"kty": "oct",
+		}
+	} else {
+		jwk, err := publicJWK(publicKey)
+		if err != nil {
+			return "", err
+		}
+		switch jwk.KeyType {
+		case "RSA":
+			members = map[string]string{"e": jwk.E, "kty": jwk.KeyType, "n": jwk.N}
+		case "EC":
+			members = map[string]string{"crv": jwk.Curve, "kty": jwk.KeyType, "x": jwk.X, "y": jwk.Y}
+		default:
+			members = map[string]string{"crv": jwk.Curve, "kty": jwk.KeyType, "x": jwk.X}
+		}
+	}
+
+	// json.Marshal sorts map keys which is the required lexicographic order
+	bytes, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(bytes)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func padBytes(input []byte, size int) []byte {
+	if len(input) >= size {
+		return input
+	}
+	result := make([]byte, size)
+	copy(result[size-len(input):], input)
+	return result
+}
@@ -0,0 +1,180 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	jose "github.com/dvsekhvalnov/jose2go"
+	"github.com/hasura/go-graphql-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func encodePKCS8(t *testing.T, key interface{}) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestSigningKeys(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	for _, fixture := range []struct {
+		Algorithm string
+		Content   []byte
+		KeyType   string
+	}{
+		{jose.RS256, encodePKCS8(t, rsaKey), "RSA"},
+		{jose.ES256, encodePKCS8(t, ecKey), "EC"},
+		{EdDSA, encodePKCS8(t, edKey), "OKP"},
+	} {
+		key, err := newSigningKey(fixture.Algorithm, "", fixture.Content)
+		if err != nil {
+			t.Fatalf("%s: %s", fixture.Algorithm, err)
+		}
+		assert.NotEmpty(t, key.ID, fixture.Algorithm)
+
+		jwk, err := key.JWK()
+		assert.Nil(t, err, fixture.Algorithm)
+		assert.Equal(t, fixture.KeyType, jwk.KeyType, fixture.Algorithm)
+		assert.Equal(t, fixture.Algorithm, jwk.Algorithm, fixture.Algorithm)
+		assert.Equal(t, key.ID, jwk.KeyID, fixture.Algorithm)
+
+		ja := &JWTAuth{bootstrapKey: key}
+		token, err := ja.sign(map[string]string{"sub": "foo"})
+		assert.Nil(t, err, fixture.Algorithm)
+
+		payload, headers, err := jose.DecodeBytes(token, ja.verificationKey)
+		assert.Nil(t, err, fixture.Algorithm)
+		assert.Equal(t, `{"sub":"foo"}`, string(payload), fixture.Algorithm)
+		assert.Equal(t, key.ID, headers["kid"], fixture.Algorithm)
+	}
+}
+
+func TestSigningKeyMismatch(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	_, err := newSigningKey(jose.ES256, "", encodePKCS8(t, ecKey))
+	assert.EqualError(t, err, "algorithm ES256 doesn't match curve P-384")
+
+	_, err = newSigningKey(jose.RS256, "", encodePKCS8(t, ecKey))
+	assert.EqualError(t, err, "private key type *ecdsa.PrivateKey doesn't match algorithm RS256")
+}
+
+func TestVerificationKeyRejectsAlgorithmConfusion(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	key, err := newSigningKey(jose.RS256, "", encodePKCS8(t, rsaKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ja := &JWTAuth{bootstrapKey: key}
+
+	publicBytes, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	forged, _ := jose.Sign(`{"sub":"admin"}`, jose.HS256, publicBytes, jose.Header("kid", key.ID))
+
+	_, _, err = jose.DecodeBytes(forged, ja.verificationKey)
+	assert.EqualError(t, err, "jwt_invalid_algorithm")
+
+	unsigned, _ := jose.Sign(`{"sub":"admin"}`, jose.NONE, nil)
+	_, _, err = jose.DecodeBytes(unsigned, ja.verificationKey)
+	assert.EqualError(t, err, "jwt_invalid_algorithm")
+}
+
+func TestSymmetricKeyIsNotPublished(t *testing.T) {
+	key, err := newSigningKey(jose.HS256, "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ja := &JWTAuth{bootstrapKey: key}
+	jwks, err := ja.JWKS()
+	assert.Nil(t, err)
+	assert.Empty(t, jwks.Keys)
+}
+
+func TestKeyRingOverlap(t *testing.T) {
+	current, _ := newSigningKey(jose.HS256, "current", []byte("current"))
+	retired, _ := newSigningKey(jose.HS256, "retired", []byte("retired"))
+	retired.ExpiresAt = time.Now().Add(time.Minute)
+	expired, _ := newSigningKey(jose.HS256, "expired", []byte("expired"))
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+
+	ja := &JWTAuth{
+		bootstrapKey: current,
+		ring:         newKeyRing(current, retired, expired),
+		config: JWTAuthConfig{
+			KeyReloadInterval: time.Hour,
+		},
+	}
+
+	for _, fixture := range []struct {
+		Key   *signingKey
+		Error string
+	}{
+		{current, ""},
+		{retired, ""},
+		{expired, "jwt_unknown_key"},
+	} {
+		token, _ := jose.Sign(`{"sub":"foo"}`, fixture.Key.Algorithm, fixture.Key.private, jose.Header("kid", fixture.Key.ID))
+		_, _, err := jose.DecodeBytes(token, ja.verificationKey)
+		if fixture.Error == "" {
+			assert.Nil(t, err, fixture.Key.ID)
+		} else {
+			assert.EqualError(t, err, fixture.Error, fixture.Key.ID)
+		}
+	}
+}
+
+func TestKeyRingRejectsTokensWithoutKeyIDAfterRotation(t *testing.T) {
+	bootstrap, _ := newSigningKey(jose.HS256, "bootstrap", []byte("bootstrap"))
+	rotated, _ := newSigningKey(jose.HS256, "rotated", []byte("rotated"))
+	ja := &JWTAuth{
+		bootstrapKey: bootstrap,
+		ring:         newKeyRing(bootstrap),
+		config: JWTAuthConfig{
+			KeyReloadInterval: time.Hour,
+		},
+	}
+
+	token, _ := jose.Sign(`{"sub":"foo"}`, bootstrap.Algorithm, bootstrap.private)
+	_, _, err := jose.DecodeBytes(token, ja.verificationKey)
+	assert.Nil(t, err)
+
+	// the configured key expires after the overlap of the first rotation
+	retired := *bootstrap
+	retired.ExpiresAt = time.Now().Add(-time.Minute)
+	ja.ring = newKeyRing(rotated, &retired)
+	_, _, err = jose.DecodeBytes(token, ja.verificationKey)
+	assert.EqualError(t, err, "jwt_unknown_key")
+}
+
+func TestKeyRingReloadFailureBacksOff(t *testing.T) {
+	current, _ := newSigningKey(jose.HS256, "current", []byte("current"))
+	stale := newKeyRing(current)
+	stale.loadedAt = time.Now().Add(-time.Hour)
+	ja := &JWTAuth{
+		bootstrapKey: current,
+		// the controller fails every request
+		controller: graphql.NewClient("http://127.0.0.1:1/v1/graphql", nil),
+		ring:       stale,
+		config: JWTAuthConfig{
+			KeyReloadInterval: time.Minute,
+		},
+	}
+
+	ring := ja.keyRing()
+	assert.Equal(t, current, ring.current)
+	assert.WithinDuration(t, time.Now(), ring.loadedAt, time.Second)
+	// the failed reload isn't retried by the next lookup
+	assert.Equal(t, ring, ja.keyRing())
+}
@@ -0,0 +1,535 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+)
+
+type login_attempts_bool_exp map[string]interface{}
+type login_attempts_insert_input map[string]interface{}
+type account_inc_input map[string]interface{}
+
+// AudienceUnlockAccount is the audience of tokens in account unlock emails
+const AudienceUnlockAccount = "unlock_account"
+
+var (
+	errAccountLocked    = errors.New("account_locked")
+	errTooManyAttempts  = errors.New("too_many_attempts")
+	errUnlockTokenStale = errors.New("invalid_unlock_token")
+)
+
+// LoginThrottleConfig holds thresholds of brute-force protection
+type LoginThrottleConfig struct {
+	// MaxFailedAttempts is the number of consecutive failures which lock the account
+	MaxFailedAttempts int `envconfig:"LOGIN_MAX_FAILED_ATTEMPTS" default:"5"`
+	// LockoutDuration is how long the account is locked. The user can unlock it earlier by the email link
+	LockoutDuration time.Duration `envconfig:"LOGIN_LOCKOUT_DURATION" default:"15m"`
+	// BackoffBase is the delay after the first failure which is doubled on every consecutive failure
+	BackoffBase time.Duration `envconfig:"LOGIN_BACKOFF_BASE" default:"1s"`
+	BackoffMax  time.Duration `envconfig:"LOGIN_BACKOFF_MAX" default:"1m"`
+	// IPMaxFailedAttempts is the number of failures of an IP address during IPWindow, zero disables it
+	IPMaxFailedAttempts int           `envconfig:"LOGIN_IP_MAX_FAILED_ATTEMPTS" default:"20"`
+	IPWindow            time.Duration `envconfig:"LOGIN_IP_WINDOW" default:"15m"`
+	// UnlockTokenTTL is how long unlock links are valid
+	UnlockTokenTTL time.Duration `envconfig:"LOGIN_UNLOCK_TOKEN_TTL" default:"24h"`
+	// AttemptRetention is how long login attempts are kept, it is extended to IPWindow at least.
+	// Attempts and claims of expired single-use tokens are pruned on every PruneInterval, zero disables it
+	AttemptRetention time.Duration `envconfig:"LOGIN_ATTEMPT_RETENTION" default:"720h"`
+	PruneInterval    time.Duration `envconfig:"LOGIN_ATTEMPT_PRUNE_INTERVAL" default:"1h"`
+}
+
+// LoginState is the brute-force protection state of the account
+type LoginState struct {
+	FailedLoginCount  int        `graphql:"failed_login_count"`
+	LastFailedLoginAt *time.Time `graphql:"last_failed_login_at"`
+	LockedUntil       *time.Time `graphql:"locked_until"`
+}
+
+// LoginFailure describes the result of a failed login
+type LoginFailure struct {
+	Locked      bool
+	LockedUntil time.Time
+}
+
+// backoff return the delay before the next attempt after consecutive failures
+func (ltc LoginThrottleConfig) backoff(failures int) time.Duration {
+	if failures <= 0 || ltc.BackoffBase <= 0 {
+		return 0
+	}
+
+	delay := ltc.BackoffBase
+	for i := 1; i < failures && delay < ltc.BackoffMax; i++ {
+		delay *= 2
+	}
+	if ltc.BackoffMax > 0 && delay > ltc.BackoffMax {
+		return ltc.BackoffMax
+	}
+
+	return delay
+}
+
+// CheckLoginIP reject the login if the IP address failed too many times recently
+func (ja *JWTAuth) CheckLoginIP(ctx context.Context, ip string) error {
+	config := ja.config.Login
+	if ip == "" || config.IPMaxFailedAttempts <= 0 {
+		return nil
+	}
+
+	var query struct {
+		LoginAttemptsAggregate struct {
+			Aggregate struct {
+				Count int `graphql:"count"`
+			} `graphql:"aggregate"`
+		} `graphql:"login_attempts_aggregate(where: $where)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": login_attempts_bool_exp{
+			"ip": map[string]interface{}{
+				"_eq": ip,
+			},
+			"success": map[string]interface{}{
+				"_eq": false,
+			},
+			"created_at": map[string]interface{}{
+				"_gt": time.Now().Add(-config.IPWindow),
+			},
+		},
+	}
+
+	err := ja.controller.Query(ctx, &query, variables, graphql.OperationName("CountLoginFailuresByIP"))
+	if err != nil {
+		return err
+	}
+
+	if query.LoginAttemptsAggregate.Aggregate.Count >= config.IPMaxFailedAttempts {
+		return errTooManyAttempts
+	}
+
+	return nil
+}
+
+// CheckLoginState reject the login if the account is locked or the backoff delay isn't over yet
+func (ja *JWTAuth) CheckLoginState(state LoginState) error {
+	now := time.Now()
+	if state.LockedUntil != nil && state.LockedUntil.After(now) {
+		return errAccountLocked
+	}
+
+	if state.LastFailedLoginAt != nil {
+		retryAt := state.LastFailedLoginAt.Add(ja.config.Login.backoff(state.FailedLoginCount))
+		if retryAt.After(now) {
+			return fmt.Errorf("%s: retry after %s", errTooManyAttempts, retryAt.Sub(now).Round(time.Second))
+		}
+	}
+
+	return nil
+}
+
+// RecordLoginFailure count the failure of the account and IP address.
+// The account is locked when the failures reach the threshold. accountID is empty if the email doesn't exist
+func (ja *JWTAuth) RecordLoginFailure(ctx context.Context, accountID string, email string, ip string) (*LoginFailure, error) {
+	err := ja.insertLoginAttempt(ctx, accountID, email, ip, false)
+	if err != nil || accountID == "" {
+		return &LoginFailure{}, err
+	}
+
+	if err := ja.releaseExpiredLock(ctx, accountID); err != nil {
+		return nil, err
+	}
+
+	var mutation struct {
+		UpdateAccount struct {
+			FailedLoginCount int `graphql:"failed_login_count"`
+		} `graphql:"update_account_by_pk(pk_columns: $pk_columns, _inc: $inc, _set: $set)"`
+	}
+
+	// the increment is atomic so concurrent attempts can't skip the threshold
+	variables := map[string]interface{}{
+		"pk_columns": account_pk_columns_input{
+			"id": accountID,
+		},
+		"inc": account_inc_input{
+			"failed_login_count": 1,
+		},
+		"set": account_set_input{
+			"last_failed_login_at": time.Now(),
+		},
+	}
+
+	err = ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("IncrementLoginFailures"))
+	if err != nil {
+		return nil, err
+	}
+
+	return ja.lockAfterFailures(ctx, accountID, mutation.UpdateAccount.FailedLoginCount)
+}
+
+// VerifyAccountCredential check a credential of the account, e.g. the second factor or the current password,
+// under the brute-force protection of logins. The attempt is counted as a failure before verify runs,
+// in an atomic increment which is conditional on the account not being locked,
+// so neither new challenges nor concurrent requests can exceed the threshold.
+// The failure counter is reset if the credential is valid
+func (ja *JWTAuth) VerifyAccountCredential(ctx context.Context, accountID string, ip string, verify func() error) (*LoginFailure, error) {
+	return ja.verifyCredential(ctx, accountID, ip, verify, func(LoginState) error {
+		return ja.updateLoginState(ctx, accountID, resetLoginState())
+	})
+}
+
+// VerifyFirstFactor check the password of a login like VerifyAccountCredential,
+// but a valid password only releases the reserved attempt. Earlier failures are kept until the login is complete,
+// so that knowing the password doesn't reset the failures of the second factor
+func (ja *JWTAuth) VerifyFirstFactor(ctx context.Context, accountID string, ip string, verify func() error) (*LoginFailure, error) {
+	return ja.verifyCredential(ctx, accountID, ip, verify, func(state LoginState) error {
+		return ja.releaseLoginAttempt(ctx, accountID, state)
+	})
+}
+
+// verifyCredential reserve an attempt and verify the credential. onSuccess receives the login state before the attempt
+func (ja *JWTAuth) verifyCredential(ctx context.Context, accountID string, ip string, verify func() error, onSuccess func(state LoginState) error) (*LoginFailure, error) {
+	state, err := ja.getLoginState(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if err := ja.CheckLoginState(*state); err != nil {
+		return nil, err
+	}
+	if state.LockedUntil != nil {
+		if err := ja.releaseExpiredLock(ctx, accountID); err != nil {
+			return nil, err
+		}
+		state = &LoginState{}
+	}
+
+	now := time.Now()
+	var mutation struct {
+		UpdateAccount struct {
+			Returning []struct {
+				FailedLoginCount int `graphql:"failed_login_count"`
+			} `graphql:"returning"`
+		} `graphql:"update_account(where: $where, _inc: $inc, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": account_bool_exp{
+			"id": map[string]interface{}{
+				"_eq": accountID,
+			},
+			"_or": []map[string]interface{}{
+				{
+					"locked_until": map[string]interface{}{
+						"_is_null": true,
+					},
+				},
+				{
+					"locked_until": map[string]interface{}{
+						"_lte": now,
+					},
+				},
+			},
+		},
+		"inc": account_inc_input{
+			"failed_login_count": 1,
+		},
+		"set": account_set_input{
+			"last_failed_login_at": now,
+		},
+	}
+
+	err = ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("ReserveLoginAttempt"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(mutation.UpdateAccount.Returning) == 0 {
+		return nil, errAccountLocked
+	}
+
+	count := mutation.UpdateAccount.Returning[0].FailedLoginCount
+	if maxAttempts := ja.config.Login.MaxFailedAttempts; maxAttempts > 0 && count > maxAttempts {
+		// concurrent attempts passed the lock check before the account was locked
+		if _, err := ja.lockAfterFailures(ctx, accountID, count); err != nil {
+			return nil, err
+		}
+		return nil, errAccountLocked
+	}
+
+	if verifyErr := verify(); verifyErr != nil {
+		if err := ja.insertLoginAttempt(ctx, accountID, "", ip, false); err != nil {
+			return nil, err
+		}
+		failure, err := ja.lockAfterFailures(ctx, accountID, count)
+		if err != nil {
+			return nil, err
+		}
+		return failure, verifyErr
+	}
+
+	return nil, onSuccess(*state)
+}
+
+// releaseLoginAttempt revert the reservation of a valid attempt to the previous login state
+func (ja *JWTAuth) releaseLoginAttempt(ctx context.Context, accountID string, state LoginState) error {
+	var mutation struct {
+		UpdateAccount struct {
+			ID string `graphql:"id"`
+		} `graphql:"update_account_by_pk(pk_columns: $pk_columns, _inc: $inc, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"pk_columns": account_pk_columns_input{
+			"id": accountID,
+		},
+		"inc": account_inc_input{
+			"failed_login_count": -1,
+		},
+		"set": account_set_input{
+			"last_failed_login_at": state.LastFailedLoginAt,
+		},
+	}
+
+	return ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("ReleaseLoginAttempt"))
+}
+
+// lockAfterFailures lock the account if the failures reached the threshold
+func (ja *JWTAuth) lockAfterFailures(ctx context.Context, accountID string, failures int) (*LoginFailure, error) {
+	config := ja.config.Login
+	if config.MaxFailedAttempts <= 0 || failures < config.MaxFailedAttempts {
+		return &LoginFailure{}, nil
+	}
+
+	lockedUntil := time.Now().Add(config.LockoutDuration)
+	err := ja.updateLoginState(ctx, accountID, account_set_input{
+		"locked_until": lockedUntil,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginFailure{
+		Locked:      true,
+		LockedUntil: lockedUntil,
+	}, nil
+}
+
+// releaseExpiredLock reset the failure counter of the account whose lockout is over,
+// so that the next failure starts a new count instead of locking the account again
+func (ja *JWTAuth) releaseExpiredLock(ctx context.Context, accountID string) error {
+	var mutation struct {
+		UpdateAccount struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"update_account(where: $where, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": account_bool_exp{
+			"id": map[string]interface{}{
+				"_eq": accountID,
+			},
+			"locked_until": map[string]interface{}{
+				"_lte": time.Now(),
+			},
+		},
+		"set": resetLoginState(),
+	}
+
+	return ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("ReleaseExpiredLock"))
+}
+
+// PruneLoginAttempts delete login attempts older than the retention. It returns the number of deleted attempts
+func (ja *JWTAuth) PruneLoginAttempts(ctx context.Context) (int, error) {
+	config := ja.config.Login
+	retention := config.AttemptRetention
+	// attempts of the IP window are still counted by CheckLoginIP
+	if retention < config.IPWindow {
+		retention = config.IPWindow
+	}
+
+	var mutation struct {
+		DeleteLoginAttempts struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"delete_login_attempts(where: $where)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": login_attempts_bool_exp{
+			"created_at": map[string]interface{}{
+				"_lt": time.Now().Add(-retention),
+			},
+		},
+	}
+
+	err := ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("PruneLoginAttempts"))
+	if err != nil {
+		return 0, err
+	}
+
+	return mutation.DeleteLoginAttempts.AffectedRows, nil
+}
+
+// RecordLoginSuccess store the successful attempt.
+// Failures are reset by ResetLoginFailures once the login is complete, so that MFA failures
+// are still counted when the password is verified again
+func (ja *JWTAuth) RecordLoginSuccess(ctx context.Context, accountID string, ip string) error {
+	return ja.insertLoginAttempt(ctx, accountID, "", ip, true)
+}
+
+// ResetLoginFailures reset the failure counter and the lockout of the account
+func (ja *JWTAuth) ResetLoginFailures(ctx context.Context, accountID string, state LoginState) error {
+	if state.FailedLoginCount == 0 && state.LockedUntil == nil {
+		return nil
+	}
+
+	return ja.updateLoginState(ctx, accountID, resetLoginState())
+}
+
+func (ja *JWTAuth) insertLoginAttempt(ctx context.Context, accountID string, email string, ip string, success bool) error {
+	var mutation struct {
+		InsertLoginAttempt struct {
+			ID string `graphql:"id"`
+		} `graphql:"insert_login_attempts_one(object: $object)"`
+	}
+
+	variables := map[string]interface{}{
+		"object": login_attempts_insert_input{
+			"account_id": nullableString(accountID),
+			"email":      nullableString(email),
+			"ip":         ip,
+			"success":    success,
+		},
+	}
+
+	return ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("InsertLoginAttempt"))
+}
+
+func (ja *JWTAuth) getLoginState(ctx context.Context, accountID string) (*LoginState, error) {
+	var query struct {
+		Account *LoginState `graphql:"account_by_pk(id: $id)"`
+	}
+
+	variables := map[string]interface{}{
+		"id": graphql.String(accountID),
+	}
+
+	err := ja.controller.Query(ctx, &query, variables, graphql.OperationName("GetLoginState"))
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Account == nil {
+		return nil, errors.New("account not found")
+	}
+
+	return query.Account, nil
+}
+
+// EncodeUnlockToken sign the token of the unlock link of the locked account
+func (ja *JWTAuth) EncodeUnlockToken(accountID string, lockedUntil time.Time) (string, time.Time, error) {
+	// the token is bound to the lockout so it can't be reused after the next lockout
+	return ja.EncodePurposeToken(AudienceUnlockAccount, accountID, lockedUntil.UTC().Format(time.RFC3339), ja.config.Login.UnlockTokenTTL)
+}
+
+// UnlockAccountByToken unlock the account with the token of the unlock email
+func (ja *JWTAuth) UnlockAccountByToken(ctx context.Context, token string) (string, error) {
+	claims, err := ja.DecodePurposeToken(AudienceUnlockAccount, token)
+	if err != nil {
+		return "", err
+	}
+
+	lockedUntil, err := time.Parse(time.RFC3339, claims.Binding)
+	if err != nil {
+		return "", errUnlockTokenStale
+	}
+
+	var mutation struct {
+		UpdateAccount struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"update_account(where: $where, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": account_bool_exp{
+			"id": map[string]interface{}{
+				"_eq": claims.Subject,
+			},
+			"locked_until": map[string]interface{}{
+				"_gte": lockedUntil,
+				"_lt":  lockedUntil.Add(time.Second),
+			},
+		},
+		"set": resetLoginState(),
+	}
+
+	err = ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("UnlockAccountByToken"))
+	if err != nil {
+		return "", err
+	}
+
+	if mutation.UpdateAccount.AffectedRows == 0 {
+		return "", errUnlockTokenStale
+	}
+
+	return claims.Subject, nil
+}
+
+// UnlockAccount reset the lockout and failure counter of the account
+func (ja *JWTAuth) UnlockAccount(ctx context.Context, accountID string) (bool, error) {
+	var mutation struct {
+		UpdateAccount struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"update_account(where: $where, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": account_bool_exp{
+			"id": map[string]interface{}{
+				"_eq": accountID,
+			},
+		},
+		"set": resetLoginState(),
+	}
+
+	err := ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("UnlockAccount"))
+	if err != nil {
+		return false, err
+	}
+
+	return mutation.UpdateAccount.AffectedRows > 0, nil
+}
+
+func (ja *JWTAuth) updateLoginState(ctx context.Context, accountID string, set account_set_input) error {
+	var mutation struct {
+		UpdateAccount struct {
+			ID string `graphql:"id"`
+		} `graphql:"update_account_by_pk(pk_columns: $pk_columns, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"pk_columns": account_pk_columns_input{
+			"id": accountID,
+		},
+		"set": set,
+	}
+
+	return ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("UpdateLoginState"))
+}
+
+func resetLoginState() account_set_input {
+	return account_set_input{
+		"failed_login_count":   0,
+		"last_failed_login_at": nil,
+		"locked_until":         nil,
+	}
+}
+
+func nullableString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+
+	return value
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	config := LoginThrottleConfig{
+		BackoffBase: time.Second,
+		BackoffMax:  10 * time.Second,
+	}
+
+	for failures, expected := range []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		assert.Equal(t, expected, config.backoff(failures), failures)
+	}
+}
+
+func TestCheckLoginState(t *testing.T) {
+	ja := &JWTAuth{
+		config: JWTAuthConfig{
+			Login: LoginThrottleConfig{
+				BackoffBase: time.Minute,
+				BackoffMax:  time.Hour,
+			},
+		},
+	}
+
+	now := time.Now()
+	lockedUntil := now.Add(time.Minute)
+	assert.EqualError(t, ja.CheckLoginState(LoginState{LockedUntil: &lockedUntil}), "account_locked")
+
+	lastFailed := now.Add(-30 * time.Second)
+	err := ja.CheckLoginState(LoginState{FailedLoginCount: 1, LastFailedLoginAt: &lastFailed})
+	assert.Contains(t, err.Error(), "too_many_attempts")
+
+	lastFailed = now.Add(-2 * time.Minute)
+	assert.Nil(t, ja.CheckLoginState(LoginState{FailedLoginCount: 1, LastFailedLoginAt: &lastFailed}))
+}
+
+func TestVerifyAccountCredential(t *testing.T) {
+	errWrongPassword := errors.New("password_not_match")
+	lockedUntil := time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+	expiredLock := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	for _, fixture := range []struct {
+		Name        string
+		LockedUntil string
+		Returning   string
+		VerifyError error
+		Verified    bool
+		Error       error
+		Locked      bool
+	}{
+		{"valid credential", "null", `[{"failed_login_count":1}]`, nil, true, nil, false},
+		{"invalid credential", "null", `[{"failed_login_count":2}]`, errWrongPassword, true, errWrongPassword, false},
+		{"invalid credential at the threshold", "null", `[{"failed_login_count":3}]`, errWrongPassword, true, errWrongPassword, true},
+		{"locked account", `"` + lockedUntil + `"`, `[]`, nil, false, errAccountLocked, false},
+		// the counter restarts after the lockout, so the next failure doesn't lock the account again
+		{"expired lock", `"` + expiredLock + `"`, `[{"failed_login_count":1}]`, errWrongPassword, true, errWrongPassword, false},
+		// another request locked the account after the state was read
+		{"locked concurrently", "null", `[]`, nil, false, errAccountLocked, false},
+		{"attempts over the threshold", "null", `[{"failed_login_count":4}]`, nil, false, errAccountLocked, false},
+	} {
+		fake, controller := newFakeController(t, map[string]string{
+			"GetLoginState":       fmt.Sprintf(`{"account_by_pk":{"failed_login_count":0,"last_failed_login_at":null,"locked_until":%s}}`, fixture.LockedUntil),
+			"ReserveLoginAttempt": `{"update_account":{"returning":` + fixture.Returning + `}}`,
+			"InsertLoginAttempt":  `{"insert_login_attempts_one":{"id":"attempt"}}`,
+			"UpdateLoginState":    `{"update_account_by_pk":{"id":"account"}}`,
+			"ReleaseExpiredLock":  `{"update_account":{"affected_rows":1}}`,
+		})
+		ja := newTestJWTAuth(t, controller, JWTAuthConfig{
+			Login: LoginThrottleConfig{
+				MaxFailedAttempts: 3,
+				LockoutDuration:   time.Minute,
+			},
+		})
+
+		verified := false
+		failure, err := ja.VerifyAccountCredential(context.Background(), "account", "10.0.0.1", func() error {
+			verified = true
+			return fixture.VerifyError
+		})
+		assert.Equal(t, fixture.Error, err, fixture.Name)
+		assert.Equal(t, fixture.Verified, verified, fixture.Name)
+		if fixture.VerifyError != nil {
+			assert.Equal(t, fixture.Locked, failure.Locked, fixture.Name)
+			assert.Len(t, fake.variables("InsertLoginAttempt"), 1, fixture.Name)
+		}
+		assert.Equal(t, fixture.LockedUntil == `"`+expiredLock+`"`, len(fake.variables("ReleaseExpiredLock")) == 1, fixture.Name)
+	}
+}
+
+func TestVerifyFirstFactor(t *testing.T) {
+	lastFailed := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	fake, controller := newFakeController(t, map[string]string{
+		"GetLoginState":       `{"account_by_pk":{"failed_login_count":2,"last_failed_login_at":"` + lastFailed + `","locked_until":null}}`,
+		"ReserveLoginAttempt": `{"update_account":{"returning":[{"failed_login_count":3}]}}`,
+		"ReleaseLoginAttempt": `{"update_account_by_pk":{"id":"account"}}`,
+	})
+	ja := newTestJWTAuth(t, controller, JWTAuthConfig{
+		Login: LoginThrottleConfig{
+			MaxFailedAttempts: 5,
+			LockoutDuration:   time.Minute,
+		},
+	})
+
+	_, err := ja.VerifyFirstFactor(context.Background(), "account", "10.0.0.1", func() error {
+		return nil
+	})
+	assert.Nil(t, err)
+
+	// the failures of the second factor are kept until the login is complete
+	assert.Empty(t, fake.variables("UpdateLoginState"))
+	releases := fake.variables("ReleaseLoginAttempt")
+	if assert.Len(t, releases, 1) {
+		assert.Equal(t, map[string]interface{}{"failed_login_count": float64(-1)}, releases[0]["inc"])
+		assert.Equal(t, map[string]interface{}{"last_failed_login_at": lastFailed}, releases[0]["set"])
+	}
+}
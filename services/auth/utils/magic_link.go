@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+)
+
+type magic_links_bool_exp map[string]interface{}
+type magic_links_insert_input map[string]interface{}
+type magic_links_set_input map[string]interface{}
+
+// AudienceMagicLink is the audience of tokens in magic link emails
+const AudienceMagicLink = "magic_link"
+
+var (
+	errInvalidMagicLink  = errors.New("invalid_magic_link")
+	errTooManyMagicLinks = errors.New("too_many_requests")
+)
+
+// MagicLinkConfig holds the lifetime and rate limits of passwordless login links
+type MagicLinkConfig struct {
+	// TTL is how long magic links are valid
+	TTL time.Duration `envconfig:"MAGIC_LINK_TTL" default:"15m"`
+	// MaxPerAccount is the number of links which an account can receive during RateWindow, zero disables it
+	MaxPerAccount int `envconfig:"MAGIC_LINK_MAX_PER_ACCOUNT" default:"5"`
+	// MaxPerIP is the number of links which an IP address can request during RateWindow, zero disables it
+	MaxPerIP   int           `envconfig:"MAGIC_LINK_MAX_PER_IP" default:"20"`
+	RateWindow time.Duration `envconfig:"MAGIC_LINK_RATE_WINDOW" default:"1h"`
+}
+
+// IsMagicLinkRateLimited tells if the error is returned because of the magic link rate limits
+func IsMagicLinkRateLimited(err error) bool {
+	return errors.Is(err, errTooManyMagicLinks)
+}
+
+// CheckMagicLinkIP reject the request if the IP address requested too many links recently
+func (ja *JWTAuth) CheckMagicLinkIP(ctx context.Context, ip string) error {
+	config := ja.config.MagicLink
+	if ip == "" || config.MaxPerIP <= 0 {
+		return nil
+	}
+
+	count, err := ja.countMagicLinks(ctx, magic_links_bool_exp{
+		"ip": map[string]interface{}{
+			"_eq": ip,
+		},
+	}, "CountMagicLinksByIP")
+	if err != nil {
+		return err
+	}
+
+	if count >= config.MaxPerIP {
+		return errTooManyMagicLinks
+	}
+
+	return nil
+}
+
+// CreateMagicLink issue a single-use login link token of the account.
+// If deviceToken is set, the link can only be consumed with the same device token
+func (ja *JWTAuth) CreateMagicLink(ctx context.Context, accountID string, deviceToken string, info SessionInfo) (string, time.Time, error) {
+	config := ja.config.MagicLink
+	if config.MaxPerAccount > 0 {
+		count, err := ja.countMagicLinks(ctx, magic_links_bool_exp{
+			"account_id": map[string]interface{}{
+				"_eq": accountID,
+			},
+		}, "CountMagicLinksByAccount")
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		if count >= config.MaxPerAccount {
+			return "", time.Time{}, errTooManyMagicLinks
+		}
+	}
+
+	expiresAt := time.Now().Add(config.TTL)
+	object := magic_links_insert_input{
+		"account_id": accountID,
+		"ip":         info.IP,
+		"expires_at": expiresAt,
+	}
+	if deviceToken != "" {
+		object["device_hash"] = hashOpaqueToken(deviceToken)
+	}
+
+	var mutation struct {
+		InsertMagicLink struct {
+			ID string `graphql:"id"`
+		} `graphql:"insert_magic_links_one(object: $object)"`
+	}
+
+	variables := map[string]interface{}{
+		"object": object,
+	}
+
+	err := ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("CreateMagicLink"))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// the signed token refers to the link record which makes it single use
+	token, _, err := ja.EncodePurposeToken(AudienceMagicLink, accountID, mutation.InsertMagicLink.ID, config.TTL)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiresAt, nil
+}
+
+// ConsumeMagicLink mark the link of the token as used and return its account.
+// Links bound to a device are only accepted with the device token of the request,
+// and aren't used up by attempts from other devices
+func (ja *JWTAuth) ConsumeMagicLink(ctx context.Context, token string, deviceToken string) (string, error) {
+	claims, err := ja.DecodePurposeToken(AudienceMagicLink, token)
+	if err != nil {
+		return "", errInvalidMagicLink
+	}
+
+	now := time.Now()
+	where := magic_links_bool_exp{
+		"id": map[string]interface{}{
+			"_eq": claims.Binding,
+		},
+		"account_id": map[string]interface{}{
+			"_eq": claims.Subject,
+		},
+		"used_at": map[string]interface{}{
+			"_is_null": true,
+		},
+		"expires_at": map[string]interface{}{
+			"_gt": now,
+		},
+	}
+	if deviceToken == "" {
+		where["device_hash"] = map[string]interface{}{
+			"_is_null": true,
+		}
+	} else {
+		where["device_hash"] = map[string]interface{}{
+			"_eq": hashOpaqueToken(deviceToken),
+		}
+	}
+
+	var mutation struct {
+		UpdateMagicLinks struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"update_magic_links(where: $where, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": where,
+		"set": magic_links_set_input{
+			"used_at": now,
+		},
+	}
+
+	err = ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("ConsumeMagicLink"))
+	if err != nil {
+		return "", err
+	}
+
+	if mutation.UpdateMagicLinks.AffectedRows == 0 {
+		return "", errInvalidMagicLink
+	}
+
+	return claims.Subject, nil
+}
+
+func (ja *JWTAuth) countMagicLinks(ctx context.Context, where magic_links_bool_exp, operationName string) (int, error) {
+	var query struct {
+		MagicLinksAggregate struct {
+			Aggregate struct {
+				Count int `graphql:"count"`
+			} `graphql:"aggregate"`
+		} `graphql:"magic_links_aggregate(where: $where)"`
+	}
+
+	where["created_at"] = map[string]interface{}{
+		"_gt": time.Now().Add(-ja.config.MagicLink.RateWindow),
+	}
+	variables := map[string]interface{}{
+		"where": where,
+	}
+
+	err := ja.controller.Query(ctx, &query, variables, graphql.OperationName(operationName))
+	if err != nil {
+		return 0, err
+	}
+
+	return query.MagicLinksAggregate.Aggregate.Count, nil
+}
+
+// NewMagicLinkDeviceToken create the secret which binds a magic link to the requesting device
+func NewMagicLinkDeviceToken() (string, error) {
+	return generateOpaqueToken()
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsumeMagicLinkDeviceBinding(t *testing.T) {
+	for _, fixture := range []struct {
+		Name         string
+		DeviceToken  string
+		AffectedRows string
+		DeviceHash   map[string]interface{}
+		Error        error
+	}{
+		{"link without device", "", "1", map[string]interface{}{"_is_null": true}, nil},
+		{"link of the device", "device", "1", map[string]interface{}{"_eq": hashOpaqueToken("device")}, nil},
+		// a link bound to another device or already used doesn't match the update
+		{"link of another device", "other", "0", map[string]interface{}{"_eq": hashOpaqueToken("other")}, errInvalidMagicLink},
+	} {
+		fake, controller := newFakeController(t, map[string]string{
+			"ConsumeMagicLink": `{"update_magic_links":{"affected_rows":` + fixture.AffectedRows + `}}`,
+		})
+		ja := newTestJWTAuth(t, controller, JWTAuthConfig{})
+
+		token, _, err := ja.EncodePurposeToken(AudienceMagicLink, "account", "link", time.Minute)
+		assert.Nil(t, err, fixture.Name)
+
+		accountID, err := ja.ConsumeMagicLink(context.Background(), token, fixture.DeviceToken)
+		assert.Equal(t, fixture.Error, err, fixture.Name)
+		if fixture.Error == nil {
+			assert.Equal(t, "account", accountID, fixture.Name)
+		}
+
+		requests := fake.variables("ConsumeMagicLink")
+		if assert.Len(t, requests, 1, fixture.Name) {
+			where := requests[0]["where"].(map[string]interface{})
+			assert.Equal(t, fixture.DeviceHash, where["device_hash"], fixture.Name)
+			assert.Equal(t, map[string]interface{}{"_eq": "link"}, where["id"], fixture.Name)
+			assert.Equal(t, map[string]interface{}{"_is_null": true}, where["used_at"], fixture.Name)
+		}
+	}
+}
+
+func TestConsumeMagicLinkRejectsOtherTokens(t *testing.T) {
+	fake, controller := newFakeController(t, map[string]string{})
+	ja := newTestJWTAuth(t, controller, JWTAuthConfig{})
+
+	token, _, err := ja.EncodePurposeToken(AudienceVerifyEmail, "account", "link", time.Minute)
+	assert.Nil(t, err)
+
+	_, err = ja.ConsumeMagicLink(context.Background(), token, "")
+	assert.Equal(t, errInvalidMagicLink, err)
+	assert.Empty(t, fake.variables("ConsumeMagicLink"))
+}
@@ -0,0 +1,400 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+	"nexlab.tech/core/services/auth/totp"
+	"nexlab.tech/core/services/auth/webauthn"
+)
+
+type account_bool_exp map[string]interface{}
+type account_set_input map[string]interface{}
+type account_pk_columns_input map[string]interface{}
+type recovery_codes_bool_exp map[string]interface{}
+type recovery_codes_insert_input map[string]interface{}
+type recovery_codes_set_input map[string]interface{}
+
+const (
+	// AudienceMFA is the audience of challenge tokens which are exchanged for session tokens after the second factor
+	AudienceMFA        = "mfa"
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+var (
+	errTwoFactorEnabled     = errors.New("two_factor_already_enabled")
+	errTwoFactorNotEnrolled = errors.New("two_factor_not_enrolled")
+	errInvalidMFACode       = errors.New("invalid_mfa_code")
+	recoveryCodeEncoding    = base32.StdEncoding.WithPadding(base32.NoPadding)
+	recoveryCodeNormalizer  = strings.NewReplacer("-", "", " ", "")
+)
+
+// TOTPEnrollment is the pending authenticator secret of the account
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+}
+
+// EnrollTOTP generate a new pending TOTP secret of the account.
+// Two-factor authentication isn't enabled until the first code is confirmed
+func (ja *JWTAuth) EnrollTOTP(ctx context.Context, accountID string, accountName string) (*TOTPEnrollment, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	var mutation struct {
+		UpdateAccount struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"update_account(where: $where, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": account_bool_exp{
+			"id": map[string]interface{}{
+				"_eq": accountID,
+			},
+			"totp_enabled_at": map[string]interface{}{
+				"_is_null": true,
+			},
+		},
+		"set": account_set_input{
+			"totp_secret":       secret,
+			"totp_last_counter": nil,
+		},
+	}
+
+	err = ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("EnrollTOTP"))
+	if err != nil {
+		return nil, err
+	}
+
+	if mutation.UpdateAccount.AffectedRows == 0 {
+		return nil, errTwoFactorEnabled
+	}
+
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(ja.config.TOTPIssuer, accountName, secret),
+	}, nil
+}
+
+// ConfirmTOTP enable two-factor authentication with the first code of the pending secret.
+// It returns new recovery codes which are shown to the user once
+func (ja *JWTAuth) ConfirmTOTP(ctx context.Context, accountID string, code string) ([]string, error) {
+	account, err := ja.getTOTPAccount(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if account.TOTPEnabledAt != nil {
+		return nil, errTwoFactorEnabled
+	}
+	if account.TOTPSecret == nil || *account.TOTPSecret == "" {
+		return nil, errTwoFactorNotEnrolled
+	}
+
+	counter, ok := totp.Validate(*account.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, errInvalidMFACode
+	}
+
+	codes, objects, err := generateRecoveryCodes(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	var mutation struct {
+		UpdateAccount struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"update_account(where: $where, _set: $set)"`
+		DeleteRecoveryCodes struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"delete_recovery_codes(where: $recovery_where)"`
+		InsertRecoveryCodes struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"insert_recovery_codes(objects: $objects)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": account_bool_exp{
+			"id": map[string]interface{}{
+				"_eq": accountID,
+			},
+			"totp_secret": map[string]interface{}{
+				"_eq": *account.TOTPSecret,
+			},
+		},
+		"set": account_set_input{
+			"totp_enabled_at":   time.Now(),
+			"totp_last_counter": counter,
+		},
+		"recovery_where": recovery_codes_bool_exp{
+			"account_id": map[string]interface{}{
+				"_eq": accountID,
+			},
+		},
+		"objects": objects,
+	}
+
+	err = ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("ConfirmTOTP"))
+	if err != nil {
+		return nil, err
+	}
+
+	// the secret is replaced by another enrollment
+	if mutation.UpdateAccount.AffectedRows == 0 {
+		return nil, errTwoFactorNotEnrolled
+	}
+
+	return codes, nil
+}
+
+// ResetTOTP disable two-factor authentication of the account and remove its recovery codes.
+// Passkeys are kept, they are reset by ResetWebAuthnCredentials
+func (ja *JWTAuth) ResetTOTP(ctx context.Context, accountID string) (bool, error) {
+	var mutation struct {
+		UpdateAccount struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"update_account(where: $where, _set: $set)"`
+		DeleteRecoveryCodes struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"delete_recovery_codes(where: $recovery_where)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": account_bool_exp{
+			"id": map[string]interface{}{
+				"_eq": accountID,
+			},
+		},
+		"set": account_set_input{
+			"totp_secret":       nil,
+			"totp_enabled_at":   nil,
+			"totp_last_counter": nil,
+		},
+		"recovery_where": recovery_codes_bool_exp{
+			"account_id": map[string]interface{}{
+				"_eq": accountID,
+			},
+		},
+	}
+
+	err := ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("ResetTOTP"))
+	if err != nil {
+		return false, err
+	}
+
+	return mutation.UpdateAccount.AffectedRows > 0, nil
+}
+
+// EncodeMFAChallenge sign the challenge token which the client exchanges with the second factor
+func (ja *JWTAuth) EncodeMFAChallenge(accountID string) (string, time.Time, error) {
+	return ja.EncodePurposeToken(AudienceMFA, accountID, "", ja.config.MFATokenTTL)
+}
+
+// VerifyMFAChallenge validate the challenge token and the TOTP or recovery code.
+// It returns the account id. Challenges are single use, and failures count towards the account lockout
+func (ja *JWTAuth) VerifyMFAChallenge(ctx context.Context, token string, code string, ip string) (string, error) {
+	return ja.verifyMFAChallenge(ctx, token, ip, func(accountID string) error {
+		return ja.VerifySecondFactor(ctx, accountID, code)
+	})
+}
+
+// VerifyMFAWebAuthn validate the challenge token with the passkey assertion of the account
+func (ja *JWTAuth) VerifyMFAWebAuthn(ctx context.Context, token string, challengeToken string, credentialID string, response webauthn.AssertionResponse, ip string) (string, error) {
+	return ja.verifyMFAChallenge(ctx, token, ip, func(accountID string) error {
+		_, err := ja.FinishWebAuthnLogin(ctx, challengeToken, credentialID, response, accountID)
+		return err
+	})
+}
+
+// DecodeMFAChallenge return the account of a pending challenge token without consuming it
+func (ja *JWTAuth) DecodeMFAChallenge(token string) (string, error) {
+	claims, err := ja.DecodePurposeToken(AudienceMFA, token)
+	if err != nil {
+		return "", err
+	}
+
+	return claims.Subject, nil
+}
+
+// verifyMFAChallenge verify the second factor under the login brute-force protection of the account,
+// then claim the challenge token in the database so that it can't be used again
+func (ja *JWTAuth) verifyMFAChallenge(ctx context.Context, token string, ip string, verify func(accountID string) error) (string, error) {
+	claims, err := ja.DecodePurposeToken(AudienceMFA, token)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = ja.VerifyAccountCredential(ctx, claims.Subject, ip, func() error {
+		if err := verify(claims.Subject); err != nil {
+			return err
+		}
+		return ja.claimPurposeToken(ctx, claims)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return claims.Subject, nil
+}
+
+// VerifySecondFactor check the TOTP code or an unused recovery code of the account.
+// Used TOTP time steps and recovery codes can't be replayed
+func (ja *JWTAuth) VerifySecondFactor(ctx context.Context, accountID string, code string) error {
+	account, err := ja.getTOTPAccount(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	if account.TOTPEnabledAt == nil || account.TOTPSecret == nil {
+		return errTwoFactorNotEnrolled
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == totp.DefaultDigits {
+		return ja.consumeTOTPCode(ctx, accountID, *account.TOTPSecret, account.TOTPLastCounter, code)
+	}
+
+	return ja.consumeRecoveryCode(ctx, accountID, code)
+}
+
+type totpAccount struct {
+	TOTPSecret      *string    `graphql:"totp_secret"`
+	TOTPEnabledAt   *time.Time `graphql:"totp_enabled_at"`
+	TOTPLastCounter *int64     `graphql:"totp_last_counter"`
+}
+
+func (ja *JWTAuth) getTOTPAccount(ctx context.Context, accountID string) (*totpAccount, error) {
+	var query struct {
+		Account *totpAccount `graphql:"account_by_pk(id: $id)"`
+	}
+
+	variables := map[string]interface{}{
+		"id": graphql.String(accountID),
+	}
+
+	err := ja.controller.Query(ctx, &query, variables, graphql.OperationName("GetAccountTOTP"))
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Account == nil {
+		return nil, errors.New("account not found")
+	}
+
+	return query.Account, nil
+}
+
+func (ja *JWTAuth) consumeTOTPCode(ctx context.Context, accountID string, secret string, lastCounter *int64, code string) error {
+	counter, ok := totp.Validate(secret, code, time.Now())
+	if !ok || (lastCounter != nil && counter <= *lastCounter) {
+		return errInvalidMFACode
+	}
+
+	var mutation struct {
+		UpdateAccount struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"update_account(where: $where, _set: $set)"`
+	}
+
+	// the conditional update rejects concurrent replays of the same code
+	variables := map[string]interface{}{
+		"where": account_bool_exp{
+			"id": map[string]interface{}{
+				"_eq": accountID,
+			},
+			"_or": []map[string]interface{}{
+				{
+					"totp_last_counter": map[string]interface{}{
+						"_is_null": true,
+					},
+				},
+				{
+					"totp_last_counter": map[string]interface{}{
+						"_lt": counter,
+					},
+				},
+			},
+		},
+		"set": account_set_input{
+			"totp_last_counter": counter,
+		},
+	}
+
+	err := ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("ConsumeTOTPCode"))
+	if err != nil {
+		return err
+	}
+
+	if mutation.UpdateAccount.AffectedRows == 0 {
+		return errInvalidMFACode
+	}
+
+	return nil
+}
+
+func (ja *JWTAuth) consumeRecoveryCode(ctx context.Context, accountID string, code string) error {
+	var mutation struct {
+		UpdateRecoveryCodes struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"update_recovery_codes(where: $where, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": recovery_codes_bool_exp{
+			"account_id": map[string]interface{}{
+				"_eq": accountID,
+			},
+			"code_hash": map[string]interface{}{
+				"_eq": hashRecoveryCode(code),
+			},
+			"used_at": map[string]interface{}{
+				"_is_null": true,
+			},
+		},
+		"set": recovery_codes_set_input{
+			"used_at": time.Now(),
+		},
+	}
+
+	err := ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("ConsumeRecoveryCode"))
+	if err != nil {
+		return err
+	}
+
+	if mutation.UpdateRecoveryCodes.AffectedRows == 0 {
+		return errInvalidMFACode
+	}
+
+	return nil
+}
+
+// generateRecoveryCodes create random codes in xxxxx-xxxxx format and their insert objects
+func generateRecoveryCodes(accountID string) ([]string, []recovery_codes_insert_input, error) {
+	codes := make([]string, recoveryCodeCount)
+	objects := make([]recovery_codes_insert_input, recoveryCodeCount)
+	for i := range codes {
+		random := make([]byte, recoveryCodeLength*5/8)
+		if _, err := rand.Read(random); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(random))
+		codes[i] = code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+		objects[i] = recovery_codes_insert_input{
+			"account_id": accountID,
+			"code_hash":  hashRecoveryCode(codes[i]),
+		}
+	}
+
+	return codes, objects, nil
+}
+
+func hashRecoveryCode(code string) string {
+	return hashOpaqueToken(strings.ToLower(recoveryCodeNormalizer.Replace(code)))
+}
@@ -0,0 +1,247 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// password hash algorithms
+const (
+	HashAlgorithmArgon2id = "argon2id"
+	HashAlgorithmBcrypt   = "bcrypt"
+)
+
+var (
+	errInvalidPasswordHash = errors.New("invalid password hash")
+)
+
+// PasswordHashConfig holds the algorithm and parameters of new password hashes.
+// Hashes of other algorithms or parameters are still verified and upgraded on login
+type PasswordHashConfig struct {
+	Algorithm         string `envconfig:"PASSWORD_HASH_ALGORITHM" default:"argon2id"`
+	Argon2Memory      uint32 `envconfig:"ARGON2_MEMORY" default:"65536"`
+	Argon2Time        uint32 `envconfig:"ARGON2_TIME" default:"3"`
+	Argon2Parallelism uint8  `envconfig:"ARGON2_PARALLELISM" default:"2"`
+}
+
+// PasswordHasher encodes and verifies password hashes of an algorithm
+type PasswordHasher interface {
+	// Hash encode the password with a random salt
+	Hash(password string) (string, error)
+	// Verify compare the password with the encoded hash
+	Verify(encoded string, password string) (bool, error)
+	// Supports check if the hash is encoded by the algorithm
+	Supports(encoded string) bool
+	// NeedsRehash check if the hash is encoded with outdated parameters
+	NeedsRehash(encoded string) bool
+}
+
+// argon2Params are the cost parameters of argon2id
+type argon2Params struct {
+	Memory      uint32
+	Time        uint32
+	Parallelism uint8
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+type argon2idHasher struct {
+	params argon2Params
+}
+
+// NewArgon2idHasher create the argon2id hasher which encodes hashes in PHC string format, e.g
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+func NewArgon2idHasher(memory uint32, time uint32, parallelism uint8) PasswordHasher {
+	return &argon2idHasher{
+		params: argon2Params{
+			Memory:      memory,
+			Time:        time,
+			Parallelism: parallelism,
+		},
+	}
+}
+
+func (ah *argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, ah.params.Time, ah.params.Memory, ah.params.Parallelism, argon2KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		ah.params.Memory,
+		ah.params.Time,
+		ah.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (ah *argon2idHasher) Verify(encoded string, password string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Parallelism, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (ah *argon2idHasher) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (ah *argon2idHasher) NeedsRehash(encoded string) bool {
+	params, _, _, err := decodeArgon2id(encoded)
+
+	return err != nil || params != ah.params
+}
+
+// decodeArgon2id parse the PHC string of an argon2id hash
+func decodeArgon2id(encoded string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != HashAlgorithmArgon2id {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Parallelism); err != nil {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	return params, salt, key, nil
+}
+
+type bcryptHasher struct {
+	cost int
+}
+
+// NewBcryptHasher create the bcrypt hasher. Hashes use the modular crypt format, e.g $2a$10$<salt><hash>
+func NewBcryptHasher(cost int) PasswordHasher {
+	return &bcryptHasher{cost: cost}
+}
+
+func (bh *bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bh.cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+func (bh *bcryptHasher) Verify(encoded string, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (bh *bcryptHasher) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (bh *bcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+
+	return err != nil || cost != bh.cost
+}
+
+// passwordHashers hash new passwords with the preferred algorithm
+// and verify hashes of every supported algorithm
+type passwordHashers struct {
+	preferred PasswordHasher
+	hashers   []PasswordHasher
+}
+
+func (jac JWTAuthConfig) newPasswordHashers() (*passwordHashers, error) {
+	config := jac.PasswordHash
+	argon2id := NewArgon2idHasher(config.Argon2Memory, config.Argon2Time, config.Argon2Parallelism)
+	bcryptHasher := NewBcryptHasher(jac.Cost)
+
+	var preferred PasswordHasher
+	switch config.Algorithm {
+	case HashAlgorithmArgon2id:
+		if config.Argon2Memory == 0 || config.Argon2Time == 0 || config.Argon2Parallelism == 0 {
+			return nil, errors.New("ARGON2_MEMORY, ARGON2_TIME and ARGON2_PARALLELISM must be positive")
+		}
+		preferred = argon2id
+	case HashAlgorithmBcrypt:
+		preferred = bcryptHasher
+	default:
+		return nil, fmt.Errorf("invalid PASSWORD_HASH_ALGORITHM %s, accepted values: argon2id, bcrypt", config.Algorithm)
+	}
+
+	return &passwordHashers{
+		preferred: preferred,
+		hashers:   []PasswordHasher{argon2id, bcryptHasher},
+	}, nil
+}
+
+func (ph *passwordHashers) find(encoded string) PasswordHasher {
+	for _, hasher := range ph.hashers {
+		if hasher.Supports(encoded) {
+			return hasher
+		}
+	}
+
+	return nil
+}
+
+// HashPassword encode the password with the configured algorithm
+func (ja *JWTAuth) HashPassword(password string) (string, error) {
+	return ja.hashers.preferred.Hash(password)
+}
+
+// ComparePassword check the password against the hash of any supported algorithm
+func (ja *JWTAuth) ComparePassword(hashedPassword, password string) error {
+	hasher := ja.hashers.find(hashedPassword)
+	if hasher == nil {
+		return errInvalidPasswordHash
+	}
+
+	ok, err := hasher.Verify(hashedPassword, password)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errPasswordNotMatch
+	}
+
+	return nil
+}
+
+// PasswordNeedsRehash check if the hash should be upgraded to the configured algorithm and parameters
+func (ja *JWTAuth) PasswordNeedsRehash(hashedPassword string) bool {
+	return !ja.hashers.preferred.Supports(hashedPassword) || ja.hashers.preferred.NeedsRehash(hashedPassword)
+}
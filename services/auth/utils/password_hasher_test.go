@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestArgon2idHasher(t *testing.T) {
+	hasher := NewArgon2idHasher(1024, 1, 1)
+
+	hash, err := hasher.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"), hash)
+	assert.True(t, hasher.Supports(hash))
+	assert.False(t, hasher.NeedsRehash(hash))
+
+	ok, err := hasher.Verify(hash, "secret")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	ok, err = hasher.Verify(hash, "Secret")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	other, _ := hasher.Hash("secret")
+	assert.NotEqual(t, hash, other, "salts must be random")
+
+	assert.True(t, NewArgon2idHasher(2048, 1, 1).NeedsRehash(hash))
+
+	_, err = hasher.Verify("$argon2id$v=19$m=1024,t=1$c2FsdA$a2V5", "secret")
+	assert.Equal(t, errInvalidPasswordHash, err)
+}
+
+func TestArgon2idReferenceHash(t *testing.T) {
+	// generated by the reference implementation: echo -n password | argon2 somesalt -id -t 2 -m 16 -p 1
+	hash := "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc"
+	hasher := NewArgon2idHasher(65536, 2, 1)
+
+	ok, err := hasher.Verify(hash, "password")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestPasswordRehash(t *testing.T) {
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+
+	for _, fixture := range []struct {
+		Algorithm string
+		Rehash    bool
+	}{
+		{HashAlgorithmArgon2id, true},
+		{HashAlgorithmBcrypt, false},
+	} {
+		config := JWTAuthConfig{
+			Cost: bcrypt.MinCost,
+			PasswordHash: PasswordHashConfig{
+				Algorithm:         fixture.Algorithm,
+				Argon2Memory:      1024,
+				Argon2Time:        1,
+				Argon2Parallelism: 1,
+			},
+		}
+		hashers, err := config.newPasswordHashers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ja := &JWTAuth{hashers: hashers}
+
+		assert.Nil(t, ja.ComparePassword(string(bcryptHash), "secret"), fixture.Algorithm)
+		assert.Equal(t, errPasswordNotMatch, ja.ComparePassword(string(bcryptHash), "other"), fixture.Algorithm)
+		assert.Equal(t, fixture.Rehash, ja.PasswordNeedsRehash(string(bcryptHash)), fixture.Algorithm)
+
+		hash, err := ja.HashPassword("secret")
+		assert.Nil(t, err, fixture.Algorithm)
+		assert.Nil(t, ja.ComparePassword(hash, "secret"), fixture.Algorithm)
+		assert.False(t, ja.PasswordNeedsRehash(hash), fixture.Algorithm)
+	}
+
+	_, err := JWTAuthConfig{PasswordHash: PasswordHashConfig{Algorithm: "md5"}}.newPasswordHashers()
+	assert.EqualError(t, err, "invalid PASSWORD_HASH_ALGORITHM md5, accepted values: argon2id, bcrypt")
+}
@@ -0,0 +1,247 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+)
+
+type password_resets_bool_exp map[string]interface{}
+type password_resets_insert_input map[string]interface{}
+type password_resets_set_input map[string]interface{}
+
+var (
+	errInvalidResetToken     = errors.New("invalid_reset_token")
+	errTooManyPasswordResets = errors.New("too_many_requests")
+)
+
+// IsPasswordResetRateLimited tells if the error is returned because of the password reset rate limits
+func IsPasswordResetRateLimited(err error) bool {
+	return errors.Is(err, errTooManyPasswordResets)
+}
+
+// CheckPasswordResetIP reject the request if the IP address requested too many resets recently
+func (ja *JWTAuth) CheckPasswordResetIP(ctx context.Context, ip string) error {
+	if ip == "" || ja.config.PasswordResetMaxPerIP <= 0 {
+		return nil
+	}
+
+	count, err := ja.countPasswordResets(ctx, password_resets_bool_exp{
+		"ip": map[string]interface{}{
+			"_eq": ip,
+		},
+	}, "CountPasswordResetsByIP")
+	if err != nil {
+		return err
+	}
+
+	if count >= ja.config.PasswordResetMaxPerIP {
+		return errTooManyPasswordResets
+	}
+
+	return nil
+}
+
+// CreatePasswordReset issue a single-use password reset token of the account.
+// Only the token hash is stored so leaked database rows can't be used to reset passwords
+func (ja *JWTAuth) CreatePasswordReset(ctx context.Context, accountID string, info SessionInfo) (string, time.Time, error) {
+	if ja.config.PasswordResetMaxPerAccount > 0 {
+		count, err := ja.countPasswordResets(ctx, password_resets_bool_exp{
+			"account_id": map[string]interface{}{
+				"_eq": accountID,
+			},
+		}, "CountPasswordResetsByAccount")
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		if count >= ja.config.PasswordResetMaxPerAccount {
+			return "", time.Time{}, errTooManyPasswordResets
+		}
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(ja.config.PasswordResetTTL)
+	var mutation struct {
+		InsertPasswordReset struct {
+			ID string `graphql:"id"`
+		} `graphql:"insert_password_resets_one(object: $object)"`
+	}
+
+	variables := map[string]interface{}{
+		"object": password_resets_insert_input{
+			"account_id": accountID,
+			"token_hash": hashOpaqueToken(token),
+			"ip":         info.IP,
+			"expires_at": expiresAt,
+		},
+	}
+
+	err = ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("CreatePasswordReset"))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiresAt, nil
+}
+
+// FindPasswordReset return the account of a pending reset token without consuming it,
+// so the new password can be validated before the token is used up
+func (ja *JWTAuth) FindPasswordReset(ctx context.Context, token string) (string, error) {
+	if token == "" {
+		return "", errInvalidResetToken
+	}
+
+	var query struct {
+		PasswordResets []struct {
+			AccountID string `graphql:"account_id"`
+		} `graphql:"password_resets(where: $where, limit: 1)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": password_resets_bool_exp{
+			"token_hash": map[string]interface{}{
+				"_eq": hashOpaqueToken(token),
+			},
+			"used_at": map[string]interface{}{
+				"_is_null": true,
+			},
+			"expires_at": map[string]interface{}{
+				"_gt": time.Now(),
+			},
+		},
+	}
+
+	err := ja.controller.Query(ctx, &query, variables, graphql.OperationName("FindPasswordReset"))
+	if err != nil {
+		return "", err
+	}
+
+	if len(query.PasswordResets) == 0 {
+		return "", errInvalidResetToken
+	}
+
+	return query.PasswordResets[0].AccountID, nil
+}
+
+// ConsumePasswordReset mark the reset token as used and return its account.
+// Other pending tokens of the account are invalidated too
+func (ja *JWTAuth) ConsumePasswordReset(ctx context.Context, token string) (string, error) {
+	if token == "" {
+		return "", errInvalidResetToken
+	}
+
+	now := time.Now()
+	var mutation struct {
+		UpdatePasswordResets struct {
+			Returning []struct {
+				AccountID string `graphql:"account_id"`
+			} `graphql:"returning"`
+		} `graphql:"update_password_resets(where: $where, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": password_resets_bool_exp{
+			"token_hash": map[string]interface{}{
+				"_eq": hashOpaqueToken(token),
+			},
+			"used_at": map[string]interface{}{
+				"_is_null": true,
+			},
+			"expires_at": map[string]interface{}{
+				"_gt": now,
+			},
+		},
+		"set": password_resets_set_input{
+			"used_at": now,
+		},
+	}
+
+	err := ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("ConsumePasswordReset"))
+	if err != nil {
+		return "", err
+	}
+
+	if len(mutation.UpdatePasswordResets.Returning) == 0 {
+		return "", errInvalidResetToken
+	}
+	accountID := mutation.UpdatePasswordResets.Returning[0].AccountID
+
+	return accountID, ja.invalidatePasswordResets(ctx, accountID)
+}
+
+// invalidatePasswordResets mark every pending reset token of the account as used
+func (ja *JWTAuth) invalidatePasswordResets(ctx context.Context, accountID string) error {
+	var mutation struct {
+		UpdatePasswordResets struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"update_password_resets(where: $where, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": password_resets_bool_exp{
+			"account_id": map[string]interface{}{
+				"_eq": accountID,
+			},
+			"used_at": map[string]interface{}{
+				"_is_null": true,
+			},
+		},
+		"set": password_resets_set_input{
+			"used_at": time.Now(),
+		},
+	}
+
+	return ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("InvalidatePasswordResets"))
+}
+
+// generateOpaqueToken create a random URL safe token
+func generateOpaqueToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// hashOpaqueToken return the stored form of a random token.
+// SHA-256 is enough because tokens have high entropy
+func hashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// countPasswordResets count the resets requested during the rate window
+func (ja *JWTAuth) countPasswordResets(ctx context.Context, where password_resets_bool_exp, operationName string) (int, error) {
+	var query struct {
+		PasswordResetsAggregate struct {
+			Aggregate struct {
+				Count int `graphql:"count"`
+			} `graphql:"aggregate"`
+		} `graphql:"password_resets_aggregate(where: $where)"`
+	}
+
+	where["created_at"] = map[string]interface{}{
+		"_gt": time.Now().Add(-ja.config.PasswordResetRateWindow),
+	}
+	variables := map[string]interface{}{
+		"where": where,
+	}
+
+	err := ja.controller.Query(ctx, &query, variables, graphql.OperationName(operationName))
+	if err != nil {
+		return 0, err
+	}
+
+	return query.PasswordResetsAggregate.Aggregate.Count, nil
+}
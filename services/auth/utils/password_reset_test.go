@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsumePasswordReset(t *testing.T) {
+	for _, fixture := range []struct {
+		Name      string
+		Token     string
+		Returning string
+		AccountID string
+		Error     error
+	}{
+		{"pending token", "token", `[{"account_id":"account"}]`, "account", nil},
+		{"used or expired token", "token", `[]`, "", errInvalidResetToken},
+		{"empty token", "", `[]`, "", errInvalidResetToken},
+	} {
+		fake, controller := newFakeController(t, map[string]string{
+			"ConsumePasswordReset":     `{"update_password_resets":{"returning":` + fixture.Returning + `}}`,
+			"InvalidatePasswordResets": `{"update_password_resets":{"affected_rows":1}}`,
+		})
+		ja := newTestJWTAuth(t, controller, JWTAuthConfig{})
+
+		accountID, err := ja.ConsumePasswordReset(context.Background(), fixture.Token)
+		assert.Equal(t, fixture.Error, err, fixture.Name)
+		assert.Equal(t, fixture.AccountID, accountID, fixture.Name)
+
+		requests := fake.variables("ConsumePasswordReset")
+		if fixture.Token == "" {
+			assert.Empty(t, requests, fixture.Name)
+			continue
+		}
+		// only the hash of the token is stored and looked up
+		if assert.Len(t, requests, 1, fixture.Name) {
+			where := requests[0]["where"].(map[string]interface{})
+			assert.Equal(t, map[string]interface{}{"_eq": hashOpaqueToken(fixture.Token)}, where["token_hash"], fixture.Name)
+		}
+		// other pending tokens of the account are invalidated once the token is used
+		assert.Equal(t, fixture.Error == nil, len(fake.variables("InvalidatePasswordResets")) == 1, fixture.Name)
+	}
+}
+
+func TestCreatePasswordResetRateLimit(t *testing.T) {
+	fake, controller := newFakeController(t, map[string]string{
+		"CountPasswordResetsByAccount": `{"password_resets_aggregate":{"aggregate":{"count":3}}}`,
+	})
+	ja := newTestJWTAuth(t, controller, JWTAuthConfig{
+		PasswordResetMaxPerAccount: 3,
+	})
+
+	_, _, err := ja.CreatePasswordReset(context.Background(), "account", SessionInfo{})
+	assert.True(t, IsPasswordResetRateLimited(err))
+	assert.Empty(t, fake.variables("CreatePasswordReset"))
+}
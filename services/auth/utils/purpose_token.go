@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hasura/go-graphql-client"
+)
+
+type used_tokens_bool_exp map[string]interface{}
+type used_tokens_insert_input map[string]interface{}
+type used_tokens_on_conflict map[string]interface{}
+
+// audiences of purpose tokens
+const (
+	AudienceVerifyEmail = "verify_email"
+)
+
+var errTokenUsed = errors.New("token_used")
+
+// EncodePurposeToken sign a short-lived token which authorizes a single step of a flow, e.g. email verification.
+// The audience prevents the token from being accepted as an access token or by another flow
+func (ja *JWTAuth) EncodePurposeToken(audience string, subject string, binding string, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	exp := now.Add(ttl)
+	payload := jwtPayload{
+		JwtID:          uuid.New().String(),
+		Issuer:         ja.config.Issuer,
+		Subject:        subject,
+		Audience:       audience,
+		IssuedAt:       now.Unix(),
+		NotBeforeTime:  now.Unix(),
+		ExpirationTime: exp.Unix(),
+		Binding:        binding,
+	}
+
+	token, err := ja.sign(payload)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, exp, nil
+}
+
+// DecodePurposeToken verify the signature, audience and expiry of the purpose token
+func (ja *JWTAuth) DecodePurposeToken(audience string, token string) (*jwtPayload, error) {
+	result, err := ja.ParseToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Audience != audience || result.Subject == "" {
+		return nil, errors.New("token_mismatch")
+	}
+
+	if result.ExpirationTime <= time.Now().Unix() {
+		return nil, errors.New("token_expired")
+	}
+
+	return result, nil
+}
+
+// claimPurposeToken mark the single-use token as used. The jti is inserted once in the database,
+// so the token can't be used twice by concurrent requests or on another instance
+func (ja *JWTAuth) claimPurposeToken(ctx context.Context, claims *jwtPayload) error {
+	var mutation struct {
+		InsertUsedTokens struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"insert_used_tokens(objects: $objects, on_conflict: $on_conflict)"`
+	}
+
+	variables := map[string]interface{}{
+		"objects": []used_tokens_insert_input{
+			{
+				"jti":        claims.JwtID,
+				"audience":   claims.Audience,
+				"expires_at": time.Unix(claims.ExpirationTime, 0),
+			},
+		},
+		"on_conflict": used_tokens_on_conflict{
+			"constraint":     "used_tokens_pkey",
+			"update_columns": []string{},
+		},
+	}
+
+	err := ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("ClaimPurposeToken"))
+	if err != nil {
+		return err
+	}
+
+	if mutation.InsertUsedTokens.AffectedRows == 0 {
+		return errTokenUsed
+	}
+
+	return nil
+}
+
+// PruneUsedTokens delete the claims of expired single-use tokens,
+// which are rejected by the expiry already. It returns the number of deleted claims
+func (ja *JWTAuth) PruneUsedTokens(ctx context.Context) (int, error) {
+	var mutation struct {
+		DeleteUsedTokens struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"delete_used_tokens(where: $where)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": used_tokens_bool_exp{
+			"expires_at": map[string]interface{}{
+				"_lt": time.Now(),
+			},
+		},
+	}
+
+	err := ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("PruneUsedTokens"))
+	if err != nil {
+		return 0, err
+	}
+
+	return mutation.DeleteUsedTokens.AffectedRows, nil
+}
+
+// InvalidateAccountTokens evict cached verification results of the account
+// so that changes of its role or status apply to the next request
+func (ja *JWTAuth) InvalidateAccountTokens(accountID string) {
+	ja.invalidateVerifiedTokens(accountID, "", "")
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodePurposeToken(t *testing.T) {
+	ja := newTestJWTAuth(t, nil, JWTAuthConfig{})
+
+	token, _, err := ja.EncodePurposeToken(AudienceVerifyEmail, "account", "user@example.com", time.Minute)
+	assert.Nil(t, err)
+
+	claims, err := ja.DecodePurposeToken(AudienceVerifyEmail, token)
+	assert.Nil(t, err)
+	assert.Equal(t, "account", claims.Subject)
+	assert.Equal(t, "user@example.com", claims.Binding)
+
+	// tokens of other purposes, e.g. password resets or access tokens, aren't accepted
+	_, err = ja.DecodePurposeToken(AudienceMagicLink, token)
+	assert.EqualError(t, err, "token_mismatch")
+
+	expired, _, err := ja.EncodePurposeToken(AudienceVerifyEmail, "account", "user@example.com", -time.Minute)
+	assert.Nil(t, err)
+	_, err = ja.DecodePurposeToken(AudienceVerifyEmail, expired)
+	assert.EqualError(t, err, "token_expired")
+
+	other := newTestJWTAuth(t, nil, JWTAuthConfig{})
+	other.config.Issuer = "other"
+	_, err = other.DecodePurposeToken(AudienceVerifyEmail, token)
+	assert.EqualError(t, err, "jwt_invalid_issuer")
+}
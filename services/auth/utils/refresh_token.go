@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/sirupsen/logrus"
+)
+
+type refresh_tokens_bool_exp map[string]interface{}
+type refresh_tokens_insert_input map[string]interface{}
+type refresh_tokens_set_input map[string]interface{}
+
+// security event types
+const (
+	SecurityEventRefreshTokenReuse = "refresh_token_reuse"
+)
+
+var (
+	errRefreshTokenReused = errors.New("refresh_token_reused")
+)
+
+// SecurityEvent describes a suspicious activity detected while handling tokens
+type SecurityEvent struct {
+	Type      string
+	AccountID string
+	SessionID string
+	Metadata  map[string]interface{}
+}
+
+// OnSecurityEvent register a callback which is called when a security event is emitted
+func (ja *JWTAuth) OnSecurityEvent(callback func(event SecurityEvent)) {
+	ja.securityHooks = append(ja.securityHooks, callback)
+}
+
+func (ja *JWTAuth) emitSecurityEvent(event SecurityEvent) {
+	logrus.WithFields(logrus.Fields{
+		"security_event": event.Type,
+		"account_id":     event.AccountID,
+		"session_id":     event.SessionID,
+		"metadata":       event.Metadata,
+	}).Warn("security event")
+
+	for _, hook := range ja.securityHooks {
+		hook(event)
+	}
+}
+
+// storeRefreshToken record the issued refresh token so that it can be consumed only once.
+// Every refresh token of a session belongs to the same token family
+func (ja *JWTAuth) storeRefreshToken(ctx context.Context, jwtID string, sessionID string, expiresAt time.Time) error {
+	var mutation struct {
+		InsertRefreshToken struct {
+			ID string `graphql:"id"`
+		} `graphql:"insert_refresh_tokens_one(object: $object)"`
+	}
+
+	variables := map[string]interface{}{
+		"object": refresh_tokens_insert_input{
+			"id":         jwtID,
+			"session_id": sessionID,
+			"expires_at": expiresAt,
+		},
+	}
+
+	return ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("CreateRefreshToken"))
+}
+
+// consumeRefreshToken mark the refresh token as used.
+// A token which is unknown or already used means the token family is compromised,
+// so the whole session is revoked
+func (ja *JWTAuth) consumeRefreshToken(ctx context.Context, token *jwtPayload) error {
+	var mutation struct {
+		UpdateRefreshTokens struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"update_refresh_tokens(where: $where, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": refresh_tokens_bool_exp{
+			"id": map[string]interface{}{
+				"_eq": token.JwtID,
+			},
+			"session_id": map[string]interface{}{
+				"_eq": token.SessionID,
+			},
+			"used_at": map[string]interface{}{
+				"_is_null": true,
+			},
+		},
+		"set": refresh_tokens_set_input{
+			"used_at": time.Now(),
+		},
+	}
+
+	err := ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("ConsumeRefreshToken"))
+	if err != nil {
+		return err
+	}
+
+	if mutation.UpdateRefreshTokens.AffectedRows > 0 {
+		return nil
+	}
+
+	if _, err := ja.RevokeSession(ctx, token.Subject, token.SessionID); err != nil {
+		return err
+	}
+
+	ja.emitSecurityEvent(SecurityEvent{
+		Type:      SecurityEventRefreshTokenReuse,
+		AccountID: token.Subject,
+		SessionID: token.SessionID,
+		Metadata: map[string]interface{}{
+			"jti": token.JwtID,
+		},
+	})
+
+	return errRefreshTokenReused
+}
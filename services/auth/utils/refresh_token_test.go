@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsumeRefreshToken(t *testing.T) {
+	for _, fixture := range []struct {
+		Name         string
+		AffectedRows string
+		Error        error
+		Revoked      bool
+	}{
+		{"unused token", "1", nil, false},
+		{"reused token", "0", errRefreshTokenReused, true},
+	} {
+		fake, controller := newFakeController(t, map[string]string{
+			"ConsumeRefreshToken": `{"update_refresh_tokens":{"affected_rows":` + fixture.AffectedRows + `}}`,
+			"RevokeSessions":      `{"update_sessions":{"affected_rows":1}}`,
+		})
+		ja := newTestJWTAuth(t, controller, JWTAuthConfig{})
+
+		var events []SecurityEvent
+		ja.OnSecurityEvent(func(event SecurityEvent) {
+			events = append(events, event)
+		})
+
+		err := ja.consumeRefreshToken(context.Background(), &jwtPayload{
+			JwtID:     "jti-refresh",
+			Subject:   "account",
+			SessionID: "session",
+		})
+		assert.Equal(t, fixture.Error, err, fixture.Name)
+
+		revocations := fake.variables("RevokeSessions")
+		if !fixture.Revoked {
+			assert.Empty(t, revocations, fixture.Name)
+			assert.Empty(t, events, fixture.Name)
+			continue
+		}
+
+		// the whole token family is revoked with its session
+		if assert.Len(t, revocations, 1, fixture.Name) {
+			where := revocations[0]["where"].(map[string]interface{})
+			assert.Equal(t, map[string]interface{}{"_eq": "session"}, where["id"], fixture.Name)
+			assert.Equal(t, map[string]interface{}{"_eq": "account"}, where["account_id"], fixture.Name)
+		}
+		if assert.Len(t, events, 1, fixture.Name) {
+			assert.Equal(t, SecurityEventRefreshTokenReuse, events[0].Type, fixture.Name)
+			assert.Equal(t, "session", events[0].SessionID, fixture.Name)
+		}
+	}
+}
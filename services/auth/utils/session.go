@@ -0,0 +1,219 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+)
+
+type sessions_bool_exp map[string]interface{}
+type sessions_insert_input map[string]interface{}
+type sessions_set_input map[string]interface{}
+type sessions_pk_columns_input map[string]interface{}
+
+// the last activity of sessions is updated at most once per interval
+const sessionTouchInterval = time.Minute
+
+var (
+	errSessionRevoked = errors.New("session_revoked")
+)
+
+// SessionInfo describes the device which the session is created from
+type SessionInfo struct {
+	UserAgent string
+	IP        string
+}
+
+// createSession insert a new session record of the account
+func (ja *JWTAuth) createSession(ctx context.Context, accountID string, info SessionInfo) (string, error) {
+	var mutation struct {
+		InsertSession struct {
+			ID string `graphql:"id"`
+		} `graphql:"insert_sessions_one(object: $object)"`
+	}
+
+	variables := map[string]interface{}{
+		"object": sessions_insert_input{
+			"account_id": accountID,
+			"user_agent": info.UserAgent,
+			"ip":         info.IP,
+		},
+	}
+
+	err := ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("CreateSession"))
+	if err != nil {
+		return "", err
+	}
+
+	return mutation.InsertSession.ID, nil
+}
+
+// checkSession verify that the session belongs to an active account and isn't revoked,
+// then record the activity time. Impersonation sessions must be used by the impersonator of the token
+func (ja *JWTAuth) checkSession(ctx context.Context, accountID string, sessionID string, impersonatorID string) error {
+	if sessionID == "" {
+		return errSessionRevoked
+	}
+
+	var query struct {
+		Session *struct {
+			ID             string     `graphql:"id"`
+			AccountID      string     `graphql:"account_id"`
+			ImpersonatorID *string    `graphql:"impersonator_id"`
+			LastSeenAt     time.Time  `graphql:"last_seen_at"`
+			RevokedAt      *time.Time `graphql:"revoked_at"`
+			Account        struct {
+				Status string `graphql:"status"`
+			} `graphql:"account"`
+		} `graphql:"sessions_by_pk(id: $id)"`
+	}
+
+	variables := map[string]interface{}{
+		"id": graphql.String(sessionID),
+	}
+
+	err := ja.controller.Query(ctx, &query, variables, graphql.OperationName("GetSessionById"))
+	if err != nil {
+		return err
+	}
+
+	session := query.Session
+	if session == nil || session.AccountID != accountID || session.RevokedAt != nil {
+		return errSessionRevoked
+	}
+
+	sessionImpersonatorID := ""
+	if session.ImpersonatorID != nil {
+		sessionImpersonatorID = *session.ImpersonatorID
+	}
+	if sessionImpersonatorID != impersonatorID {
+		return errSessionRevoked
+	}
+
+	if err := CheckAccountStatus(session.Account.Status); err != nil {
+		return err
+	}
+
+	if time.Since(session.LastSeenAt) > sessionTouchInterval {
+		return ja.touchSession(ctx, sessionID)
+	}
+
+	return nil
+}
+
+func (ja *JWTAuth) touchSession(ctx context.Context, sessionID string) error {
+	var mutation struct {
+		UpdateSession struct {
+			ID string `graphql:"id"`
+		} `graphql:"update_sessions_by_pk(pk_columns: $pk_columns, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"pk_columns": sessions_pk_columns_input{
+			"id": sessionID,
+		},
+		"set": sessions_set_input{
+			"last_seen_at": time.Now(),
+		},
+	}
+
+	return ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("TouchSession"))
+}
+
+// RevokeSession revoke a single session of the account
+func (ja *JWTAuth) RevokeSession(ctx context.Context, accountID string, sessionID string) (int, error) {
+	count, err := ja.revokeSessions(ctx, sessions_bool_exp{
+		"id": map[string]interface{}{
+			"_eq": sessionID,
+		},
+		"account_id": map[string]interface{}{
+			"_eq": accountID,
+		},
+	})
+	// evict cached tokens after the revocation is stored so they can't be cached again
+	ja.invalidateVerifiedTokens(accountID, sessionID, "")
+
+	return count, err
+}
+
+// RevokeAccountSessions revoke all sessions of the account.
+// The session of exceptSessionID is kept alive if set
+func (ja *JWTAuth) RevokeAccountSessions(ctx context.Context, accountID string, exceptSessionID string) (int, error) {
+	where := sessions_bool_exp{
+		"account_id": map[string]interface{}{
+			"_eq": accountID,
+		},
+	}
+	if exceptSessionID != "" {
+		where["id"] = map[string]interface{}{
+			"_neq": exceptSessionID,
+		}
+	}
+	count, err := ja.revokeSessions(ctx, where)
+	ja.invalidateVerifiedTokens(accountID, "", exceptSessionID)
+
+	return count, err
+}
+
+func (ja *JWTAuth) revokeSessions(ctx context.Context, where sessions_bool_exp) (int, error) {
+	where["revoked_at"] = map[string]interface{}{
+		"_is_null": true,
+	}
+
+	var mutation struct {
+		UpdateSessions struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"update_sessions(where: $where, _set: $set)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": where,
+		"set": sessions_set_input{
+			"revoked_at": time.Now(),
+		},
+	}
+
+	err := ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("RevokeSessions"))
+	if err != nil {
+		return 0, err
+	}
+
+	return mutation.UpdateSessions.AffectedRows, nil
+}
+
+// ActiveSession is a session of the account which isn't revoked
+type ActiveSession struct {
+	ID             string    `graphql:"id"`
+	UserAgent      *string   `graphql:"user_agent"`
+	IP             *string   `graphql:"ip"`
+	ImpersonatorID *string   `graphql:"impersonator_id"`
+	CreatedAt      time.Time `graphql:"created_at"`
+	LastSeenAt     time.Time `graphql:"last_seen_at"`
+}
+
+// GetActiveSessions return sessions of the account which aren't revoked, the most recently used first
+func (ja *JWTAuth) GetActiveSessions(ctx context.Context, accountID string) ([]ActiveSession, error) {
+	var query struct {
+		Sessions []ActiveSession `graphql:"sessions(where: $where, order_by: {last_seen_at: desc})"`
+	}
+
+	variables := map[string]interface{}{
+		"where": sessions_bool_exp{
+			"account_id": map[string]interface{}{
+				"_eq": accountID,
+			},
+			"revoked_at": map[string]interface{}{
+				"_is_null": true,
+			},
+		},
+	}
+
+	err := ja.controller.Query(ctx, &query, variables, graphql.OperationName("GetActiveSessions"))
+	if err != nil {
+		return nil, err
+	}
+
+	return query.Sessions, nil
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckSession(t *testing.T) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	session := func(fields string) string {
+		return `{"sessions_by_pk":{"id":"session","account_id":"account","last_seen_at":"` + now + `"` + fields + `}}`
+	}
+
+	for _, fixture := range []struct {
+		Name           string
+		Response       string
+		ImpersonatorID string
+		Error          error
+	}{
+		{"active session", session(`,"account":{"status":"active"}`), "", nil},
+		{"unknown session", `{"sessions_by_pk":null}`, "", errSessionRevoked},
+		{"revoked session", session(`,"revoked_at":"` + now + `","account":{"status":"active"}`), "", errSessionRevoked},
+		{"session of another account", `{"sessions_by_pk":{"id":"session","account_id":"other","last_seen_at":"` + now + `","account":{"status":"active"}}}`, "", errSessionRevoked},
+		{"impersonation token of a normal session", session(`,"account":{"status":"active"}`), "admin", errSessionRevoked},
+		{"impersonation session", session(`,"impersonator_id":"admin","account":{"status":"active"}`), "admin", nil},
+		{"suspended account", session(`,"account":{"status":"suspended"}`), "", errAccountSuspended},
+	} {
+		_, controller := newFakeController(t, map[string]string{
+			"GetSessionById": fixture.Response,
+		})
+		ja := newTestJWTAuth(t, controller, JWTAuthConfig{})
+
+		err := ja.checkSession(context.Background(), "account", "session", fixture.ImpersonatorID)
+		assert.Equal(t, fixture.Error, err, fixture.Name)
+	}
+}
+
+func TestCheckSessionWithoutID(t *testing.T) {
+	fake, controller := newFakeController(t, map[string]string{})
+	ja := newTestJWTAuth(t, controller, JWTAuthConfig{})
+
+	// tokens issued before sessions were stored don't have a session id
+	assert.Equal(t, errSessionRevoked, ja.checkSession(context.Background(), "account", "", ""))
+	assert.Empty(t, fake.variables("GetSessionById"))
+}
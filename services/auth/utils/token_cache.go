@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"time"
+
+	"nexlab.tech/core/pkg/cache"
+)
+
+// VerifiedToken is the cached result of an access token verified by the webhook
+type VerifiedToken struct {
+	AccountID string
+	SessionID string
+	// ImpersonatorID is the admin of impersonation tokens
+	ImpersonatorID string
+	Variables      map[string]string
+	ExpiresAt      time.Time
+}
+
+// MaxAge return how long the verified result can be cached by clients,
+// which is bounded by the token expiry
+func (vt *VerifiedToken) MaxAge(maxAge time.Duration) time.Duration {
+	remaining := time.Until(vt.ExpiresAt)
+	if remaining < maxAge {
+		return remaining
+	}
+
+	return maxAge
+}
+
+// GetVerifiedToken return the cached session variables of the token.
+// The token signature must be verified by ParseToken first so that forged tokens can't reuse a cached jti
+func (ja *JWTAuth) GetVerifiedToken(token *jwtPayload) (*VerifiedToken, bool) {
+	if ja.verifyCache == nil || token.JwtID == "" {
+		return nil, false
+	}
+
+	value, ok := ja.verifyCache.Get(token.JwtID)
+	if !ok {
+		return nil, false
+	}
+
+	result := value.(*VerifiedToken)
+	if result.AccountID != token.Subject || result.SessionID != token.SessionID ||
+		result.ImpersonatorID != token.ImpersonatorID() || !result.ExpiresAt.After(time.Now()) {
+		ja.verifyCache.Delete(token.JwtID)
+		return nil, false
+	}
+
+	return result, true
+}
+
+// CacheVerifiedToken store the session variables of the verified token until the cache TTL or token expiry
+func (ja *JWTAuth) CacheVerifiedToken(token *jwtPayload, variables map[string]string) *VerifiedToken {
+	result := &VerifiedToken{
+		AccountID:      token.Subject,
+		SessionID:      token.SessionID,
+		ImpersonatorID: token.ImpersonatorID(),
+		Variables:      variables,
+		ExpiresAt:      time.Unix(token.ExpirationTime, 0),
+	}
+	if ja.verifyCache == nil || token.JwtID == "" {
+		return result
+	}
+
+	ttl := result.MaxAge(ja.config.VerifyCacheTTL)
+	if ttl > 0 {
+		ja.verifyCache.Set(token.JwtID, result, ttl)
+	}
+
+	return result
+}
+
+// invalidateVerifiedTokens evict cached tokens of the account, including tokens which the account impersonates.
+// All sessions of the account are evicted if sessionID is empty, except the session of exceptSessionID
+func (ja *JWTAuth) invalidateVerifiedTokens(accountID string, sessionID string, exceptSessionID string) {
+	if ja.verifyCache == nil {
+		return
+	}
+
+	ja.verifyCache.DeleteFunc(func(key string, value interface{}) bool {
+		item := value.(*VerifiedToken)
+		if item.AccountID != accountID && item.ImpersonatorID != accountID {
+			return false
+		}
+		if sessionID != "" {
+			return item.SessionID == sessionID
+		}
+
+		return exceptSessionID == "" || item.SessionID != exceptSessionID
+	})
+}
+
+// newVerifyCache construct the cache of verified tokens. It is disabled if the TTL or size isn't positive
+func (jac JWTAuthConfig) newVerifyCache() *cache.LRU {
+	if jac.VerifyCacheTTL <= 0 || jac.VerifyCacheSize <= 0 {
+		return nil
+	}
+
+	return cache.NewLRU(jac.VerifyCacheSize, jac.VerifyCacheTTL)
+}
@@ -0,0 +1,490 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+	"nexlab.tech/core/services/auth/webauthn"
+)
+
+type webauthn_credentials_bool_exp map[string]interface{}
+type webauthn_credentials_insert_input map[string]interface{}
+type webauthn_credentials_set_input map[string]interface{}
+
+// audiences of WebAuthn ceremony tokens which carry the signed challenge
+const (
+	AudienceWebAuthnRegister = "webauthn_register"
+	AudienceWebAuthnLogin    = "webauthn_login"
+)
+
+// security event types
+const (
+	SecurityEventWebAuthnSignCount = "webauthn_sign_count"
+)
+
+var (
+	errWebAuthnDisabled          = errors.New("webauthn_disabled")
+	errInvalidWebAuthnChallenge  = errors.New("invalid_webauthn_challenge")
+	errInvalidWebAuthnCredential = errors.New("invalid_webauthn_credential")
+	errWebAuthnNotRegistered     = errors.New("webauthn_not_registered")
+)
+
+// WebAuthnConfig holds the relying party of passkeys. WebAuthn is disabled if RPID is empty
+type WebAuthnConfig struct {
+	// RPID is the domain which passkeys are scoped to, e.g. example.com
+	RPID   string `envconfig:"WEBAUTHN_RP_ID"`
+	RPName string `envconfig:"WEBAUTHN_RP_NAME" default:"Nexlab"`
+	// Origins are the web origins of the apps which run the ceremonies, e.g. https://app.example.com
+	Origins []string `envconfig:"WEBAUTHN_ORIGINS"`
+	// ChallengeTTL is how long the client can take to complete a ceremony
+	ChallengeTTL time.Duration `envconfig:"WEBAUTHN_CHALLENGE_TTL" default:"5m"`
+}
+
+func (wc WebAuthnConfig) newRelyingParty() (*webauthn.RelyingParty, error) {
+	if wc.RPID == "" {
+		return nil, nil
+	}
+
+	return webauthn.New(webauthn.Config{
+		RPID:    wc.RPID,
+		RPName:  wc.RPName,
+		Origins: wc.Origins,
+	})
+}
+
+// WebAuthnChallenge is a started ceremony. The client passes Options to the browser API
+// and sends the token back with the authenticator response
+type WebAuthnChallenge struct {
+	Token     string
+	Options   interface{}
+	ExpiresAt time.Time
+}
+
+// WebAuthnCredential is a passkey registered to an account
+type WebAuthnCredential struct {
+	ID             string     `graphql:"id" json:"id"`
+	Name           string     `graphql:"name" json:"name"`
+	BackupEligible bool       `graphql:"backup_eligible" json:"backup_eligible"`
+	SecondFactor   bool       `graphql:"second_factor" json:"second_factor"`
+	CreatedAt      time.Time  `graphql:"created_at" json:"created_at"`
+	LastUsedAt     *time.Time `graphql:"last_used_at" json:"last_used_at"`
+}
+
+type storedWebAuthnCredential struct {
+	ID           string `graphql:"id"`
+	AccountID    string `graphql:"account_id"`
+	CredentialID string `graphql:"credential_id"`
+	PublicKey    string `graphql:"public_key"`
+	SignCount    int64  `graphql:"sign_count"`
+	SecondFactor bool   `graphql:"second_factor"`
+}
+
+// WebAuthnEnabled tells if the relying party is configured
+func (ja *JWTAuth) WebAuthnEnabled() bool {
+	return ja.webauthn != nil
+}
+
+// BeginWebAuthnRegistration create the options of a new passkey of the account.
+// Registered passkeys are excluded so that an authenticator isn't registered twice
+func (ja *JWTAuth) BeginWebAuthnRegistration(ctx context.Context, accountID string, name string, displayName string) (*WebAuthnChallenge, error) {
+	if ja.webauthn == nil {
+		return nil, errWebAuthnDisabled
+	}
+
+	exclude, err := ja.getWebAuthnCredentialIDs(ctx, accountID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := ja.config.WebAuthn.ChallengeTTL
+	token, expiresAt, err := ja.EncodePurposeToken(AudienceWebAuthnRegister, accountID, webauthn.EncodeBytes(challenge), ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	// the user handle is the account id so that discoverable passkeys identify the account
+	options := ja.webauthn.CreationOptions(challenge, webauthn.User{
+		ID:          []byte(accountID),
+		Name:        name,
+		DisplayName: displayName,
+	}, exclude, ttl)
+
+	return &WebAuthnChallenge{
+		Token:     token,
+		Options:   options,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// FinishWebAuthnRegistration verify the attestation of the registration challenge and store the passkey.
+// secondFactor opts the passkey in as the second factor of password logins
+func (ja *JWTAuth) FinishWebAuthnRegistration(ctx context.Context, accountID string, token string, name string, secondFactor bool, response webauthn.AttestationResponse) (*WebAuthnCredential, error) {
+	if ja.webauthn == nil {
+		return nil, errWebAuthnDisabled
+	}
+
+	challenge, err := ja.consumeWebAuthnChallenge(ctx, AudienceWebAuthnRegister, token, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := ja.webauthn.VerifyRegistration(challenge, response, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var mutation struct {
+		InsertWebAuthnCredential WebAuthnCredential `graphql:"insert_webauthn_credentials_one(object: $object)"`
+	}
+
+	variables := map[string]interface{}{
+		"object": webauthn_credentials_insert_input{
+			"account_id":      accountID,
+			"credential_id":   webauthn.EncodeBytes(credential.ID),
+			"public_key":      webauthn.EncodeBytes(credential.PublicKey),
+			"sign_count":      credential.SignCount,
+			"aaguid":          webauthn.EncodeBytes(credential.AAGUID),
+			"name":            name,
+			"backup_eligible": credential.BackupEligible,
+			"second_factor":   secondFactor,
+		},
+	}
+
+	err = ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("InsertWebAuthnCredential"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &mutation.InsertWebAuthnCredential, nil
+}
+
+// BeginWebAuthnLogin create the options of an assertion.
+// Without the account id, the user picks a discoverable passkey of any account
+func (ja *JWTAuth) BeginWebAuthnLogin(ctx context.Context, accountID string) (*WebAuthnChallenge, error) {
+	if ja.webauthn == nil {
+		return nil, errWebAuthnDisabled
+	}
+
+	var allow [][]byte
+	userVerification := webauthn.UserVerificationRequired
+	if accountID != "" {
+		var err error
+		allow, err = ja.getWebAuthnCredentialIDs(ctx, accountID, true)
+		if err != nil {
+			return nil, err
+		}
+		if len(allow) == 0 {
+			return nil, errWebAuthnNotRegistered
+		}
+		// the password has been verified already when the passkey is the second factor
+		userVerification = webauthn.UserVerificationPreferred
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := ja.config.WebAuthn.ChallengeTTL
+	encodedChallenge := webauthn.EncodeBytes(challenge)
+	// the subject of purpose tokens is required, so the challenge is the subject and the account is the binding
+	token, expiresAt, err := ja.EncodePurposeToken(AudienceWebAuthnLogin, encodedChallenge, accountID, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebAuthnChallenge{
+		Token:     token,
+		Options:   ja.webauthn.RequestOptions(challenge, allow, userVerification, ttl),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// FinishWebAuthnLogin verify the assertion of the login challenge and return the account of the passkey.
+// accountID must be the account which the challenge was created for, or empty for discoverable logins
+// which require user verification because the passkey is the only factor.
+// With the account, the passkey is a second factor and must have opted in
+func (ja *JWTAuth) FinishWebAuthnLogin(ctx context.Context, token string, credentialID string, response webauthn.AssertionResponse, accountID string) (string, error) {
+	if ja.webauthn == nil {
+		return "", errWebAuthnDisabled
+	}
+
+	claims, err := ja.DecodePurposeToken(AudienceWebAuthnLogin, token)
+	if err != nil || claims.Binding != accountID {
+		return "", errInvalidWebAuthnChallenge
+	}
+	challenge, err := ja.useWebAuthnChallenge(ctx, claims, claims.Subject)
+	if err != nil {
+		return "", err
+	}
+
+	stored, err := ja.getWebAuthnCredential(ctx, credentialID)
+	if err != nil {
+		return "", err
+	}
+	if stored == nil || (accountID != "" && (stored.AccountID != accountID || !stored.SecondFactor)) {
+		return "", errInvalidWebAuthnCredential
+	}
+
+	requireUserVerification := accountID == ""
+	if requireUserVerification && string(response.UserHandle) != stored.AccountID {
+		return "", errInvalidWebAuthnCredential
+	}
+
+	publicKey, err := webauthn.DecodeBytes(stored.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	rawID, err := webauthn.DecodeBytes(stored.CredentialID)
+	if err != nil {
+		return "", err
+	}
+
+	signCount, err := ja.webauthn.VerifyAssertion(challenge, webauthn.Credential{
+		ID:        rawID,
+		PublicKey: publicKey,
+		SignCount: uint32(stored.SignCount),
+	}, response, requireUserVerification)
+	if errors.Is(err, webauthn.ErrSignCount) {
+		ja.emitSecurityEvent(SecurityEvent{
+			Type:      SecurityEventWebAuthnSignCount,
+			AccountID: stored.AccountID,
+			Metadata: map[string]interface{}{
+				"credential_id": stored.ID,
+				"sign_count":    stored.SignCount,
+			},
+		})
+		return "", err
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var mutation struct {
+		UpdateWebAuthnCredentials struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"update_webauthn_credentials(where: $where, _set: $set)"`
+	}
+
+	// the conditional update rejects concurrent assertions with the same counter
+	variables := map[string]interface{}{
+		"where": webauthn_credentials_bool_exp{
+			"id": map[string]interface{}{
+				"_eq": stored.ID,
+			},
+			"sign_count": map[string]interface{}{
+				"_eq": stored.SignCount,
+			},
+		},
+		"set": webauthn_credentials_set_input{
+			"sign_count":   signCount,
+			"last_used_at": time.Now(),
+		},
+	}
+
+	err = ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("UseWebAuthnCredential"))
+	if err != nil {
+		return "", err
+	}
+
+	if mutation.UpdateWebAuthnCredentials.AffectedRows == 0 {
+		return "", errInvalidWebAuthnCredential
+	}
+
+	return stored.AccountID, nil
+}
+
+// HasWebAuthnSecondFactor tells if the account opted in a passkey as the second factor
+func (ja *JWTAuth) HasWebAuthnSecondFactor(ctx context.Context, accountID string) (bool, error) {
+	if ja.webauthn == nil {
+		return false, nil
+	}
+
+	ids, err := ja.getWebAuthnCredentialIDs(ctx, accountID, true)
+	if err != nil {
+		return false, err
+	}
+
+	return len(ids) > 0, nil
+}
+
+// GetWebAuthnCredentials list the passkeys of the account
+func (ja *JWTAuth) GetWebAuthnCredentials(ctx context.Context, accountID string) ([]WebAuthnCredential, error) {
+	var query struct {
+		WebAuthnCredentials []WebAuthnCredential `graphql:"webauthn_credentials(where: $where, order_by: { created_at: asc })"`
+	}
+
+	variables := map[string]interface{}{
+		"where": webauthn_credentials_bool_exp{
+			"account_id": map[string]interface{}{
+				"_eq": accountID,
+			},
+		},
+	}
+
+	err := ja.controller.Query(ctx, &query, variables, graphql.OperationName("GetWebAuthnCredentials"))
+	if err != nil {
+		return nil, err
+	}
+
+	return query.WebAuthnCredentials, nil
+}
+
+// DeleteWebAuthnCredential remove the passkey of the account
+func (ja *JWTAuth) DeleteWebAuthnCredential(ctx context.Context, accountID string, id string) (bool, error) {
+	var mutation struct {
+		DeleteWebAuthnCredentials struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"delete_webauthn_credentials(where: $where)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": webauthn_credentials_bool_exp{
+			"id": map[string]interface{}{
+				"_eq": id,
+			},
+			"account_id": map[string]interface{}{
+				"_eq": accountID,
+			},
+		},
+	}
+
+	err := ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("DeleteWebAuthnCredential"))
+	if err != nil {
+		return false, err
+	}
+
+	return mutation.DeleteWebAuthnCredentials.AffectedRows > 0, nil
+}
+
+// ResetWebAuthnCredentials remove all passkeys of the account and return the number of removed ones
+func (ja *JWTAuth) ResetWebAuthnCredentials(ctx context.Context, accountID string) (int, error) {
+	var mutation struct {
+		DeleteWebAuthnCredentials struct {
+			AffectedRows int `graphql:"affected_rows"`
+		} `graphql:"delete_webauthn_credentials(where: $where)"`
+	}
+
+	variables := map[string]interface{}{
+		"where": webauthn_credentials_bool_exp{
+			"account_id": map[string]interface{}{
+				"_eq": accountID,
+			},
+		},
+	}
+
+	err := ja.controller.Mutate(ctx, &mutation, variables, graphql.OperationName("ResetWebAuthnCredentials"))
+	if err != nil {
+		return 0, err
+	}
+
+	return mutation.DeleteWebAuthnCredentials.AffectedRows, nil
+}
+
+// consumeWebAuthnChallenge decode the ceremony token of the subject and return its challenge
+func (ja *JWTAuth) consumeWebAuthnChallenge(ctx context.Context, audience string, token string, subject string) ([]byte, error) {
+	claims, err := ja.DecodePurposeToken(audience, token)
+	if err != nil || claims.Subject != subject {
+		return nil, errInvalidWebAuthnChallenge
+	}
+
+	return ja.useWebAuthnChallenge(ctx, claims, claims.Binding)
+}
+
+// useWebAuthnChallenge claim the ceremony token in the database so that its challenge can't be replayed,
+// even on another instance. The sign count doesn't stop replays of synced passkeys which always report zero
+func (ja *JWTAuth) useWebAuthnChallenge(ctx context.Context, claims *jwtPayload, encodedChallenge string) ([]byte, error) {
+	err := ja.claimPurposeToken(ctx, claims)
+	if errors.Is(err, errTokenUsed) {
+		return nil, errInvalidWebAuthnChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := webauthn.DecodeBytes(encodedChallenge)
+	if err != nil {
+		return nil, errInvalidWebAuthnChallenge
+	}
+
+	return challenge, nil
+}
+
+// getWebAuthnCredentialIDs return the credential ids of the passkeys of the account,
+// only the ones which opted in as the second factor if secondFactor is true
+func (ja *JWTAuth) getWebAuthnCredentialIDs(ctx context.Context, accountID string, secondFactor bool) ([][]byte, error) {
+	var query struct {
+		WebAuthnCredentials []struct {
+			CredentialID string `graphql:"credential_id"`
+		} `graphql:"webauthn_credentials(where: $where)"`
+	}
+
+	where := webauthn_credentials_bool_exp{
+		"account_id": map[string]interface{}{
+			"_eq": accountID,
+		},
+	}
+	if secondFactor {
+		where["second_factor"] = map[string]interface{}{
+			"_eq": true,
+		}
+	}
+
+	variables := map[string]interface{}{
+		"where": where,
+	}
+
+	err := ja.controller.Query(ctx, &query, variables, graphql.OperationName("GetWebAuthnCredentialIDs"))
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([][]byte, 0, len(query.WebAuthnCredentials))
+	for _, credential := range query.WebAuthnCredentials {
+		id, err := webauthn.DecodeBytes(credential.CredentialID)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, id)
+	}
+
+	return results, nil
+}
+
+func (ja *JWTAuth) getWebAuthnCredential(ctx context.Context, credentialID string) (*storedWebAuthnCredential, error) {
+	rawID, err := webauthn.DecodeBytes(credentialID)
+	if err != nil {
+		return nil, errInvalidWebAuthnCredential
+	}
+
+	var query struct {
+		WebAuthnCredentials []storedWebAuthnCredential `graphql:"webauthn_credentials(where: $where, limit: 1)"`
+	}
+
+	// ids are stored without padding
+	variables := map[string]interface{}{
+		"where": webauthn_credentials_bool_exp{
+			"credential_id": map[string]interface{}{
+				"_eq": webauthn.EncodeBytes(rawID),
+			},
+		},
+	}
+
+	err = ja.controller.Query(ctx, &query, variables, graphql.OperationName("GetWebAuthnCredential"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(query.WebAuthnCredentials) == 0 {
+		return nil, nil
+	}
+
+	return &query.WebAuthnCredentials[0], nil
+}
@@ -0,0 +1,477 @@
+// Package webauthn implements the relying party of Web Authentication (W3C WebAuthn level 2)
+// for passkeys and security keys. It supports the "none" attestation and ES256 credentials
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// client data types of the ceremonies
+const (
+	ceremonyCreate = "webauthn.create"
+	ceremonyGet    = "webauthn.get"
+)
+
+// user verification requirements
+const (
+	UserVerificationRequired  = "required"
+	UserVerificationPreferred = "preferred"
+)
+
+// AlgorithmES256 is the COSE identifier of ECDSA with P-256 and SHA-256
+const AlgorithmES256 = -7
+
+const challengeSize = 32
+
+// authenticator data flags
+const (
+	flagUserPresent           = 0x01
+	flagUserVerified          = 0x04
+	flagBackupEligible        = 0x08
+	flagAttestedCredential    = 0x40
+	flagExtensionData         = 0x80
+	authenticatorDataMinSize  = 37
+	attestedCredentialMinSize = 18
+)
+
+// COSE key parameters (RFC 8152)
+const (
+	coseKeyType      = 1
+	coseAlgorithm    = 3
+	coseCurve        = -1
+	coseX            = -2
+	coseY            = -3
+	coseKeyTypeEC2   = 2
+	coseCurveP256    = 1
+	p256CoordinateSz = 32
+)
+
+var (
+	errChallengeMismatch = errors.New("webauthn_challenge_mismatch")
+	errOriginNotAllowed  = errors.New("webauthn_origin_not_allowed")
+	errRPIDMismatch      = errors.New("webauthn_rp_id_mismatch")
+	errUserNotPresent    = errors.New("webauthn_user_not_present")
+	errUserNotVerified   = errors.New("webauthn_user_not_verified")
+	errInvalidSignature  = errors.New("webauthn_invalid_signature")
+	// ErrSignCount is returned when the signature counter doesn't increase, which indicates a cloned authenticator
+	ErrSignCount = errors.New("webauthn_sign_count_regressed")
+)
+
+var encoding = base64.RawURLEncoding
+
+// Config holds the relying party identity
+type Config struct {
+	// RPID is the domain which credentials are scoped to, e.g. example.com
+	RPID string
+	// RPName is the name displayed by authenticators
+	RPName string
+	// Origins are the web origins which can run the ceremonies, e.g. https://app.example.com
+	Origins []string
+}
+
+// RelyingParty verifies registration and authentication ceremonies
+type RelyingParty struct {
+	config   Config
+	rpIDHash [32]byte
+}
+
+// New create the relying party of the config
+func New(config Config) (*RelyingParty, error) {
+	if config.RPID == "" {
+		return nil, errors.New("webauthn relying party id is required")
+	}
+	if len(config.Origins) == 0 {
+		return nil, errors.New("webauthn origins are required")
+	}
+	if config.RPName == "" {
+		config.RPName = config.RPID
+	}
+
+	return &RelyingParty{
+		config:   config,
+		rpIDHash: sha256.Sum256([]byte(config.RPID)),
+	}, nil
+}
+
+// NewChallenge create a random challenge of a ceremony
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, challengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+// EncodeBytes encode binary values of options and responses as base64url without padding
+func EncodeBytes(value []byte) string {
+	return encoding.EncodeToString(value)
+}
+
+// DecodeBytes decode base64url values of client responses. Padded values are accepted too
+func DecodeBytes(value string) ([]byte, error) {
+	return encoding.DecodeString(trimPadding(value))
+}
+
+func trimPadding(value string) string {
+	for len(value) > 0 && value[len(value)-1] == '=' {
+		value = value[:len(value)-1]
+	}
+	return value
+}
+
+// User is the account which a credential is registered for
+type User struct {
+	ID          []byte
+	Name        string
+	DisplayName string
+}
+
+// CredentialDescriptor refers to a registered credential in options
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// CreationOptions is the publicKey argument of navigator.credentials.create with binary values in base64url
+type CreationOptions struct {
+	Challenge string `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	PubKeyCredParams []struct {
+		Type string `json:"type"`
+		Alg  int    `json:"alg"`
+	} `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout,omitempty"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// RequestOptions is the publicKey argument of navigator.credentials.get with binary values in base64url
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int                    `json:"timeout,omitempty"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// CreationOptions build the registration options. Discoverable credentials are preferred
+// so that the passkey can sign in without the email
+func (rp *RelyingParty) CreationOptions(challenge []byte, user User, exclude [][]byte, timeout time.Duration) CreationOptions {
+	var options CreationOptions
+	options.Challenge = EncodeBytes(challenge)
+	options.RP.ID = rp.config.RPID
+	options.RP.Name = rp.config.RPName
+	options.User.ID = EncodeBytes(user.ID)
+	options.User.Name = user.Name
+	options.User.DisplayName = user.DisplayName
+	options.PubKeyCredParams = append(options.PubKeyCredParams, struct {
+		Type string `json:"type"`
+		Alg  int    `json:"alg"`
+	}{"public-key", AlgorithmES256})
+	options.Timeout = int(timeout / time.Millisecond)
+	options.ExcludeCredentials = credentialDescriptors(exclude)
+	options.AuthenticatorSelection.ResidentKey = "preferred"
+	options.AuthenticatorSelection.UserVerification = UserVerificationPreferred
+	options.Attestation = "none"
+
+	return options
+}
+
+// RequestOptions build the authentication options. An empty allow list lets the user pick a discoverable credential
+func (rp *RelyingParty) RequestOptions(challenge []byte, allow [][]byte, userVerification string, timeout time.Duration) RequestOptions {
+	return RequestOptions{
+		Challenge:        EncodeBytes(challenge),
+		Timeout:          int(timeout / time.Millisecond),
+		RPID:             rp.config.RPID,
+		AllowCredentials: credentialDescriptors(allow),
+		UserVerification: userVerification,
+	}
+}
+
+func credentialDescriptors(ids [][]byte) []CredentialDescriptor {
+	results := make([]CredentialDescriptor, len(ids))
+	for i, id := range ids {
+		results[i] = CredentialDescriptor{
+			Type: "public-key",
+			ID:   EncodeBytes(id),
+		}
+	}
+	return results
+}
+
+// Credential is a verified public key credential
+type Credential struct {
+	ID []byte
+	// PublicKey is the COSE encoded public key
+	PublicKey      []byte
+	SignCount      uint32
+	AAGUID         []byte
+	UserVerified   bool
+	BackupEligible bool
+}
+
+// AttestationResponse is the response of navigator.credentials.create
+type AttestationResponse struct {
+	ClientDataJSON    []byte
+	AttestationObject []byte
+}
+
+// AssertionResponse is the response of navigator.credentials.get
+type AssertionResponse struct {
+	ClientDataJSON    []byte
+	AuthenticatorData []byte
+	Signature         []byte
+	UserHandle        []byte
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+type authenticatorData struct {
+	RPIDHash   []byte
+	Flags      byte
+	SignCount  uint32
+	Credential *Credential
+}
+
+// VerifyRegistration verify the attestation of a new credential for the challenge
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, response AttestationResponse, requireUserVerification bool) (*Credential, error) {
+	if err := rp.verifyClientData(response.ClientDataJSON, ceremonyCreate, challenge); err != nil {
+		return nil, err
+	}
+
+	value, _, err := decodeCBOR(response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+	attestation, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("invalid attestation object")
+	}
+
+	// attestation statements aren't verified, so only the none format is accepted
+	if format, _ := attestation["fmt"].(string); format != "none" {
+		return nil, fmt.Errorf("unsupported attestation format %q", format)
+	}
+	if statement, ok := attestation["attStmt"].(map[interface{}]interface{}); !ok || len(statement) != 0 {
+		return nil, errors.New("invalid attestation statement")
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("invalid authenticator data")
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(authData, requireUserVerification); err != nil {
+		return nil, err
+	}
+	if authData.Credential == nil {
+		return nil, errors.New("attested credential data is missing")
+	}
+
+	if _, err := parsePublicKey(authData.Credential.PublicKey); err != nil {
+		return nil, err
+	}
+
+	return authData.Credential, nil
+}
+
+// VerifyAssertion verify the signature of the registered credential for the challenge.
+// It returns the new signature counter which must be stored for the next assertion
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, credential Credential, response AssertionResponse, requireUserVerification bool) (uint32, error) {
+	if err := rp.verifyClientData(response.ClientDataJSON, ceremonyGet, challenge); err != nil {
+		return 0, err
+	}
+
+	authData, err := parseAuthenticatorData(response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	if err := rp.verifyAuthenticatorData(authData, requireUserVerification); err != nil {
+		return 0, err
+	}
+
+	publicKey, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(response.ClientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, response.AuthenticatorData...), clientDataHash[:]...))
+	if !ecdsa.VerifyASN1(publicKey, digest[:], response.Signature) {
+		return 0, errInvalidSignature
+	}
+
+	// authenticators without a counter always return zero, e.g. synced passkeys
+	if (authData.SignCount != 0 || credential.SignCount != 0) && authData.SignCount <= credential.SignCount {
+		return 0, ErrSignCount
+	}
+
+	return authData.SignCount, nil
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return fmt.Errorf("invalid client data: %s", err)
+	}
+
+	if data.Type != ceremony {
+		return fmt.Errorf("invalid client data type %q", data.Type)
+	}
+
+	received, err := DecodeBytes(data.Challenge)
+	if err != nil || len(challenge) == 0 || subtle.ConstantTimeCompare(received, challenge) != 1 {
+		return errChallengeMismatch
+	}
+
+	if data.CrossOrigin {
+		return errOriginNotAllowed
+	}
+	for _, origin := range rp.config.Origins {
+		if data.Origin == origin {
+			return nil
+		}
+	}
+
+	return errOriginNotAllowed
+}
+
+func (rp *RelyingParty) verifyAuthenticatorData(authData *authenticatorData, requireUserVerification bool) error {
+	if subtle.ConstantTimeCompare(authData.RPIDHash, rp.rpIDHash[:]) != 1 {
+		return errRPIDMismatch
+	}
+	if authData.Flags&flagUserPresent == 0 {
+		return errUserNotPresent
+	}
+	if requireUserVerification && authData.Flags&flagUserVerified == 0 {
+		return errUserNotVerified
+	}
+
+	return nil
+}
+
+// parseAuthenticatorData decode the binary authenticator data:
+// rpIdHash (32) | flags (1) | signCount (4) | attested credential data | extensions
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < authenticatorDataMinSize {
+		return nil, errors.New("authenticator data is too short")
+	}
+
+	result := &authenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[authenticatorDataMinSize:]
+
+	if result.Flags&flagAttestedCredential != 0 {
+		// aaguid (16) | credentialIdLength (2) | credentialId | credentialPublicKey
+		if len(rest) < attestedCredentialMinSize {
+			return nil, errors.New("attested credential data is too short")
+		}
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		if len(rest) < attestedCredentialMinSize+idLength {
+			return nil, errors.New("credential id is truncated")
+		}
+		credential := &Credential{
+			AAGUID:         append([]byte{}, rest[:16]...),
+			ID:             append([]byte{}, rest[18:18+idLength]...),
+			SignCount:      result.SignCount,
+			UserVerified:   result.Flags&flagUserVerified != 0,
+			BackupEligible: result.Flags&flagBackupEligible != 0,
+		}
+		keyData := rest[18+idLength:]
+		_, remaining, err := decodeCBOR(keyData)
+		if err != nil {
+			return nil, err
+		}
+		credential.PublicKey = append([]byte{}, keyData[:len(keyData)-len(remaining)]...)
+		result.Credential = credential
+		rest = remaining
+	}
+
+	if result.Flags&flagExtensionData != 0 {
+		_, remaining, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, err
+		}
+		rest = remaining
+	}
+
+	if len(rest) > 0 {
+		return nil, errors.New("authenticator data has trailing bytes")
+	}
+
+	return result, nil
+}
+
+// parsePublicKey decode the COSE key of an ES256 credential
+func parsePublicKey(data []byte) (*ecdsa.PublicKey, error) {
+	value, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := value.(map[interface{}]interface{})
+	if !ok || len(rest) > 0 {
+		return nil, errors.New("invalid credential public key")
+	}
+
+	if keyType, _ := key[int64(coseKeyType)].(int64); keyType != coseKeyTypeEC2 {
+		return nil, errors.New("unsupported credential key type")
+	}
+	if algorithm, _ := key[int64(coseAlgorithm)].(int64); algorithm != AlgorithmES256 {
+		return nil, fmt.Errorf("unsupported credential algorithm %d", algorithm)
+	}
+	if curve, _ := key[int64(coseCurve)].(int64); curve != coseCurveP256 {
+		return nil, errors.New("unsupported credential curve")
+	}
+
+	x, _ := key[int64(coseX)].([]byte)
+	y, _ := key[int64(coseY)].([]byte)
+	if len(x) != p256CoordinateSz || len(y) != p256CoordinateSz {
+		return nil, errors.New("invalid credential key coordinates")
+	}
+
+	publicKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+		return nil, errors.New("credential key isn't on the curve")
+	}
+
+	return publicKey, nil
+}
@@ -0,0 +1,321 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://app.example.com"
+)
+
+// cborPair keeps the order of encoded map entries
+type cborPair struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// encodeCBOR encode the subset of CBOR which authenticators produce
+func encodeCBOR(value interface{}) []byte {
+	header := func(major byte, arg uint64) []byte {
+		switch {
+		case arg < 24:
+			return []byte{major<<5 | byte(arg)}
+		case arg < 1<<8:
+			return []byte{major<<5 | 24, byte(arg)}
+		case arg < 1<<16:
+			buf := []byte{major<<5 | 25, 0, 0}
+			binary.BigEndian.PutUint16(buf[1:], uint16(arg))
+			return buf
+		default:
+			buf := []byte{major<<5 | 26, 0, 0, 0, 0}
+			binary.BigEndian.PutUint32(buf[1:], uint32(arg))
+			return buf
+		}
+	}
+
+	switch v := value.(type) {
+	case int:
+		if v < 0 {
+			return header(cborNegative, uint64(-1-v))
+		}
+		return header(cborUnsigned, uint64(v))
+	case []byte:
+		return append(header(cborBytes, uint64(len(v))), v...)
+	case string:
+		return append(header(cborText, uint64(len(v))), v...)
+	case bool:
+		if v {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	case []interface{}:
+		result := header(cborArray, uint64(len(v)))
+		for _, item := range v {
+			result = append(result, encodeCBOR(item)...)
+		}
+		return result
+	case []cborPair:
+		result := header(cborMap, uint64(len(v)))
+		for _, pair := range v {
+			result = append(result, encodeCBOR(pair.Key)...)
+			result = append(result, encodeCBOR(pair.Value)...)
+		}
+		return result
+	default:
+		panic("unsupported cbor value")
+	}
+}
+
+// softAuthenticator is a software authenticator which signs ceremonies like a platform passkey
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	rpID         string
+	origin       string
+	signCount    uint32
+	// noCounter makes the authenticator always return a zero counter like synced passkeys
+	noCounter bool
+	flags     byte
+	format    string
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{
+		key:          key,
+		credentialID: credentialID,
+		rpID:         testRPID,
+		origin:       testOrigin,
+		flags:        flagUserPresent | flagUserVerified,
+		format:       "none",
+	}
+}
+
+func (sa *softAuthenticator) clientData(ceremony string, challenge []byte) []byte {
+	data, _ := json.Marshal(clientData{
+		Type:      ceremony,
+		Challenge: EncodeBytes(challenge),
+		Origin:    sa.origin,
+	})
+	return data
+}
+
+func (sa *softAuthenticator) authenticatorData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(sa.rpID))
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:37], sa.signCount)
+	return append(data, attested...)
+}
+
+func (sa *softAuthenticator) publicKey() []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	sa.key.X.FillBytes(x)
+	sa.key.Y.FillBytes(y)
+	return encodeCBOR([]cborPair{
+		{coseKeyType, coseKeyTypeEC2},
+		{coseAlgorithm, AlgorithmES256},
+		{coseCurve, coseCurveP256},
+		{coseX, x},
+		{coseY, y},
+	})
+}
+
+func (sa *softAuthenticator) create(challenge []byte) AttestationResponse {
+	attested := make([]byte, 18)
+	binary.BigEndian.PutUint16(attested[16:], uint16(len(sa.credentialID)))
+	attested = append(attested, sa.credentialID...)
+	attested = append(attested, sa.publicKey()...)
+
+	return AttestationResponse{
+		ClientDataJSON: sa.clientData(ceremonyCreate, challenge),
+		AttestationObject: encodeCBOR([]cborPair{
+			{"fmt", sa.format},
+			{"attStmt", []cborPair{}},
+			{"authData", sa.authenticatorData(sa.flags|flagAttestedCredential, attested)},
+		}),
+	}
+}
+
+func (sa *softAuthenticator) get(challenge []byte) AssertionResponse {
+	if !sa.noCounter {
+		sa.signCount++
+	}
+	clientDataJSON := sa.clientData(ceremonyGet, challenge)
+	authData := sa.authenticatorData(sa.flags, nil)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, sa.key, digest[:])
+	if err != nil {
+		panic(err)
+	}
+
+	return AssertionResponse{
+		ClientDataJSON:    clientDataJSON,
+		AuthenticatorData: authData,
+		Signature:         signature,
+	}
+}
+
+func newTestRelyingParty(t *testing.T) *RelyingParty {
+	rp, err := New(Config{
+		RPID:    testRPID,
+		Origins: []string{testOrigin},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rp
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	authenticator := newSoftAuthenticator(t)
+
+	challenge, err := NewChallenge()
+	assert.Nil(t, err)
+	credential, err := rp.VerifyRegistration(challenge, authenticator.create(challenge), true)
+	assert.Nil(t, err)
+	assert.Equal(t, authenticator.credentialID, credential.ID)
+	assert.Equal(t, authenticator.publicKey(), credential.PublicKey)
+	assert.True(t, credential.UserVerified)
+
+	challenge, _ = NewChallenge()
+	signCount, err := rp.VerifyAssertion(challenge, *credential, authenticator.get(challenge), true)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), signCount)
+	credential.SignCount = signCount
+
+	// a cloned authenticator replays an old counter
+	clone := *authenticator
+	clone.signCount = 0
+	challenge, _ = NewChallenge()
+	_, err = rp.VerifyAssertion(challenge, *credential, clone.get(challenge), true)
+	assert.Equal(t, ErrSignCount, err)
+
+	challenge, _ = NewChallenge()
+	signCount, err = rp.VerifyAssertion(challenge, *credential, authenticator.get(challenge), true)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), signCount)
+}
+
+func TestAssertionWithoutCounter(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	authenticator := newSoftAuthenticator(t)
+	authenticator.noCounter = true
+
+	challenge, _ := NewChallenge()
+	credential, err := rp.VerifyRegistration(challenge, authenticator.create(challenge), false)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2; i++ {
+		signCount, err := rp.VerifyAssertion(challenge, *credential, authenticator.get(challenge), false)
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(0), signCount)
+	}
+}
+
+func TestRegistrationRejected(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	challenge, _ := NewChallenge()
+
+	authenticator := newSoftAuthenticator(t)
+	otherChallenge, _ := NewChallenge()
+	_, err := rp.VerifyRegistration(challenge, authenticator.create(otherChallenge), false)
+	assert.Equal(t, errChallengeMismatch, err)
+
+	authenticator = newSoftAuthenticator(t)
+	authenticator.origin = "https://evil.example.org"
+	_, err = rp.VerifyRegistration(challenge, authenticator.create(challenge), false)
+	assert.Equal(t, errOriginNotAllowed, err)
+
+	authenticator = newSoftAuthenticator(t)
+	authenticator.rpID = "evil.example.org"
+	_, err = rp.VerifyRegistration(challenge, authenticator.create(challenge), false)
+	assert.Equal(t, errRPIDMismatch, err)
+
+	authenticator = newSoftAuthenticator(t)
+	authenticator.flags = flagUserPresent
+	_, err = rp.VerifyRegistration(challenge, authenticator.create(challenge), true)
+	assert.Equal(t, errUserNotVerified, err)
+
+	authenticator = newSoftAuthenticator(t)
+	authenticator.flags = 0
+	_, err = rp.VerifyRegistration(challenge, authenticator.create(challenge), false)
+	assert.Equal(t, errUserNotPresent, err)
+
+	authenticator = newSoftAuthenticator(t)
+	authenticator.format = "packed"
+	_, err = rp.VerifyRegistration(challenge, authenticator.create(challenge), false)
+	assert.EqualError(t, err, `unsupported attestation format "packed"`)
+}
+
+func TestAssertionRejected(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	authenticator := newSoftAuthenticator(t)
+	challenge, _ := NewChallenge()
+	credential, err := rp.VerifyRegistration(challenge, authenticator.create(challenge), false)
+	assert.Nil(t, err)
+
+	response := authenticator.get(challenge)
+	response.Signature[len(response.Signature)-1] ^= 0xff
+	_, err = rp.VerifyAssertion(challenge, *credential, response, false)
+	assert.Equal(t, errInvalidSignature, err)
+
+	// the registration client data can't be replayed as an assertion
+	response = authenticator.get(challenge)
+	response.ClientDataJSON = authenticator.clientData(ceremonyCreate, challenge)
+	_, err = rp.VerifyAssertion(challenge, *credential, response, false)
+	assert.EqualError(t, err, `invalid client data type "webauthn.create"`)
+
+	other := newSoftAuthenticator(t)
+	_, err = rp.VerifyAssertion(challenge, *credential, other.get(challenge), false)
+	assert.Equal(t, errInvalidSignature, err)
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	value, rest, err := decodeCBOR(append(encodeCBOR([]cborPair{
+		{"a", []interface{}{1, -7, true, "text"}},
+		{-2, []byte{1, 2, 3}},
+	}), 0xff))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0xff}, rest)
+	assert.Equal(t, map[interface{}]interface{}{
+		"a":       []interface{}{int64(1), int64(-7), true, "text"},
+		int64(-2): []byte{1, 2, 3},
+	}, value)
+
+	_, _, err = decodeCBOR([]byte{0x5a, 0xff, 0xff, 0xff, 0xff})
+	assert.Equal(t, errCBORTruncated, err)
+
+	_, _, err = decodeCBOR([]byte{0x9f})
+	assert.EqualError(t, err, "cbor: unsupported additional information 31")
+
+	_, _, err = decodeCBOR(encodeCBOR([]cborPair{{1, 1}, {1, 2}}))
+	assert.EqualError(t, err, "cbor: duplicate map key")
+
+	nested := []byte{}
+	for i := 0; i < cborMaxDepth+2; i++ {
+		nested = append(nested, 0x81)
+	}
+	_, _, err = decodeCBOR(append(nested, 0x01))
+	assert.EqualError(t, err, "cbor: nesting too deep")
+}
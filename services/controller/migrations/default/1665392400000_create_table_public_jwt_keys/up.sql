@@ -0,0 +1,13 @@
+CREATE TABLE "public"."jwt_keys"
+(
+    "id"         text        NOT NULL,
+    "algorithm"  text        NOT NULL,
+    "secret"     text        NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT now(),
+    "retired_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+
+-- only one key signs tokens at a time
+CREATE UNIQUE INDEX jwt_keys_active_unique
+  ON "public"."jwt_keys"((retired_at IS NULL)) WHERE retired_at IS NULL;
@@ -0,0 +1,2 @@
+alter table "public"."account" add column "randomHash" text;
+DROP TABLE "public"."sessions";
@@ -0,0 +1,18 @@
+CREATE TABLE "public"."sessions"
+(
+    "id"           text        NOT NULL DEFAULT gen_random_uuid(),
+    "account_id"   text        NOT NULL,
+    "user_agent"   text,
+    "ip"           text,
+    "created_at"   timestamptz NOT NULL DEFAULT now(),
+    "last_seen_at" timestamptz NOT NULL DEFAULT now(),
+    "revoked_at"   timestamptz,
+    PRIMARY KEY ("id"),
+    FOREIGN KEY ("account_id") REFERENCES "public"."account" ("id") ON UPDATE restrict ON DELETE cascade
+);
+
+CREATE INDEX sessions_account_id_idx
+  ON "public"."sessions"("account_id") WHERE revoked_at IS NULL;
+
+-- sessions replace the account-wide random hash
+alter table "public"."account" drop column "randomHash";
@@ -0,0 +1,13 @@
+CREATE TABLE "public"."refresh_tokens"
+(
+    "id"         text        NOT NULL,
+    "session_id" text        NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT now(),
+    "expires_at" timestamptz NOT NULL,
+    "used_at"    timestamptz,
+    PRIMARY KEY ("id"),
+    FOREIGN KEY ("session_id") REFERENCES "public"."sessions" ("id") ON UPDATE restrict ON DELETE cascade
+);
+
+CREATE INDEX refresh_tokens_session_id_idx
+  ON "public"."refresh_tokens"("session_id");
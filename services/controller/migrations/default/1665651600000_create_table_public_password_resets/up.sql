@@ -0,0 +1,16 @@
+CREATE TABLE "public"."password_resets"
+(
+    "id"         text        NOT NULL DEFAULT gen_random_uuid(),
+    "account_id" text        NOT NULL,
+    "token_hash" text        NOT NULL,
+    "ip"         text,
+    "created_at" timestamptz NOT NULL DEFAULT now(),
+    "expires_at" timestamptz NOT NULL,
+    "used_at"    timestamptz,
+    PRIMARY KEY ("id"),
+    UNIQUE ("token_hash"),
+    FOREIGN KEY ("account_id") REFERENCES "public"."account" ("id") ON UPDATE restrict ON DELETE cascade
+);
+
+CREATE INDEX password_resets_account_id_idx
+  ON "public"."password_resets"("account_id");
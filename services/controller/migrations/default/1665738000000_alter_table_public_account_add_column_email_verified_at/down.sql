@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS account_reset_email_verification ON "public"."account";
+DROP FUNCTION IF EXISTS account_reset_email_verification();
+ALTER TABLE "public"."account" DROP COLUMN "email_verified_at";
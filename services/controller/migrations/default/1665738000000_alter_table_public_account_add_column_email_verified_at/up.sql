@@ -0,0 +1,19 @@
+ALTER TABLE "public"."account" ADD COLUMN "email_verified_at" timestamptz NULL;
+
+-- accounts created before email verification are trusted
+UPDATE "public"."account" SET "email_verified_at" = "created_at";
+
+-- changing the email requires verifying the new address
+CREATE OR REPLACE FUNCTION account_reset_email_verification()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF NEW."email" IS DISTINCT FROM OLD."email" THEN
+    NEW."email_verified_at" = NULL;
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER account_reset_email_verification
+  BEFORE UPDATE ON "public"."account"
+  FOR EACH ROW EXECUTE PROCEDURE account_reset_email_verification();
@@ -0,0 +1,6 @@
+DROP TABLE "public"."recovery_codes";
+
+ALTER TABLE "public"."account"
+  DROP COLUMN "totp_secret",
+  DROP COLUMN "totp_enabled_at",
+  DROP COLUMN "totp_last_counter";
@@ -0,0 +1,18 @@
+ALTER TABLE "public"."account"
+  ADD COLUMN "totp_secret"       text        NULL,
+  ADD COLUMN "totp_enabled_at"   timestamptz NULL,
+  ADD COLUMN "totp_last_counter" bigint      NULL;
+
+CREATE TABLE "public"."recovery_codes"
+(
+    "id"         text        NOT NULL DEFAULT gen_random_uuid(),
+    "account_id" text        NOT NULL,
+    "code_hash"  text        NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT now(),
+    "used_at"    timestamptz,
+    PRIMARY KEY ("id"),
+    FOREIGN KEY ("account_id") REFERENCES "public"."account" ("id") ON UPDATE restrict ON DELETE cascade
+);
+
+CREATE INDEX recovery_codes_account_id_idx
+  ON "public"."recovery_codes"("account_id");
@@ -0,0 +1,22 @@
+ALTER TABLE "public"."account"
+  ADD COLUMN "failed_login_count"   integer     NOT NULL DEFAULT 0,
+  ADD COLUMN "last_failed_login_at" timestamptz NULL,
+  ADD COLUMN "locked_until"         timestamptz NULL;
+
+CREATE TABLE "public"."login_attempts"
+(
+    "id"         text        NOT NULL DEFAULT gen_random_uuid(),
+    "account_id" text,
+    "email"      text,
+    "ip"         text,
+    "success"    boolean     NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY ("id"),
+    FOREIGN KEY ("account_id") REFERENCES "public"."account" ("id") ON UPDATE restrict ON DELETE cascade
+);
+
+CREATE INDEX login_attempts_ip_created_at_idx
+  ON "public"."login_attempts"("ip", "created_at");
+
+CREATE INDEX login_attempts_account_id_created_at_idx
+  ON "public"."login_attempts"("account_id", "created_at");
@@ -0,0 +1,9 @@
+DROP FUNCTION public.search_accounts(text);
+
+DROP INDEX "public"."account_status_idx";
+DROP INDEX "public"."account_full_name_trgm_idx";
+DROP INDEX "public"."account_email_trgm_idx";
+
+ALTER TABLE "public"."account"
+  DROP COLUMN "deleted_at",
+  ALTER COLUMN "status" DROP NOT NULL;
@@ -0,0 +1,25 @@
+UPDATE "public"."account" SET "status" = 'active' WHERE "status" IS NULL;
+
+ALTER TABLE "public"."account"
+  ALTER COLUMN "status" SET NOT NULL,
+  ADD COLUMN "deleted_at" timestamptz NULL;
+
+CREATE INDEX account_email_trgm_idx
+  ON "public"."account" USING gin (("email"::text) gin_trgm_ops);
+
+CREATE INDEX account_full_name_trgm_idx
+  ON "public"."account" USING gin ("fullName" gin_trgm_ops);
+
+CREATE INDEX account_status_idx
+  ON "public"."account"("status");
+
+-- search accounts by email or full name, the most similar first.
+-- Substrings match literally, and similar words match with the trigram operator
+CREATE OR REPLACE FUNCTION public.search_accounts(search text) RETURNS SETOF account LANGUAGE sql STABLE AS $function$
+SELECT *
+FROM account
+WHERE "email"::text ILIKE '%' || replace(replace(replace(search, '\', '\\'), '%', '\%'), '_', '\_') || '%'
+  OR "fullName" ILIKE '%' || replace(replace(replace(search, '\', '\\'), '%', '\%'), '_', '\_') || '%'
+  OR "email"::text % search
+  OR "fullName" % search
+ORDER BY greatest(similarity("email"::text, search), similarity(coalesce("fullName", ''), search)) DESC, "created_at" DESC $function$;
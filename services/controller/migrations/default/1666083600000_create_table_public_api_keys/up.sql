@@ -0,0 +1,20 @@
+-- API keys act with the role of the key, which is restricted by the role of the owner. There are no finer scopes
+CREATE TABLE "public"."api_keys"
+(
+    "id"           text        NOT NULL DEFAULT gen_random_uuid(),
+    "account_id"   text        NOT NULL,
+    "name"         text        NOT NULL,
+    "prefix"       text        NOT NULL,
+    "key_hash"     text        NOT NULL,
+    "role"         text        NOT NULL DEFAULT 'user',
+    "expires_at"   timestamptz,
+    "last_used_at" timestamptz,
+    "revoked_at"   timestamptz,
+    "created_at"   timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY ("id"),
+    FOREIGN KEY ("account_id") REFERENCES "public"."account" ("id") ON UPDATE restrict ON DELETE cascade,
+    UNIQUE ("prefix")
+);
+
+CREATE INDEX api_keys_account_id_idx
+  ON "public"."api_keys"("account_id");
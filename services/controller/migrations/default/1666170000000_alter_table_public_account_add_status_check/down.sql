@@ -0,0 +1,2 @@
+ALTER TABLE "public"."account"
+  DROP CONSTRAINT "account_status_check";
@@ -0,0 +1,7 @@
+-- statuses outside of the lifecycle were never enforced, treat them as active
+UPDATE "public"."account"
+SET "status" = 'active'
+WHERE "status" NOT IN ('pending', 'active', 'suspended', 'deleted');
+
+ALTER TABLE "public"."account"
+  ADD CONSTRAINT "account_status_check" CHECK ("status" IN ('pending', 'active', 'suspended', 'deleted'));
@@ -0,0 +1,3 @@
+DROP TABLE "public"."audit_events";
+
+DROP FUNCTION audit_events_prevent_change();
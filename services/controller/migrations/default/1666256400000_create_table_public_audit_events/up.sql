@@ -0,0 +1,43 @@
+CREATE TABLE "public"."audit_events"
+(
+    "id"          text        NOT NULL DEFAULT gen_random_uuid(),
+    "actor_id"    text,
+    "actor_role"  text        NOT NULL,
+    "action"      text        NOT NULL,
+    "target_type" text,
+    "target_id"   text,
+    "ip"          text,
+    "user_agent"  text,
+    "outcome"     text        NOT NULL,
+    "error"       text,
+    "metadata"    jsonb,
+    "created_at"  timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY ("id"),
+    CONSTRAINT "audit_events_outcome_check" CHECK ("outcome" IN ('success', 'failure'))
+);
+
+-- the actor isn't a foreign key so the history survives account deletion
+CREATE INDEX audit_events_created_at_idx
+  ON "public"."audit_events"("created_at");
+CREATE INDEX audit_events_actor_id_idx
+  ON "public"."audit_events"("actor_id", "created_at");
+CREATE INDEX audit_events_target_idx
+  ON "public"."audit_events"("target_type", "target_id", "created_at");
+CREATE INDEX audit_events_action_idx
+  ON "public"."audit_events"("action", "created_at");
+
+-- audit events are append-only
+CREATE OR REPLACE FUNCTION audit_events_prevent_change()
+RETURNS TRIGGER AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_prevent_change
+  BEFORE UPDATE OR DELETE ON "public"."audit_events"
+  FOR EACH ROW EXECUTE PROCEDURE audit_events_prevent_change();
+
+CREATE TRIGGER audit_events_prevent_truncate
+  BEFORE TRUNCATE ON "public"."audit_events"
+  FOR EACH STATEMENT EXECUTE PROCEDURE audit_events_prevent_change();
@@ -0,0 +1,3 @@
+DROP INDEX "public"."account_purge_after_idx";
+
+ALTER TABLE "public"."account" DROP COLUMN "purge_after";
@@ -0,0 +1,6 @@
+-- deleted accounts are purged after the grace period
+ALTER TABLE "public"."account" ADD COLUMN "purge_after" timestamptz NULL;
+
+CREATE INDEX account_purge_after_idx
+  ON "public"."account"("purge_after")
+  WHERE "purge_after" IS NOT NULL;
@@ -0,0 +1,15 @@
+ALTER TABLE "public"."shares"
+  DROP CONSTRAINT "shares_accountId_fkey",
+  DROP CONSTRAINT "shares_fileId_fkey",
+  DROP CONSTRAINT "shares_createdBy_fkey",
+  DROP CONSTRAINT "shares_updatedBy_fkey",
+  ADD CONSTRAINT "shares_accountId_fkey" FOREIGN KEY ("accountId") REFERENCES "public"."account" ("id") ON UPDATE restrict ON DELETE restrict,
+  ADD CONSTRAINT "shares_fileId_fkey" FOREIGN KEY ("fileId") REFERENCES "public"."files" ("id") ON UPDATE restrict ON DELETE restrict,
+  ADD CONSTRAINT "shares_createdBy_fkey" FOREIGN KEY ("createdBy") REFERENCES "public"."account" ("id") ON UPDATE restrict ON DELETE restrict,
+  ADD CONSTRAINT "shares_updatedBy_fkey" FOREIGN KEY ("updatedBy") REFERENCES "public"."account" ("id") ON UPDATE restrict ON DELETE restrict;
+
+ALTER TABLE "public"."files"
+  DROP CONSTRAINT "files_createdBy_fkey",
+  DROP CONSTRAINT "files_updatedBy_fkey",
+  ADD CONSTRAINT "files_createdBy_fkey" FOREIGN KEY ("createdBy") REFERENCES "public"."account" ("id") ON UPDATE restrict ON DELETE restrict,
+  ADD CONSTRAINT "files_updatedBy_fkey" FOREIGN KEY ("updatedBy") REFERENCES "public"."account" ("id") ON UPDATE restrict ON DELETE restrict;
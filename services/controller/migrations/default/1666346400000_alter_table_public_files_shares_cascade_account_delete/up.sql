@@ -0,0 +1,17 @@
+-- purging an account removes the files and shares it owns,
+-- and clears it from the audit columns of other records
+ALTER TABLE "public"."files"
+  DROP CONSTRAINT "files_createdBy_fkey",
+  DROP CONSTRAINT "files_updatedBy_fkey",
+  ADD CONSTRAINT "files_createdBy_fkey" FOREIGN KEY ("createdBy") REFERENCES "public"."account" ("id") ON UPDATE restrict ON DELETE cascade,
+  ADD CONSTRAINT "files_updatedBy_fkey" FOREIGN KEY ("updatedBy") REFERENCES "public"."account" ("id") ON UPDATE restrict ON DELETE set null;
+
+ALTER TABLE "public"."shares"
+  DROP CONSTRAINT "shares_accountId_fkey",
+  DROP CONSTRAINT "shares_fileId_fkey",
+  DROP CONSTRAINT "shares_createdBy_fkey",
+  DROP CONSTRAINT "shares_updatedBy_fkey",
+  ADD CONSTRAINT "shares_accountId_fkey" FOREIGN KEY ("accountId") REFERENCES "public"."account" ("id") ON UPDATE restrict ON DELETE cascade,
+  ADD CONSTRAINT "shares_fileId_fkey" FOREIGN KEY ("fileId") REFERENCES "public"."files" ("id") ON UPDATE restrict ON DELETE cascade,
+  ADD CONSTRAINT "shares_createdBy_fkey" FOREIGN KEY ("createdBy") REFERENCES "public"."account" ("id") ON UPDATE restrict ON DELETE set null,
+  ADD CONSTRAINT "shares_updatedBy_fkey" FOREIGN KEY ("updatedBy") REFERENCES "public"."account" ("id") ON UPDATE restrict ON DELETE set null;
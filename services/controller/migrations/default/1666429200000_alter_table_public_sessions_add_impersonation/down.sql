@@ -0,0 +1,7 @@
+DROP INDEX "public"."sessions_impersonator_id_idx";
+
+ALTER TABLE "public"."sessions"
+  DROP CONSTRAINT "sessions_impersonation_reason_check",
+  DROP CONSTRAINT "sessions_impersonator_id_fkey",
+  DROP COLUMN "impersonation_reason",
+  DROP COLUMN "impersonator_id";
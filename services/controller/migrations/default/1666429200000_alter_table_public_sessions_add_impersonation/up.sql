@@ -0,0 +1,9 @@
+-- impersonation sessions are started by an admin on behalf of the account
+ALTER TABLE "public"."sessions"
+  ADD COLUMN "impersonator_id"      text NULL,
+  ADD COLUMN "impersonation_reason" text NULL,
+  ADD CONSTRAINT "sessions_impersonator_id_fkey" FOREIGN KEY ("impersonator_id") REFERENCES "public"."account" ("id") ON UPDATE restrict ON DELETE cascade,
+  ADD CONSTRAINT "sessions_impersonation_reason_check" CHECK ("impersonator_id" IS NULL OR "impersonation_reason" IS NOT NULL);
+
+CREATE INDEX sessions_impersonator_id_idx
+  ON "public"."sessions"("impersonator_id") WHERE "impersonator_id" IS NOT NULL;
@@ -0,0 +1,19 @@
+CREATE TABLE "public"."magic_links"
+(
+    "id"          text        NOT NULL DEFAULT gen_random_uuid(),
+    "account_id"  text        NOT NULL,
+    -- hash of the device token when the link must be opened on the requesting device
+    "device_hash" text,
+    "ip"          text,
+    "created_at"  timestamptz NOT NULL DEFAULT now(),
+    "expires_at"  timestamptz NOT NULL,
+    "used_at"     timestamptz,
+    PRIMARY KEY ("id"),
+    FOREIGN KEY ("account_id") REFERENCES "public"."account" ("id") ON UPDATE restrict ON DELETE cascade
+);
+
+CREATE INDEX magic_links_account_id_created_at_idx
+  ON "public"."magic_links"("account_id", "created_at");
+
+CREATE INDEX magic_links_ip_created_at_idx
+  ON "public"."magic_links"("ip", "created_at");
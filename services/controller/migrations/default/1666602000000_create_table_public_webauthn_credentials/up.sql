@@ -0,0 +1,22 @@
+CREATE TABLE "public"."webauthn_credentials"
+(
+    "id"              text        NOT NULL DEFAULT gen_random_uuid(),
+    "account_id"      text        NOT NULL,
+    -- base64url credential id and COSE public key of the authenticator
+    "credential_id"   text        NOT NULL,
+    "public_key"      text        NOT NULL,
+    "sign_count"      bigint      NOT NULL DEFAULT 0,
+    "aaguid"          text,
+    "name"            text        NOT NULL,
+    "backup_eligible" boolean     NOT NULL DEFAULT false,
+    -- passkeys are the second factor of password logins only if the user opts in at registration
+    "second_factor"   boolean     NOT NULL DEFAULT false,
+    "created_at"      timestamptz NOT NULL DEFAULT now(),
+    "last_used_at"    timestamptz,
+    PRIMARY KEY ("id"),
+    UNIQUE ("credential_id"),
+    FOREIGN KEY ("account_id") REFERENCES "public"."account" ("id") ON UPDATE restrict ON DELETE cascade
+);
+
+CREATE INDEX webauthn_credentials_account_id_idx
+  ON "public"."webauthn_credentials"("account_id");
@@ -0,0 +1,12 @@
+-- jti of single-use tokens, the primary key rejects a second use on any instance
+CREATE TABLE "public"."used_tokens"
+(
+    "jti"        text        NOT NULL,
+    "audience"   text        NOT NULL,
+    "expires_at" timestamptz NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY ("jti")
+);
+
+CREATE INDEX used_tokens_expires_at_idx
+  ON "public"."used_tokens"("expires_at");
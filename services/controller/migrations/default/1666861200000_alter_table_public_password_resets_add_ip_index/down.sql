@@ -0,0 +1 @@
+DROP INDEX "public"."password_resets_ip_created_at_idx";
@@ -0,0 +1,3 @@
+-- password reset requests are rate limited by IP address
+CREATE INDEX password_resets_ip_created_at_idx
+  ON "public"."password_resets"("ip", "created_at");
@@ -0,0 +1 @@
+DROP INDEX "public"."login_attempts_created_at_idx";
@@ -0,0 +1,3 @@
+-- old login attempts are pruned on a schedule
+CREATE INDEX login_attempts_created_at_idx
+  ON "public"."login_attempts"("created_at");
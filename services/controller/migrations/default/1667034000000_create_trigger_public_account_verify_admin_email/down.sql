@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS account_verify_admin_email ON "public"."account";
+DROP FUNCTION IF EXISTS account_verify_admin_email();
@@ -0,0 +1,18 @@
+-- admins are created out of band and are verified when they are inserted,
+-- the unverified account policy applies to every role
+UPDATE "public"."account" SET "email_verified_at" = now()
+  WHERE "role" = 'admin' AND "email_verified_at" IS NULL;
+
+CREATE OR REPLACE FUNCTION account_verify_admin_email()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF NEW."role" = 'admin' AND NEW."email_verified_at" IS NULL THEN
+    NEW."email_verified_at" = now();
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER account_verify_admin_email
+  BEFORE INSERT ON "public"."account"
+  FOR EACH ROW EXECUTE PROCEDURE account_verify_admin_email();
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS files_queue_object_deletion ON "public"."files";
+DROP FUNCTION IF EXISTS files_queue_object_deletion();
+DROP TABLE "public"."file_object_deletions";
@@ -0,0 +1,28 @@
+-- stored objects of deleted files, e.g. files of purged accounts.
+-- The rows are removed by the storage cleanup once the objects are deleted
+CREATE TABLE "public"."file_object_deletions"
+(
+    "id"         text        NOT NULL DEFAULT gen_random_uuid(),
+    "file_id"    text        NOT NULL,
+    "url"        text        NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY ("id")
+);
+
+CREATE INDEX file_object_deletions_created_at_idx
+  ON "public"."file_object_deletions"("created_at");
+
+-- the trigger runs for cascade deletes too, so no object is left behind by the account purge
+CREATE OR REPLACE FUNCTION files_queue_object_deletion()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF OLD."url" <> '' THEN
+    INSERT INTO "public"."file_object_deletions"("file_id", "url") VALUES (OLD."id", OLD."url");
+  END IF;
+  RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER files_queue_object_deletion
+  AFTER DELETE ON "public"."files"
+  FOR EACH ROW EXECUTE PROCEDURE files_queue_object_deletion();